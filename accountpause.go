@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"log"
+	"sync"
+	"time"
+)
+
+// defaultAccountPauseCheckInterval is how often SES is asked whether the paused
+// account sends again when SES_PAUSE_CHECK_INTERVAL is not set.
+const defaultAccountPauseCheckInterval = time.Minute
+
+// isAccountSendingPaused reports whether SES refused the send because sending
+// of the whole account is paused, e.g. after a review of its bounce rate.
+func isAccountSendingPaused(err error) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == ses.ErrCodeAccountSendingPausedException
+}
+
+// sendingStatusProvider is the part of the SES client reporting whether the
+// account may send.
+type sendingStatusProvider interface {
+	GetAccountSendingEnabled(input *ses.GetAccountSendingEnabledInput) (*ses.GetAccountSendingEnabledOutput, error)
+}
+
+// accountPause holds all sending back once SES reported the account paused,
+// retrying the emails is futile until it is resumed. SES is asked on the
+// interval whether the account sends again.
+type accountPause struct {
+	provider sendingStatusProvider
+	interval time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	paused  bool
+	checked time.Time
+}
+
+func newAccountPause(provider sendingStatusProvider, interval time.Duration) *accountPause {
+	if interval <= 0 {
+		interval = defaultAccountPauseCheckInterval
+	}
+	return &accountPause{provider: provider, interval: interval, now: time.Now}
+}
+
+// pause holds the sending back until SES reports the account enabled again.
+func (p *accountPause) pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.paused {
+		log.Println("SES account sending is paused, all sending is held back until it is resumed")
+	}
+	p.paused = true
+	p.checked = p.now()
+}
+
+// isPaused reports whether the sending is held back, it is false for nil.
+func (p *accountPause) isPaused() bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
+
+// reserveSend returns how long to wait before the account is checked again,
+// it is 0 once the account is known to send.
+func (p *accountPause) reserveSend() time.Duration {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.paused {
+		return 0
+	}
+	if wait := p.checked.Add(p.interval).Sub(p.now()); wait > 0 {
+		return wait
+	}
+	p.checked = p.now()
+	status, err := p.provider.GetAccountSendingEnabled(&ses.GetAccountSendingEnabledInput{})
+	if err != nil {
+		log.Println("account sending status could not be checked", err)
+		return p.interval
+	}
+	if !aws.BoolValue(status.Enabled) {
+		return p.interval
+	}
+	log.Println("SES account sending is resumed")
+	p.paused = false
+	return 0
+}
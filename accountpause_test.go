@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/streadway/amqp"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+// fakeSendingStatus reports the account enabled once it was asked enabledAfter times.
+type fakeSendingStatus struct {
+	enabledAfter int
+	checks       int
+}
+
+func (s *fakeSendingStatus) GetAccountSendingEnabled(input *ses.GetAccountSendingEnabledInput) (*ses.GetAccountSendingEnabledOutput, error) {
+	s.checks++
+	return &ses.GetAccountSendingEnabledOutput{Enabled: aws.Bool(s.checks >= s.enabledAfter)}, nil
+}
+
+func TestAccountSendingPausedPausesConsumer(t *testing.T) {
+	log.SetOutput(&bytes.Buffer{})
+	defer log.SetOutput(os.Stderr)
+
+	sender := &fakeSES{err: awserr.New(ses.ErrCodeAccountSendingPausedException, "Sending is paused for this account.", nil)}
+	m := newTestMailer(&config{maxRetries: 1}, sender)
+	m.retryDelay = time.Hour
+	status := &fakeSendingStatus{enabledAfter: 2}
+	m.accountPause = newAccountPause(status, 10*time.Millisecond)
+	body := []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body"}`)
+
+	acknowledger := &fakeAcknowledger{}
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: body})
+	if acknowledger.requeued != 1 || len(m.channel.(*fakeChannel).published) != 0 {
+		t.Fatal("email must be requeued, not dead-lettered", acknowledger)
+	}
+	if !m.accountPause.isPaused() || !m.stats.report().AccountPaused {
+		t.Fatal("consumer must be paused globally", m.stats.report())
+	}
+
+	// another email waits for the account until SES reports it enabled
+	sender.err = nil
+	start := time.Now()
+	acknowledger = &fakeAcknowledger{}
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: body})
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Fatal("email must wait while the account is paused", elapsed)
+	}
+	if status.checks != 2 {
+		t.Fatal("account must be checked periodically", status.checks)
+	}
+	if acknowledger.acked != 1 || len(sender.inputs) != 2 {
+		t.Fatal("email must be sent once the account is resumed", acknowledger, len(sender.inputs))
+	}
+	if m.accountPause.isPaused() || m.stats.report().AccountPaused {
+		t.Fatal("consumer must be resumed", m.stats.report())
+	}
+}
+
+func TestIsAccountSendingPaused(t *testing.T) {
+	paused := errAWSSendingEmail{err: awserr.New(ses.ErrCodeAccountSendingPausedException, "Sending is paused for this account.", nil)}
+	if !isAccountSendingPaused(paused) {
+		t.Fatal("wrapped AccountSendingPausedException must be detected")
+	}
+	if isAccountSendingPaused(errAWSSendingEmail{err: awserr.New(ses.ErrCodeMessageRejected, "Email address is not verified.", nil)}) {
+		t.Fatal("other errors must not pause the account")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// arcMaxInstances is the highest ARC instance number, RFC 8617 4.2.1.
+const arcMaxInstances = 50
+
+// arcHeaderNames are the headers of an ARC set, every instance has one of each.
+var arcHeaderNames = []string{"ARC-Seal", "ARC-Message-Signature", "ARC-Authentication-Results"}
+
+// arcHeader is a pre-computed header of the Authenticated Received Chain, the
+// mailer doesn't seal the messages itself.
+type arcHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// validateARCHeaders checks the ARC headers are known, can be written as they
+// are and form complete sets numbered from 1 without gaps.
+func (e *email) validateARCHeaders() error {
+	sets := map[int]map[string]bool{}
+	for _, header := range e.ARCHeaders {
+		name := arcHeaderName(header.Name)
+		if name == "" {
+			return errInvalidEmail{fmt.Errorf(`"%s" is not ARC header`, header.Name)}
+		}
+		if strings.ContainsAny(header.Value, "\r\n") {
+			return errInvalidEmail{fmt.Errorf(`"%s" header must not contain line breaks`, name)}
+		}
+		instance, err := arcInstance(header.Value)
+		if err != nil {
+			return errInvalidEmail{fmt.Errorf(`"%s" header: %v`, name, err)}
+		}
+		if sets[instance] == nil {
+			sets[instance] = map[string]bool{}
+		}
+		if sets[instance][name] {
+			return errInvalidEmail{fmt.Errorf(`"%s" header is repeated in ARC set %d`, name, instance)}
+		}
+		sets[instance][name] = true
+	}
+	for instance := 1; instance <= len(sets); instance++ {
+		for _, name := range arcHeaderNames {
+			if !sets[instance][name] {
+				return errInvalidEmail{fmt.Errorf(`"%s" header is missing in ARC set %d`, name, instance)}
+			}
+		}
+	}
+	return nil
+}
+
+// arcHeaderName returns the canonical name of the ARC header, empty for the other headers.
+func arcHeaderName(name string) string {
+	for _, arcName := range arcHeaderNames {
+		if strings.EqualFold(strings.TrimSpace(name), arcName) {
+			return arcName
+		}
+	}
+	return ""
+}
+
+// arcInstance returns the i= tag of the ARC header value.
+func arcInstance(value string) (int, error) {
+	for _, tag := range strings.Split(value, ";") {
+		parts := strings.SplitN(tag, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) != "i" {
+			continue
+		}
+		instance, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || instance < 1 || instance > arcMaxInstances {
+			return 0, errInvalidEmail{fmt.Errorf(`"%s" is not valid instance`, strings.TrimSpace(parts[1]))}
+		}
+		return instance, nil
+	}
+	return 0, errInvalidEmail{fmt.Errorf("instance tag is missing")}
+}
+
+// prependARCHeaders writes the ARC headers in the given order on top of the
+// raw message, gomail writes its headers in no particular order.
+func prependARCHeaders(raw []byte, headers []arcHeader) []byte {
+	if len(headers) == 0 {
+		return raw
+	}
+	var buf bytes.Buffer
+	for _, header := range headers {
+		fmt.Fprintf(&buf, "%s: %s\r\n", arcHeaderName(header.Name), strings.TrimSpace(header.Value))
+	}
+	buf.Write(raw)
+	return buf.Bytes()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func arcSet(instance string) []arcHeader {
+	return []arcHeader{
+		{Name: "ARC-Seal", Value: "i=" + instance + "; a=rsa-sha256; cv=none; d=example.org; s=arc; b=c2VhbA=="},
+		{Name: "ARC-Message-Signature", Value: "i=" + instance + "; a=rsa-sha256; d=example.org; s=arc; h=from:to:subject; bh=Ym9keQ==; b=c2ln"},
+		{Name: "ARC-Authentication-Results", Value: "i=" + instance + "; mx.example.org; spf=pass smtp.mailfrom=example.com"},
+	}
+}
+
+func TestARCHeadersAreIncludedInOrder(t *testing.T) {
+	e := &email{
+		To:         "to@test.com",
+		Subject:    "Fwd: Wow",
+		TextBody:   "text body",
+		ARCHeaders: append(arcSet("2"), arcSet("1")...),
+	}
+	if err := e.validateARCHeaders(); err != nil {
+		t.Fatal(err)
+	}
+	raw := string(createEmail(emailOptions{from: "from@someone.com"}, e).RawMessage.Data)
+
+	var names []string
+	for _, line := range strings.Split(raw[:strings.Index(raw, "\r\n\r\n")], "\r\n") {
+		if strings.HasPrefix(line, "ARC-") {
+			names = append(names, line)
+		}
+	}
+	if len(names) != 6 {
+		t.Fatal("ARC headers", names)
+	}
+	for i, header := range e.ARCHeaders {
+		if names[i] != header.Name+": "+header.Value {
+			t.Fatal(i, "header", names[i])
+		}
+	}
+	if !strings.HasPrefix(raw, "ARC-Seal: i=2;") {
+		t.Fatal("ARC headers must be on top of the message", raw)
+	}
+}
+
+func TestValidateARCHeaders(t *testing.T) {
+	incomplete := arcSet("1")[:2]
+	gap := append(arcSet("1"), arcSet("3")...)
+	repeated := append(arcSet("1"), arcSet("1")[0])
+	unknown := append(arcSet("1"), arcHeader{Name: "DKIM-Signature", Value: "i=1"})
+	injected := arcSet("1")
+	injected[2].Value += "\r\nBcc: victim@test.com"
+	noInstance := arcSet("1")
+	noInstance[0].Value = "a=rsa-sha256; cv=none"
+	for _, headers := range [][]arcHeader{incomplete, gap, repeated, unknown, injected, noInstance, arcSet("51")} {
+		e := &email{ARCHeaders: headers}
+		var invalidErr errInvalidEmail
+		if err := e.validateARCHeaders(); !errors.As(err, &invalidErr) {
+			t.Fatal(headers, "must reject the email", err)
+		}
+	}
+
+	lowercase := arcSet("1")
+	lowercase[0].Name = "arc-seal"
+	e := &email{ARCHeaders: lowercase}
+	if err := e.validateARCHeaders(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"sync"
+)
+
+// attachmentCache keeps the decoded content of the recent inline attachments by
+// the hash of their encoded content, so a file shared by a batch of emails is
+// decoded once. The least recently used entries are evicted above maxBytes.
+type attachmentCache struct {
+	mu       sync.Mutex
+	maxBytes int
+	size     int
+	entries  map[[sha256.Size]byte]*list.Element
+	order    *list.List
+	decodes  int
+}
+
+type attachmentCacheEntry struct {
+	key     [sha256.Size]byte
+	content []byte
+}
+
+func newAttachmentCache(maxBytes int) *attachmentCache {
+	return &attachmentCache{
+		maxBytes: maxBytes,
+		entries:  map[[sha256.Size]byte]*list.Element{},
+		order:    list.New(),
+	}
+}
+
+// decode returns the decoded content, it must not be modified as it is shared
+// between the emails. The cache is bypassed when it is nil.
+func (c *attachmentCache) decode(encoded string) ([]byte, error) {
+	if c == nil {
+		return base64.StdEncoding.DecodeString(encoded)
+	}
+	key := sha256.Sum256([]byte(encoded))
+
+	c.mu.Lock()
+	if element, ok := c.entries[key]; ok {
+		c.order.MoveToFront(element)
+		c.mu.Unlock()
+		return element.Value.(*attachmentCacheEntry).content, nil
+	}
+	c.mu.Unlock()
+
+	content, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.decodes++
+	if _, ok := c.entries[key]; ok || len(content) > c.maxBytes {
+		return content, nil
+	}
+	c.entries[key] = c.order.PushFront(&attachmentCacheEntry{key: key, content: content})
+	c.size += len(content)
+	for c.size > c.maxBytes {
+		oldest := c.order.Back()
+		entry := oldest.Value.(*attachmentCacheEntry)
+		c.order.Remove(oldest)
+		delete(c.entries, entry.key)
+		c.size -= len(entry.content)
+	}
+	return content, nil
+}
+
+// decodeAttachments decodes the inline attachments of the email ahead of building it.
+func decodeAttachments(cache *attachmentCache, e *email) error {
+	for i, attach := range e.Attaches {
+		if attach.content != nil || len(attach.URL) > 0 {
+			continue
+		}
+		content, err := cache.decode(attach.FileContentBase64Encoded)
+		if err != nil {
+			return fmt.Errorf(`attachment "%s" is not valid base64: %v`, attach.FileName, err)
+		}
+		e.Attaches[i].content = content
+	}
+	return nil
+}
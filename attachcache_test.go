@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/base64"
+	"github.com/streadway/amqp"
+	"strings"
+	"testing"
+)
+
+func TestSharedAttachmentIsDecodedOnce(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{}, sender)
+	m.attachments = newAttachmentCache(1024 * 1024)
+	encoded := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("%PDF-1.4 shared report\n", 100)))
+	body := []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body","attaches":[{"file_name":"report.pdf","file_content_base64_encoded":"` + encoded + `"}]}`)
+
+	for i := 0; i < 3; i++ {
+		m.handle(amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: body})
+	}
+
+	if m.attachments.decodes != 1 {
+		t.Fatal("shared attachment must be decoded once", m.attachments.decodes)
+	}
+	if len(sender.inputs) != 3 {
+		t.Fatal("SES calls", len(sender.inputs))
+	}
+	for _, input := range sender.inputs {
+		if !strings.Contains(string(input.RawMessage.Data), `filename="report.pdf"`) {
+			t.Fatal("attachment must be sent with every email", string(input.RawMessage.Data))
+		}
+	}
+}
+
+func TestAttachmentCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newAttachmentCache(8)
+	first := base64.StdEncoding.EncodeToString([]byte("1234"))
+	second := base64.StdEncoding.EncodeToString([]byte("5678"))
+	third := base64.StdEncoding.EncodeToString([]byte("9012"))
+
+	cache.decode(first)
+	cache.decode(second)
+	cache.decode(first)
+	cache.decode(third)
+	cache.decode(first)
+	cache.decode(second)
+
+	// second was evicted by third, first was kept as recently used
+	if cache.decodes != 4 || cache.size > 8 {
+		t.Fatal("decodes", cache.decodes, "size", cache.size)
+	}
+}
+
+func TestDecodeAttachmentsRejectsInvalidBase64(t *testing.T) {
+	e := &email{Attaches: []emailAttach{{FileName: "broken.pdf", FileContentBase64Encoded: "not base64!"}}}
+
+	err := decodeAttachments(newAttachmentCache(1024), e)
+
+	if err == nil || !strings.Contains(err.Error(), `attachment "broken.pdf" is not valid base64`) {
+		t.Fatal("invalid base64 must be rejected", err)
+	}
+}
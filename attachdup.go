@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// duplicateAttachmentReject rejects the emails with two attachments of one file name.
+	duplicateAttachmentReject = "reject"
+	// duplicateAttachmentRename renames the later attachments of one file name to "file (1).pdf".
+	duplicateAttachmentRename = "rename"
+)
+
+// validateAttachmentNames fails when two attachments have the same file name,
+// ignoring case, some clients show only one of them.
+func (e *email) validateAttachmentNames() error {
+	seen := map[string]bool{}
+	for _, attach := range e.Attaches {
+		name := strings.ToLower(attach.FileName)
+		if seen[name] {
+			return errInvalidEmail{fmt.Errorf(`attachment "%s" is used twice`, attach.FileName)}
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// renameDuplicateAttachments numbers the file names used more than once, the
+// first attachment keeps its name and no other file name is taken over.
+func (e *email) renameDuplicateAttachments() {
+	names := map[string]bool{}
+	for _, attach := range e.Attaches {
+		names[strings.ToLower(attach.FileName)] = true
+	}
+	taken := map[string]bool{}
+	for i, attach := range e.Attaches {
+		if !taken[strings.ToLower(attach.FileName)] {
+			taken[strings.ToLower(attach.FileName)] = true
+			continue
+		}
+		ext := filepath.Ext(attach.FileName)
+		base := strings.TrimSuffix(attach.FileName, ext)
+		for n := 1; ; n++ {
+			renamed := fmt.Sprintf("%s (%d)%s", base, n, ext)
+			if !taken[strings.ToLower(renamed)] && !names[strings.ToLower(renamed)] {
+				taken[strings.ToLower(renamed)] = true
+				e.Attaches[i].FileName = renamed
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"github.com/streadway/amqp"
+	"strings"
+	"testing"
+)
+
+const duplicateAttachmentsBody = `{"to":"to@test.com","subject":"Wow","text_body":"text body","attaches":[{"file_name":"a.txt","file_content_base64_encoded":"YQ=="},{"file_name":"A.TXT","file_content_base64_encoded":"Yg=="}]}`
+
+func TestDuplicateAttachmentNamesAreRejected(t *testing.T) {
+	e := &email{To: "to@test.com", Subject: "Wow", TextBody: "text body", Attaches: []emailAttach{
+		{FileName: "report.pdf", FileContentBase64Encoded: "JVBERi0="},
+		{FileName: "Report.PDF", FileContentBase64Encoded: "JVBERi0="},
+	}}
+	m := newTestMailer(&config{duplicateAttachmentPolicy: duplicateAttachmentReject}, &fakeSES{})
+
+	err := m.validate(e)
+
+	if err == nil || err.Error() != `attachment "Report.PDF" is used twice` {
+		t.Fatal("duplicate attachment names must be rejected", err)
+	}
+
+	sender := &fakeSES{}
+	m.ses = sender
+	acknowledger := &fakeAcknowledger{}
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: []byte(duplicateAttachmentsBody)})
+	if len(sender.inputs) != 0 || acknowledger.nacked != 1 || acknowledger.requeued != 0 {
+		t.Fatal("email must be rejected without requeue", len(sender.inputs), acknowledger)
+	}
+}
+
+func TestDuplicateAttachmentNamesAreNotCheckedByDefault(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{}, sender)
+	acknowledger := &fakeAcknowledger{}
+
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: []byte(duplicateAttachmentsBody)})
+
+	if acknowledger.acked != 1 || len(sender.inputs) != 1 {
+		t.Fatal("email with duplicate attachment names must be sent as it is", acknowledger, len(sender.inputs))
+	}
+}
+
+func TestDuplicateAttachmentNamesAreRenamed(t *testing.T) {
+	e := &email{Attaches: []emailAttach{
+		{FileName: "report.pdf"},
+		{FileName: "report (1).pdf"},
+		{FileName: "Report.pdf"},
+		{FileName: "report.pdf"},
+		{FileName: "notes"},
+		{FileName: "notes"},
+	}}
+
+	e.renameDuplicateAttachments()
+
+	var names []string
+	for _, attach := range e.Attaches {
+		names = append(names, attach.FileName)
+	}
+	if strings.Join(names, "|") != "report.pdf|report (1).pdf|Report (2).pdf|report (3).pdf|notes|notes (1)" {
+		t.Fatal("duplicates must be numbered", names)
+	}
+	if err := e.validateAttachmentNames(); err != nil {
+		t.Fatal("renamed attachments must be valid", err)
+	}
+}
+
+func TestRenameModeSendsDuplicates(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{duplicateAttachmentPolicy: duplicateAttachmentRename}, sender)
+
+	m.handle(amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body","attaches":[{"file_name":"a.txt","file_content_base64_encoded":"YQ=="},{"file_name":"a.txt","file_content_base64_encoded":"Yg=="}]}`)})
+
+	if len(sender.inputs) != 1 || !strings.Contains(string(sender.inputs[0].RawMessage.Data), `filename="a (1).txt"`) {
+		t.Fatal("renamed duplicate must be attached")
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+const (
+	// emptyAttachmentReject rejects the emails with an attachment without content.
+	emptyAttachmentReject = "reject"
+	// emptyAttachmentSkip sends the emails without their attachments without content.
+	emptyAttachmentSkip = "skip"
+)
+
+// isEmpty reports whether the attachment has a file name but nothing to attach,
+// the attachments given by URL are only known to be empty once fetched.
+func (a emailAttach) isEmpty() bool {
+	return len(a.URL) == 0 && len(a.FileContentBase64Encoded) == 0 && len(a.content) == 0
+}
+
+// validateAttachmentContents fails on the first attachment without content,
+// it would be sent as a zero-byte file.
+func (e *email) validateAttachmentContents() error {
+	for _, attach := range e.Attaches {
+		if attach.isEmpty() {
+			return errInvalidEmail{fmt.Errorf(`attachment "%s" has no content`, attach.FileName)}
+		}
+	}
+	return nil
+}
+
+// skipEmptyAttachments drops the attachments without content from the email.
+func (e *email) skipEmptyAttachments() {
+	attaches := e.Attaches[:0]
+	for _, attach := range e.Attaches {
+		if attach.isEmpty() {
+			log.Printf(`attachment "%s" is skipped, it has no content`, attach.FileName)
+			continue
+		}
+		attaches = append(attaches, attach)
+	}
+	e.Attaches = attaches
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"github.com/streadway/amqp"
+	"strings"
+	"testing"
+)
+
+const emptyAttachmentBody = `{"to":"to@test.com","subject":"Wow","text_body":"text body","attaches":[{"file_name":"a.txt","file_content_base64_encoded":"YQ=="},{"file_name":"empty.txt","file_content_base64_encoded":""}]}`
+
+func TestEmptyAttachmentIsRejected(t *testing.T) {
+	e := &email{To: "to@test.com", Subject: "Wow", TextBody: "text body", Attaches: []emailAttach{
+		{FileName: "report.pdf", FileContentBase64Encoded: "JVBERi0="},
+		{FileName: "empty.pdf"},
+	}}
+	m := newTestMailer(&config{emptyAttachmentPolicy: emptyAttachmentReject}, &fakeSES{})
+
+	err := m.validate(e)
+
+	if err == nil || err.Error() != `attachment "empty.pdf" has no content` {
+		t.Fatal("empty attachment must be rejected", err)
+	}
+
+	sender := &fakeSES{}
+	m.ses = sender
+	acknowledger := &fakeAcknowledger{}
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: []byte(emptyAttachmentBody)})
+	if len(sender.inputs) != 0 || acknowledger.nacked != 1 || acknowledger.requeued != 0 {
+		t.Fatal("email must be rejected without requeue", len(sender.inputs), acknowledger)
+	}
+}
+
+func TestEmptyAttachmentIsSentByDefault(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{}, sender)
+	acknowledger := &fakeAcknowledger{}
+
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: []byte(emptyAttachmentBody)})
+
+	if acknowledger.acked != 1 || len(sender.inputs) != 1 {
+		t.Fatal("email must be sent as it is", acknowledger, len(sender.inputs))
+	}
+}
+
+func TestAttachmentByURLIsNotEmpty(t *testing.T) {
+	e := &email{To: "to@test.com", Subject: "Wow", TextBody: "text body", Attaches: []emailAttach{
+		{FileName: "report.pdf", URL: "https://files.test/report.pdf"},
+	}}
+	m := newTestMailer(&config{emptyAttachmentPolicy: emptyAttachmentReject}, &fakeSES{})
+
+	if err := m.validate(e); err != nil {
+		t.Fatal("attachment given by URL must be valid", err)
+	}
+}
+
+func TestSkipModeSendsWithoutEmptyAttachments(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{emptyAttachmentPolicy: emptyAttachmentSkip}, sender)
+
+	m.handle(amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: []byte(emptyAttachmentBody)})
+
+	if len(sender.inputs) != 1 {
+		t.Fatal("email must be sent")
+	}
+	filenames := attachmentFilenames(t, sender.inputs[0].RawMessage.Data)
+	if strings.Join(filenames, "|") != "a.txt" {
+		t.Fatal("empty attachment must be skipped", filenames)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+const gzipExtension = ".gz"
+
+// compressibleTypes are the media types compressed besides text/*, the binary
+// formats are mostly compressed already.
+var compressibleTypes = map[string]bool{
+	"application/json": true,
+	"application/xml":  true,
+}
+
+// isCompressible reports whether the attachment is a text file, e.g. a CSV
+// report, compressed under compress_attachments. The inline attachments are
+// shown in the body, so they are never compressed.
+func (a emailAttach) isCompressible() bool {
+	if a.Disposition == dispositionInline {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(mime.TypeByExtension(strings.ToLower(filepath.Ext(a.FileName))))
+	return strings.HasPrefix(mediaType, "text/") || compressibleTypes[mediaType]
+}
+
+// validateCompressedNames checks the names the compressed attachments get
+// aren't taken by other attachments of the email.
+func (e *email) validateCompressedNames() error {
+	if !e.CompressAttachments {
+		return nil
+	}
+	names := map[string]bool{}
+	for _, attach := range e.Attaches {
+		names[strings.ToLower(attach.FileName)] = true
+	}
+	for _, attach := range e.Attaches {
+		if attach.isCompressible() && names[strings.ToLower(attach.FileName+gzipExtension)] {
+			return errInvalidEmail{fmt.Errorf(`attachment "%s" can't be compressed, "%s" is attached too`, attach.FileName, attach.FileName+gzipExtension)}
+		}
+	}
+	return nil
+}
+
+// compressAttachments gzips the content of the text attachments and appends
+// .gz to their names, the content has to be fetched and decoded already.
+func (e *email) compressAttachments() error {
+	for i, attach := range e.Attaches {
+		if !attach.isCompressible() {
+			continue
+		}
+		var compressed bytes.Buffer
+		w := gzip.NewWriter(&compressed)
+		w.Name = attach.FileName
+		if _, err := w.Write(attach.content); err != nil {
+			return fmt.Errorf(`attachment "%s" could not be compressed: %v`, attach.FileName, err)
+		}
+		if err := w.Close(); err != nil {
+			return fmt.Errorf(`attachment "%s" could not be compressed: %v`, attach.FileName, err)
+		}
+		e.Attaches[i].FileName = attach.FileName + gzipExtension
+		e.Attaches[i].content = compressed.Bytes()
+	}
+	return nil
+}
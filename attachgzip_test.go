@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"github.com/streadway/amqp"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCompressCSVAttachment(t *testing.T) {
+	log.SetOutput(&bytes.Buffer{})
+	defer log.SetOutput(os.Stderr)
+
+	sender := &fakeSES{}
+	m := newTestMailer(&config{}, sender)
+	csv := strings.Repeat("id,name\n1,test\n", 100)
+	attaches := `[{"file_name":"report.csv","file_content_base64_encoded":"` + base64.StdEncoding.EncodeToString([]byte(csv)) + `"},{"file_name":"logo.png","file_content_base64_encoded":"dGVzdCBpcyBvawo="}]`
+	m.handle(amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body","compress_attachments":true,"attaches":` + attaches + `}`)})
+	if len(sender.inputs) != 1 {
+		t.Fatal("email must be sent")
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(sender.inputs[0].RawMessage.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := map[string]*multipart.Part{}
+	contents := map[string][]byte{}
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		if part.FileName() == "" {
+			continue
+		}
+		parts[part.FileName()] = part
+		// gomail base64 encodes the attachments
+		contents[part.FileName()], _ = ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, part))
+	}
+	part, ok := parts["report.csv.gz"]
+	if !ok {
+		t.Fatal("csv must be attached as report.csv.gz", parts)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type")); mediaType != "application/gzip" {
+		t.Fatal("content type", part.Header.Get("Content-Type"))
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(contents["report.csv.gz"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decompressed, err := ioutil.ReadAll(gz)
+	if err != nil || string(decompressed) != csv || gz.Name != "report.csv" {
+		t.Fatal("csv must be gzipped", gz.Name, err)
+	}
+	if _, ok := parts["logo.png"]; !ok {
+		t.Fatal("binary attachment must be kept as it is", parts)
+	}
+}
+
+func TestValidateCompressedNames(t *testing.T) {
+	e := &email{
+		CompressAttachments: true,
+		Attaches: []emailAttach{
+			{FileName: "report.csv", FileContentBase64Encoded: "dGVzdCBpcyBvawo="},
+			{FileName: "report.csv.gz", FileContentBase64Encoded: "dGVzdCBpcyBvawo="},
+		},
+	}
+	var invalidErr errInvalidEmail
+	if err := e.validateCompressedNames(); !errors.As(err, &invalidErr) {
+		t.Fatal("compressed name taken by another attachment must reject the email", err)
+	}
+	e.CompressAttachments = false
+	if err := e.validateCompressedNames(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	attachmentFetchTimeout = 30 * time.Second
+
+	// attachmentFetchFail fails the whole email when an attachment could not be fetched.
+	attachmentFetchFail = "fail"
+	// attachmentFetchDegrade sends the email without the attachments which could not be fetched.
+	attachmentFetchDegrade = "degrade"
+
+	// defaultAttachmentFetchConcurrency is how many attachments of an email are
+	// downloaded at once when ATTACHMENT_FETCH_CONCURRENCY is not set.
+	defaultAttachmentFetchConcurrency = 4
+
+	// defaultMaxAttachmentBytes bounds a downloaded attachment when MAX_EMAIL_SIZE
+	// is not set, SES doesn't send larger messages anyway.
+	defaultMaxAttachmentBytes = 10 * 1024 * 1024
+)
+
+// attachmentFetcher downloads the content of the attachments given by URL.
+type attachmentFetcher interface {
+	fetch(ctx context.Context, url string) ([]byte, error)
+	// size returns the content length without downloading, -1 when it is unknown.
+	size(ctx context.Context, url string) (int64, error)
+}
+
+type httpAttachmentFetcher struct {
+	client *http.Client
+	// limit is the largest attachment in bytes which is downloaded.
+	limit int64
+}
+
+func newHTTPAttachmentFetcher(limit int) *httpAttachmentFetcher {
+	if limit <= 0 {
+		limit = defaultMaxAttachmentBytes
+	}
+	return &httpAttachmentFetcher{client: &http.Client{Timeout: attachmentFetchTimeout}, limit: int64(limit)}
+}
+
+func (f *httpAttachmentFetcher) fetch(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, f.limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > f.limit {
+		return nil, errInvalidEmail{fmt.Errorf("attachment is larger than %d bytes", f.limit)}
+	}
+	return content, nil
+}
+
+func (f *httpAttachmentFetcher) size(ctx context.Context, url string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.ContentLength, nil
+}
+
+// estimateEmailSize approximates the size of the raw message before the remote
+// attachments are downloaded, their size is taken from the HEAD response. The
+// attachments whose size is unknown are not counted.
+func estimateEmailSize(ctx context.Context, fetcher attachmentFetcher, e *email) int64 {
+	if len(e.rawMessage) > 0 {
+		return int64(len(e.rawMessage))
+	}
+	size := int64(len(e.Subject) + len(e.HTMLBody) + len(e.TextBody))
+	for _, attach := range e.Attaches {
+		if attach.content != nil {
+			size += int64(base64.StdEncoding.EncodedLen(len(attach.content)))
+			continue
+		}
+		if len(attach.URL) == 0 {
+			size += int64(len(attach.FileContentBase64Encoded))
+			continue
+		}
+		contentLength, err := fetcher.size(ctx, attach.URL)
+		if err != nil || contentLength < 0 {
+			continue
+		}
+		// attachments are base64 encoded in the message
+		size += (contentLength + 2) / 3 * 4
+	}
+	return size
+}
+
+// fetchAttachments downloads the attachments given by URL, at most concurrency
+// at a time. Under the degrade policy the attachments which could not be
+// fetched are dropped from the email and listed in the X-Dropped-Attachments
+// header, under the fail policy the first failure cancels the other fetches.
+// An attachment over the size limit fails the email under both policies.
+func fetchAttachments(ctx context.Context, fetcher attachmentFetcher, e *email, policy string, concurrency int) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	fetchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	contents := make([][]byte, len(e.Attaches))
+	errs := make([]error, len(e.Attaches))
+	var mu sync.Mutex
+	var failure error
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, attach := range e.Attaches {
+		if len(attach.URL) == 0 {
+			continue
+		}
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int, attach emailAttach) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			contents[i], errs[i] = fetcher.fetch(fetchCtx, attach.URL)
+			var invalidErr errInvalidEmail
+			if errs[i] == nil || policy == attachmentFetchDegrade && !errors.As(errs[i], &invalidErr) {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			// the fetches canceled after the first failure don't replace it
+			if failure == nil && fetchCtx.Err() == nil {
+				failure = fmt.Errorf(`attachment "%s" could not be fetched: %w`, attach.FileName, errs[i])
+				cancel()
+			}
+		}(i, attach)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if failure != nil {
+		return failure
+	}
+
+	attaches := e.Attaches[:0]
+	for i, attach := range e.Attaches {
+		if len(attach.URL) == 0 {
+			attaches = append(attaches, attach)
+			continue
+		}
+		if errs[i] != nil {
+			log.Printf(`attachment "%s" is dropped, it could not be fetched: %v`, attach.FileName, errs[i])
+			e.droppedAttachments = append(e.droppedAttachments, attach.FileName)
+			continue
+		}
+		attach.content = contents[i]
+		attaches = append(attaches, attach)
+	}
+	e.Attaches = attaches
+	return nil
+}
+
+// limitedFetcher bounds the downloads running at once across all the emails,
+// so the attachment source and the memory aren't overwhelmed.
+type limitedFetcher struct {
+	attachmentFetcher
+	slots chan struct{}
+}
+
+func newLimitedFetcher(fetcher attachmentFetcher, limit int) *limitedFetcher {
+	return &limitedFetcher{attachmentFetcher: fetcher, slots: make(chan struct{}, limit)}
+}
+
+func (f *limitedFetcher) fetch(ctx context.Context, url string) ([]byte, error) {
+	select {
+	case f.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-f.slots }()
+	return f.attachmentFetcher.fetch(ctx, url)
+}
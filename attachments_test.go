@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/streadway/amqp"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeFetcher struct {
+	files map[string][]byte
+	sizes map[string]int64
+
+	mu    sync.Mutex
+	heads int
+	gets  int
+}
+
+func (f *fakeFetcher) fetch(ctx context.Context, url string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.gets++
+	if content, ok := f.files[url]; ok {
+		return content, nil
+	}
+	return nil, errors.New("not found")
+}
+
+func (f *fakeFetcher) size(ctx context.Context, url string) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.heads++
+	if size, ok := f.sizes[url]; ok {
+		return size, nil
+	}
+	if content, ok := f.files[url]; ok {
+		return int64(len(content)), nil
+	}
+	return 0, errors.New("not found")
+}
+
+func newEmailWithRemoteAttachments() *email {
+	return &email{
+		To:       "to@test.com",
+		Subject:  "Wow",
+		TextBody: "text body",
+		Attaches: []emailAttach{
+			{FileName: "report.csv", URL: "https://files.test.com/report.csv"},
+			{FileName: "missing.pdf", URL: "https://files.test.com/missing.pdf"},
+			{FileName: "inline.txt", FileContentBase64Encoded: "dGVzdCBpcyBvawo="},
+		},
+	}
+}
+
+func TestFetchAttachmentsFailPolicy(t *testing.T) {
+	fetcher := &fakeFetcher{files: map[string][]byte{"https://files.test.com/report.csv": []byte("a,b\n")}}
+	e := newEmailWithRemoteAttachments()
+
+	err := fetchAttachments(context.Background(), fetcher, e, attachmentFetchFail, 1)
+
+	if err == nil || !strings.Contains(err.Error(), `"missing.pdf" could not be fetched`) {
+		t.Fatal("missing attachment must fail the email", err)
+	}
+}
+
+func TestFetchAttachmentsDegradePolicy(t *testing.T) {
+	fetcher := &fakeFetcher{files: map[string][]byte{"https://files.test.com/report.csv": []byte("a,b\n")}}
+	e := newEmailWithRemoteAttachments()
+
+	err := fetchAttachments(context.Background(), fetcher, e, attachmentFetchDegrade, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(e.Attaches) != 2 || e.Attaches[0].FileName != "report.csv" || e.Attaches[1].FileName != "inline.txt" {
+		t.Fatal("attaches", e.Attaches)
+	}
+	if string(e.Attaches[0].content) != "a,b\n" {
+		t.Fatal("fetched content", string(e.Attaches[0].content))
+	}
+
+	raw := string(createEmail(emailOptions{from: "from@someone.com"}, e).RawMessage.Data)
+	if !strings.Contains(raw, "X-Dropped-Attachments: missing.pdf\r\n") {
+		t.Fatal("dropped attachments must be reported", raw)
+	}
+	if !strings.Contains(raw, `filename="report.csv"`) || strings.Contains(raw, `filename="missing.pdf"`) {
+		t.Fatal("only fetched attachments must be attached", raw)
+	}
+}
+
+func TestOversizedEmailIsRejectedBeforeFetching(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{maxEmailSize: 1024}, sender)
+	fetcher := &fakeFetcher{sizes: map[string]int64{"https://files.test.com/video.mp4": 50 * 1024 * 1024}}
+	m.fetcher = fetcher
+	acknowledger := &fakeAcknowledger{}
+	body := []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body","attaches":[{"file_name":"video.mp4","url":"https://files.test.com/video.mp4"}]}`)
+
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: body})
+
+	if fetcher.heads != 1 || fetcher.gets != 0 {
+		t.Fatal("size must be checked without downloading", fetcher.heads, fetcher.gets)
+	}
+	if acknowledger.nacked != 1 || acknowledger.requeued != 0 {
+		t.Fatal("oversized email must be rejected without requeue", acknowledger)
+	}
+	if len(sender.inputs) != 0 {
+		t.Fatal("email must not be sent", len(sender.inputs))
+	}
+}
+
+func TestOversizedAttachmentIsRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("a", 2048)))
+	}))
+	defer server.Close()
+	sender := &fakeSES{}
+	m := newTestMailer(&config{attachmentFetchPolicy: attachmentFetchDegrade}, sender)
+	m.fetcher = newHTTPAttachmentFetcher(1024)
+	acknowledger := &fakeAcknowledger{}
+	body := []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body","attaches":[{"file_name":"video.mp4","url":"` + server.URL + `"}]}`)
+
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: body})
+
+	if acknowledger.nacked != 1 || acknowledger.requeued != 0 {
+		t.Fatal("email with an oversized attachment must be rejected without requeue", acknowledger)
+	}
+	if len(sender.inputs) != 0 {
+		t.Fatal("email must not be sent", len(sender.inputs))
+	}
+}
+
+func TestEmailSizeIsNotEstimatedByDefault(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{}, sender)
+	fetcher := &fakeFetcher{files: map[string][]byte{"https://files.test.com/report.csv": []byte("a,b")}}
+	m.fetcher = fetcher
+	acknowledger := &fakeAcknowledger{}
+	body := []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body","attaches":[{"file_name":"report.csv","url":"https://files.test.com/report.csv"}]}`)
+
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: body})
+
+	if fetcher.heads != 0 || fetcher.gets != 1 {
+		t.Fatal("attachment size must not be requested without MAX_EMAIL_SIZE", fetcher.heads, fetcher.gets)
+	}
+	if acknowledger.acked != 1 || len(sender.inputs) != 1 {
+		t.Fatal("email must be sent", acknowledger, len(sender.inputs))
+	}
+}
+
+func TestEstimateEmailSize(t *testing.T) {
+	fetcher := &fakeFetcher{sizes: map[string]int64{"https://files.test.com/report.csv": 300, "https://files.test.com/missing.pdf": -1}}
+
+	size := estimateEmailSize(context.Background(), fetcher, newEmailWithRemoteAttachments())
+
+	// subject, text body, 400 bytes of encoded report.csv, inline.txt
+	if size != 3+9+400+16 {
+		t.Fatal("estimated size", size)
+	}
+}
+
+// concurrencyFetcher records the most fetches running at once.
+type concurrencyFetcher struct {
+	running int32
+	max     int32
+	gets    int32
+}
+
+func (f *concurrencyFetcher) fetch(ctx context.Context, url string) ([]byte, error) {
+	atomic.AddInt32(&f.gets, 1)
+	running := atomic.AddInt32(&f.running, 1)
+	defer atomic.AddInt32(&f.running, -1)
+	for {
+		max := atomic.LoadInt32(&f.max)
+		if running <= max || atomic.CompareAndSwapInt32(&f.max, max, running) {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	return []byte(url), nil
+}
+
+func (f *concurrencyFetcher) size(ctx context.Context, url string) (int64, error) {
+	return -1, nil
+}
+
+func newEmailWithManyRemoteAttachments(count int) *email {
+	e := &email{To: "to@test.com", Subject: "Wow", TextBody: "text body"}
+	for i := 0; i < count; i++ {
+		e.Attaches = append(e.Attaches, emailAttach{FileName: fmt.Sprintf("report-%d.csv", i), URL: fmt.Sprintf("https://files.test.com/report-%d.csv", i)})
+	}
+	return e
+}
+
+func TestFetchAttachmentsConcurrencyIsBounded(t *testing.T) {
+	fetcher := &concurrencyFetcher{}
+	e := newEmailWithManyRemoteAttachments(20)
+
+	if err := fetchAttachments(context.Background(), fetcher, e, attachmentFetchFail, 3); err != nil {
+		t.Fatal(err)
+	}
+	if fetcher.gets != 20 || fetcher.max > 3 {
+		t.Fatal("fetches must be bounded per email", fetcher.gets, fetcher.max)
+	}
+	if fetcher.max < 2 {
+		t.Fatal("attachments must be fetched concurrently", fetcher.max)
+	}
+	for i, attach := range e.Attaches {
+		if string(attach.content) != fmt.Sprintf("https://files.test.com/report-%d.csv", i) {
+			t.Fatal("attachments must keep their order and content", i, string(attach.content))
+		}
+	}
+}
+
+func TestLimitedFetcherBoundsAllEmails(t *testing.T) {
+	fetcher := &concurrencyFetcher{}
+	limited := newLimitedFetcher(fetcher, 2)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fetchAttachments(context.Background(), limited, newEmailWithManyRemoteAttachments(5), attachmentFetchFail, 4); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if fetcher.gets != 15 || fetcher.max > 2 {
+		t.Fatal("fetches must be bounded globally", fetcher.gets, fetcher.max)
+	}
+}
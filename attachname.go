@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// rfc2231ChunkSize is the longest encoded piece of a continued parameter value.
+	rfc2231ChunkSize = 60
+
+	dispositionAttachment = "attachment"
+	// dispositionInline asks the clients to show the attachment, e.g. an image,
+	// in the message body.
+	dispositionInline = "inline"
+)
+
+// builtinMIMETypes are the media types of the extensions the system table may
+// lack, e.g. of the compressed attachments.
+var builtinMIMETypes = map[string]string{
+	gzipExtension: "application/gzip",
+}
+
+// attachmentDispositions are the supported values of the attachment disposition field.
+var attachmentDispositions = map[string]bool{
+	dispositionAttachment: true,
+	dispositionInline:     true,
+}
+
+// attachmentHeaders returns the Content-Type and the Content-Disposition of the
+// attachment with the non-ASCII name, gomail writes the name as it is otherwise.
+// The filename is RFC 2231 encoded with continuations, the Content-Type name
+// is an RFC 2047 encoded word for the clients which don't support RFC 2231.
+// For the ASCII names only the headers differing from the gomail ones are
+// returned: the Content-Disposition of the inline attachments and the
+// Content-Type of the extensions mapped in mimeTypes or builtinMIMETypes.
+func attachmentHeaders(name, disposition string, mimeTypes map[string]string) map[string][]string {
+	if disposition != dispositionInline {
+		disposition = dispositionAttachment
+	}
+	if isASCII(name) {
+		headers := map[string][]string{}
+		if disposition == dispositionInline {
+			headers["Content-Disposition"] = []string{fmt.Sprintf(`%s; filename="%s"`, disposition, name)}
+		}
+		if mediaType, ok := attachmentMediaTypeOverride(name, mimeTypes); ok {
+			headers["Content-Type"] = []string{fmt.Sprintf(`%s; name="%s"`, mediaType, name)}
+		}
+		if len(headers) == 0 {
+			return nil
+		}
+		return headers
+	}
+	return map[string][]string{
+		"Content-Type":        {fmt.Sprintf(`%s; name="%s"`, attachmentMediaType(name, mimeTypes), mime.BEncoding.Encode("UTF-8", name))},
+		"Content-Disposition": {disposition + ";" + rfc2231Parameter("filename", name)},
+	}
+}
+
+// attachmentMediaType returns the media type of the attachment by the extension
+// of its name, the operator mapping takes precedence over the system one.
+func attachmentMediaType(name string, mimeTypes map[string]string) string {
+	if mediaType, ok := attachmentMediaTypeOverride(name, mimeTypes); ok {
+		return mediaType
+	}
+	if mediaType := mime.TypeByExtension(strings.ToLower(filepath.Ext(name))); mediaType != "" {
+		return mediaType
+	}
+	return "application/octet-stream"
+}
+
+// attachmentMediaTypeOverride returns the media type of the attachment from the
+// operator mapping or else from builtinMIMETypes, false when neither has it.
+func attachmentMediaTypeOverride(name string, mimeTypes map[string]string) (string, bool) {
+	ext := strings.ToLower(filepath.Ext(name))
+	if mediaType, ok := mimeTypes[ext]; ok {
+		return mediaType, true
+	}
+	mediaType, ok := builtinMIMETypes[ext]
+	return mediaType, ok
+}
+
+// rfc2231Parameter encodes the parameter value as UTF-8 and splits it into the
+// numbered continuations folded onto their own lines.
+func rfc2231Parameter(attribute, value string) string {
+	var encoded strings.Builder
+	for _, b := range []byte(value) {
+		if isAttributeChar(b) {
+			encoded.WriteByte(b)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+
+	var chunks []string
+	rest := encoded.String()
+	for len(rest) > rfc2231ChunkSize {
+		size := rfc2231ChunkSize
+		// the percent-encoded octets are not split
+		if i := strings.LastIndexByte(rest[:size], '%'); i > size-3 {
+			size = i
+		}
+		chunks = append(chunks, rest[:size])
+		rest = rest[size:]
+	}
+	chunks = append(chunks, rest)
+
+	if len(chunks) == 1 {
+		return fmt.Sprintf("\r\n %s*=UTF-8''%s", attribute, chunks[0])
+	}
+	var parameter strings.Builder
+	for i, chunk := range chunks {
+		if i == 0 {
+			chunk = "UTF-8''" + chunk
+		}
+		if i > 0 {
+			parameter.WriteByte(';')
+		}
+		fmt.Fprintf(&parameter, "\r\n %s*%d*=%s", attribute, i, chunk)
+	}
+	return parameter.String()
+}
+
+// isAttributeChar reports whether the byte stays unencoded in the RFC 2231 value.
+func isAttributeChar(b byte) bool {
+	return 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9' || strings.IndexByte("!#$&+-.^_`|~", b) >= 0
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+// attachmentFilenames parses the message and returns the filenames of its attachments.
+func attachmentFilenames(t *testing.T, raw []byte) []string {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var filenames []string
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		if part.Header.Get("Content-Disposition") == "" {
+			continue
+		}
+		disposition, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if disposition == "attachment" {
+			filenames = append(filenames, params["filename"])
+		}
+		ioutil.ReadAll(part)
+	}
+	return filenames
+}
+
+func TestUTF8AttachmentFilenames(t *testing.T) {
+	longName := strings.Repeat("Ежемесячный ", 6) + "отчёт.pdf"
+	input := createEmail(emailOptions{from: "from@someone.com"}, &email{
+		To:       "to@test.com",
+		Subject:  "Wow",
+		TextBody: "text body",
+		Attaches: []emailAttach{
+			{FileName: "Счёт.pdf", FileContentBase64Encoded: "dGVzdCBpcyBvawo="},
+			{FileName: longName, FileContentBase64Encoded: "dGVzdCBpcyBvawo="},
+			{FileName: "report.csv", FileContentBase64Encoded: "dGVzdCBpcyBvawo="},
+		},
+	})
+	raw := string(input.RawMessage.Data)
+
+	filenames := attachmentFilenames(t, input.RawMessage.Data)
+	if strings.Join(filenames, "|") != "Счёт.pdf|"+longName+"|report.csv" {
+		t.Fatal("filenames", filenames)
+	}
+	if !strings.Contains(raw, "filename*=UTF-8''%D0%A1%D1%87%D1%91%D1%82.pdf") {
+		t.Fatal("filename must be RFC 2231 encoded", raw)
+	}
+	if !strings.Contains(raw, "filename*0*=UTF-8''") || !strings.Contains(raw, "filename*1*=") {
+		t.Fatal("long filename must be continued", raw)
+	}
+	if !strings.Contains(raw, `name="=?UTF-8?b?`) {
+		t.Fatal("Content-Type name must be an encoded word", raw)
+	}
+}
+
+func TestAttachmentDisposition(t *testing.T) {
+	input := createEmail(emailOptions{from: "from@someone.com"}, &email{
+		To:       "to@test.com",
+		Subject:  "Wow",
+		HTMLBody: "<p>html body</p>",
+		Attaches: []emailAttach{
+			{FileName: "logo.png", FileContentBase64Encoded: "dGVzdCBpcyBvawo=", Disposition: "inline"},
+			{FileName: "Счёт.pdf", FileContentBase64Encoded: "dGVzdCBpcyBvawo=", Disposition: "inline"},
+			{FileName: "report.csv", FileContentBase64Encoded: "dGVzdCBpcyBvawo="},
+			{FileName: "terms.pdf", FileContentBase64Encoded: "dGVzdCBpcyBvawo=", Disposition: "attachment"},
+		},
+	})
+	msg, err := mail.ReadMessage(bytes.NewReader(input.RawMessage.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispositions := map[string]string{}
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		if part.Header.Get("Content-Disposition") == "" {
+			continue
+		}
+		disposition, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dispositions[params["filename"]] = disposition
+	}
+	expected := map[string]string{"logo.png": "inline", "Счёт.pdf": "inline", "report.csv": "attachment", "terms.pdf": "attachment"}
+	for name, disposition := range expected {
+		if dispositions[name] != disposition {
+			t.Fatal(name, "disposition", dispositions[name])
+		}
+	}
+}
+
+func TestValidateAttachmentDisposition(t *testing.T) {
+	e := &email{
+		To:       "to@test.com",
+		Subject:  "Wow",
+		TextBody: "text body",
+		Attaches: []emailAttach{{FileName: "logo.png", FileContentBase64Encoded: "dGVzdCBpcyBvawo=", Disposition: "inline"}},
+	}
+	if err := e.validate(); err != nil {
+		t.Fatal("inline disposition is rejected", err)
+	}
+	e.Attaches[0].Disposition = "embedded"
+	var invalidErr errInvalidEmail
+	if err := e.validate(); !errors.As(err, &invalidErr) {
+		t.Fatal("unknown disposition must reject the email", err)
+	}
+}
+
+func TestAttachmentMIMETypes(t *testing.T) {
+	mimeTypes, err := parseMIMETypes("dwg=image/vnd.dwg, .STEP = model/step")
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := createEmail(emailOptions{from: "from@someone.com", mimeTypes: mimeTypes}, &email{
+		To:       "to@test.com",
+		Subject:  "Wow",
+		TextBody: "text body",
+		Attaches: []emailAttach{
+			{FileName: "plan.DWG", FileContentBase64Encoded: "dGVzdCBpcyBvawo="},
+			{FileName: "Деталь.step", FileContentBase64Encoded: "dGVzdCBpcyBvawo="},
+			{FileName: "report.pdf", FileContentBase64Encoded: "dGVzdCBpcyBvawo="},
+		},
+	})
+	msg, err := mail.ReadMessage(bytes.NewReader(input.RawMessage.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mediaTypes []string
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		if part.Header.Get("Content-Disposition") == "" {
+			continue
+		}
+		mediaType, _, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		mediaTypes = append(mediaTypes, mediaType)
+	}
+	if strings.Join(mediaTypes, "|") != "image/vnd.dwg|model/step|application/pdf" {
+		t.Fatal("media types", mediaTypes)
+	}
+}
+
+func TestParseMIMETypesValidatesTypes(t *testing.T) {
+	for _, v := range []string{"dwg", "=image/png", "dwg=not a type"} {
+		if _, err := parseMIMETypes(v); err == nil {
+			t.Fatal(v, "must be rejected")
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// errCodeThrottling is returned by SES above the maximum send rate.
+	errCodeThrottling = "Throttling"
+	// adaptiveRateRecoverySteps is how many successful sends recover the rate
+	// from the minimum to the maximum.
+	adaptiveRateRecoverySteps = 50
+	defaultAdaptiveMinRate    = 1
+)
+
+// isThrottling reports whether SES refused the send for exceeding the send rate.
+func isThrottling(err error) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == errCodeThrottling
+}
+
+// adaptiveRate paces the sends AIMD-style: the rate is halved whenever SES
+// throttles and grows back by a fixed step with every successful send, so it
+// stays near the true SES limit without tuning.
+type adaptiveRate struct {
+	limiter  *rateLimiter
+	min, max float64
+	step     float64
+
+	mu   sync.Mutex
+	rate float64
+}
+
+// newAdaptiveRate returns nil without the maximum rate, so the sends are not paced.
+func newAdaptiveRate(max, min float64) *adaptiveRate {
+	if max <= 0 {
+		return nil
+	}
+	if min <= 0 || min > max {
+		min = max
+	}
+	return &adaptiveRate{
+		limiter: newRateLimiter(max),
+		min:     min,
+		max:     max,
+		step:    (max - min) / adaptiveRateRecoverySteps,
+		rate:    max,
+	}
+}
+
+// reserve takes the next send slot like rateLimiter.reserve.
+func (a *adaptiveRate) reserve() time.Duration {
+	if a == nil {
+		return 0
+	}
+	return a.limiter.reserve()
+}
+
+// throttled halves the rate down to the minimum.
+func (a *adaptiveRate) throttled() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rate /= 2
+	if a.rate < a.min {
+		a.rate = a.min
+	}
+	a.limiter.setRate(a.rate)
+	log.Printf("SES throttles, the send rate is lowered to %.2f per second\n", a.rate)
+}
+
+// succeeded grows the rate by one step up to the maximum.
+func (a *adaptiveRate) succeeded() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.rate >= a.max {
+		return
+	}
+	a.rate += a.step
+	if a.rate > a.max {
+		a.rate = a.max
+	}
+	a.limiter.setRate(a.rate)
+}
+
+func (a *adaptiveRate) currentRate() float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.rate
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/streadway/amqp"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveRateDecreasesThenRecovers(t *testing.T) {
+	a := newAdaptiveRate(10, 1)
+
+	a.throttled()
+	if rate := a.currentRate(); rate != 5 {
+		t.Fatal("throttling must halve the rate", rate)
+	}
+	a.throttled()
+	a.throttled()
+	a.throttled()
+	if rate := a.currentRate(); rate != 1 {
+		t.Fatal("rate must not go below the minimum", rate)
+	}
+
+	a.succeeded()
+	if rate := a.currentRate(); rate <= 1 {
+		t.Fatal("success must grow the rate", rate)
+	}
+	for i := 0; i < adaptiveRateRecoverySteps; i++ {
+		a.succeeded()
+	}
+	if rate := a.currentRate(); rate != 10 {
+		t.Fatal("rate must recover to the maximum", rate)
+	}
+}
+
+func TestThrottledSendLowersRateAndRequeues(t *testing.T) {
+	sender := &fakeSES{err: awserr.New(errCodeThrottling, "Maximum sending rate exceeded.", nil)}
+	m := newTestMailer(&config{}, sender)
+	m.sendRate = newAdaptiveRate(8, 1)
+	m.retryDelay = time.Hour
+	acknowledger := &fakeAcknowledger{}
+
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body"}`)})
+
+	if acknowledger.requeued != 1 {
+		t.Fatal("throttled email must be requeued")
+	}
+	if rate := m.sendRate.currentRate(); rate != 4 {
+		t.Fatal("throttling must lower the send rate", rate)
+	}
+	if report := m.stats.report(); report.BackoffSeconds != 0 {
+		t.Fatal("throttled email must not wait for the retry delay", report.BackoffSeconds)
+	}
+
+	sender.err = nil
+	m.sendRate.limiter.now = func() time.Time { return time.Now().Add(time.Second) }
+	m.handle(amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body"}`)})
+	if rate := m.sendRate.currentRate(); rate <= 4 {
+		t.Fatal("successful send must grow the rate", rate)
+	}
+}
+
+func TestIsThrottling(t *testing.T) {
+	if !isThrottling(errAWSSendingEmail{err: awserr.New(errCodeThrottling, "Maximum sending rate exceeded.", nil)}) {
+		t.Fatal("wrapped throttling error")
+	}
+	if isThrottling(errors.New("Throttling")) {
+		t.Fatal("plain error is not a throttling one")
+	}
+}
+
+func TestDisabledAdaptiveRate(t *testing.T) {
+	var a *adaptiveRate
+	a.throttled()
+	a.succeeded()
+	if newAdaptiveRate(0, 1) != nil || a.reserve() != 0 {
+		t.Fatal("adaptive rate without the maximum must be disabled")
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/streadway/amqp"
+	"log"
+)
+
+const (
+	// batchRequeue retries the whole batch when one of its emails fails, the
+	// emails sent already are sent again.
+	batchRequeue = "requeue"
+	// batchSplit republishes the failed emails of the batch one by one and acks the batch.
+	batchSplit = "split"
+)
+
+// isBatch reports whether the body is a JSON array of emails.
+func isBatch(body []byte) bool {
+	body = bytes.TrimLeft(body, " \t\r\n")
+	return len(body) > 0 && body[0] == '['
+}
+
+// batchAcknowledger records how an email of the batch was acknowledged.
+type batchAcknowledger struct {
+	requeued bool
+}
+
+func (a *batchAcknowledger) Ack(tag uint64, multiple bool) error {
+	return nil
+}
+
+func (a *batchAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.requeued = requeue
+	return nil
+}
+
+func (a *batchAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+// handleBatch handles the emails of the batch one by one, the batch is acked
+// once all of them are sent or rejected for good.
+func (m *mailer) handleBatch(message amqp.Delivery) {
+	var bodies []json.RawMessage
+	err := json.Unmarshal(message.Body, &bodies)
+	if err != nil {
+		// retrying doesn't fix the batch, it is taken out of the queue
+		m.deadLetter(message, fmt.Errorf("batch could not be decoded: %v", err))
+		m.stats.failed()
+		return
+	}
+
+	var failed []json.RawMessage
+	for i, body := range bodies {
+		acknowledger := &batchAcknowledger{}
+		delivery := message
+		delivery.Acknowledger = acknowledger
+		delivery.Body = body
+		m.handle(delivery)
+		if !acknowledger.requeued {
+			continue
+		}
+		if m.cfg.batchFailurePolicy != batchSplit {
+			log.Printf("email %d of %d failed, the batch is retried\n", i+1, len(bodies))
+			message.Nack(false, true)
+			return
+		}
+		failed = append(failed, body)
+	}
+
+	for _, body := range failed {
+		publishing := republishing(message)
+		publishing.Body = body
+		err := m.channel.Publish("", m.cfg.amqpQueue, false, false, publishing)
+		if err != nil {
+			log.Println("failed emails of the batch could not be republished, the batch is retried", err)
+			message.Nack(false, true)
+			return
+		}
+	}
+	if len(failed) > 0 {
+		log.Printf("%d of %d emails failed, they are republished one by one\n", len(failed), len(bodies))
+	}
+	message.Ack(false)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/streadway/amqp"
+	"testing"
+)
+
+const twoEmailBatch = `[
+	{"to":"first@test.com","subject":"First","text_body":"text body"},
+	{"to":"second@test.com","subject":"Second","text_body":"text body"}
+]`
+
+func TestBatchPayloadSendsEveryEmail(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{}, sender)
+	acknowledger := &fakeAcknowledger{}
+
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: []byte(twoEmailBatch)})
+
+	if len(sender.inputs) != 2 {
+		t.Fatal("every email of the batch must be sent", len(sender.inputs))
+	}
+	if aws.StringValue(sender.inputs[0].Destinations[0]) != "first@test.com" || aws.StringValue(sender.inputs[1].Destinations[0]) != "second@test.com" {
+		t.Fatal("destinations", sender.inputs[0].Destinations, sender.inputs[1].Destinations)
+	}
+	if acknowledger.acked != 1 || acknowledger.nacked != 0 {
+		t.Fatal("batch must be acked once", acknowledger)
+	}
+	if m.stats.consumedTotal != 2 || m.stats.sentTotal != 2 || m.stats.inFlight != 0 {
+		t.Fatal("stats must count the emails of the batch once", m.stats.consumedTotal, m.stats.sentTotal, m.stats.inFlight)
+	}
+}
+
+func TestUndecodableBatchIsDeadLettered(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{amqpDLQ: "mailer.dlq"}, sender)
+	acknowledger := &fakeAcknowledger{}
+	body := []byte(`[{"to":"first@test.com","subject":"First"`)
+
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: body})
+
+	if acknowledger.acked != 1 || acknowledger.requeued != 0 {
+		t.Fatal("undecodable batch must not be requeued", acknowledger)
+	}
+	published := m.channel.(*fakeChannel).published
+	if len(published) != 1 || published[0].key != "mailer.dlq" || string(published[0].msg.Body) != string(body) {
+		t.Fatal("undecodable batch must be dead-lettered", published)
+	}
+	if len(sender.inputs) != 0 {
+		t.Fatal("email must not be sent", len(sender.inputs))
+	}
+}
+
+// failingRecipientSES fails the sends to one recipient.
+type failingRecipientSES struct {
+	fakeSES
+	recipient string
+}
+
+func (s *failingRecipientSES) SendRawEmailWithContext(ctx aws.Context, input *ses.SendRawEmailInput, opts ...request.Option) (*ses.SendRawEmailOutput, error) {
+	if aws.StringValue(input.Destinations[0]) == s.recipient {
+		return nil, awserr.New("Throttling", "slow down", nil)
+	}
+	return s.fakeSES.SendRawEmailWithContext(ctx, input, opts...)
+}
+
+func TestBatchIsRequeuedWhenAnEmailFails(t *testing.T) {
+	sender := &failingRecipientSES{recipient: "first@test.com"}
+	m := newTestMailer(&config{batchFailurePolicy: batchRequeue}, sender)
+	acknowledger := &fakeAcknowledger{}
+
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: []byte(twoEmailBatch)})
+
+	if acknowledger.requeued != 1 || acknowledger.acked != 0 {
+		t.Fatal("batch must be requeued", acknowledger)
+	}
+	if len(sender.inputs) != 0 {
+		t.Fatal("the rest of the batch must not be sent", len(sender.inputs))
+	}
+}
+
+func TestBatchSplitRepublishesFailedEmails(t *testing.T) {
+	sender := &failingRecipientSES{recipient: "first@test.com"}
+	m := newTestMailer(&config{amqpQueue: "mailer", batchFailurePolicy: batchSplit}, sender)
+	acknowledger := &fakeAcknowledger{}
+
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: []byte(twoEmailBatch), CorrelationId: "order-42", MessageId: "batch-42", Priority: 5})
+
+	if len(sender.inputs) != 1 || aws.StringValue(sender.inputs[0].Destinations[0]) != "second@test.com" {
+		t.Fatal("the rest of the batch must be sent", sender.inputs)
+	}
+	published := m.channel.(*fakeChannel).published
+	if len(published) != 1 || published[0].key != "mailer" || string(published[0].msg.Body) != `{"to":"first@test.com","subject":"First","text_body":"text body"}` {
+		t.Fatal("failed email must be republished alone", published)
+	}
+	if msg := published[0].msg; msg.CorrelationId != "order-42" || msg.MessageId != "batch-42" || msg.Priority != 5 {
+		t.Fatal("message properties must be kept", msg)
+	}
+	if acknowledger.acked != 1 || acknowledger.nacked != 0 {
+		t.Fatal("batch must be acked", acknowledger)
+	}
+}
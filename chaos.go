@@ -0,0 +1,28 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"math/rand"
+)
+
+// chaosSender fails the given share of sends with a simulated transient SES
+// error without calling SES, to exercise retries in staging.
+type chaosSender struct {
+	sender      sesSender
+	failureRate float64
+	random      func() float64
+}
+
+func newChaosSender(sender sesSender, failureRate float64) *chaosSender {
+	return &chaosSender{sender: sender, failureRate: failureRate, random: rand.Float64}
+}
+
+func (c *chaosSender) SendRawEmailWithContext(ctx aws.Context, input *ses.SendRawEmailInput, opts ...request.Option) (*ses.SendRawEmailOutput, error) {
+	if c.random() < c.failureRate {
+		return nil, awserr.New("Throttling", "chaos mode simulated failure", nil)
+	}
+	return c.sender.SendRawEmailWithContext(ctx, input, opts...)
+}
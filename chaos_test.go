@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+)
+
+func TestChaosSenderFailureRate(t *testing.T) {
+	sender := &fakeSES{}
+	chaos := newChaosSender(sender, 0.2)
+	chaos.random = rand.New(rand.NewSource(1)).Float64
+
+	failures := 0
+	for i := 0; i < 10000; i++ {
+		if _, err := chaos.SendRawEmailWithContext(context.Background(), nil); err != nil {
+			failures++
+		}
+	}
+
+	if failures < 1800 || failures > 2200 {
+		t.Fatal("failures must be about 20%", failures)
+	}
+	if len(sender.inputs)+failures != 10000 {
+		t.Fatal("failed sends must not reach SES", len(sender.inputs), failures)
+	}
+}
+
+func TestChaosSenderWithoutFailures(t *testing.T) {
+	chaos := newChaosSender(&fakeSES{}, 0)
+
+	for i := 0; i < 1000; i++ {
+		if _, err := chaos.SendRawEmailWithContext(context.Background(), nil); err != nil {
+			t.Fatal("sends must not fail", err)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/text/encoding/htmlindex"
+	"strings"
+)
+
+// convertBodiesToUTF8 converts the bodies given in BodyCharset, e.g. ISO-8859-1
+// of a legacy system, to UTF-8. JSON strings can't carry the bytes of another
+// charset, so such bodies are base64 encoded.
+func (e *email) convertBodiesToUTF8() error {
+	if len(e.BodyCharset) == 0 {
+		return nil
+	}
+	encoding, err := htmlindex.Get(e.BodyCharset)
+	if err != nil {
+		return fmt.Errorf(`"%s" charset is not supported`, e.BodyCharset)
+	}
+	for _, body := range []*string{&e.HTMLBody, &e.TextBody, &e.MarkdownBody} {
+		if len(*body) == 0 {
+			continue
+		}
+		encoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(*body))
+		if err != nil {
+			return fmt.Errorf("bodies in %s charset must be base64 encoded: %v", e.BodyCharset, err)
+		}
+		decoded, err := encoding.NewDecoder().Bytes(encoded)
+		if err != nil {
+			return fmt.Errorf("body is not valid %s: %v", e.BodyCharset, err)
+		}
+		*body = string(decoded)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestLatin1BodyIsConvertedToUTF8(t *testing.T) {
+	latin1 := []byte("Caf\xe9 d\xe9j\xe0 vu, \xa35")
+	e := &email{
+		BodyCharset: "ISO-8859-1",
+		TextBody:    base64.StdEncoding.EncodeToString(latin1),
+		HTMLBody:    base64.StdEncoding.EncodeToString([]byte("<p>Gr\xfc\xdfe</p>")),
+	}
+
+	if err := e.convertBodiesToUTF8(); err != nil {
+		t.Fatal(err)
+	}
+
+	if e.TextBody != "Café déjà vu, £5" {
+		t.Fatal("text body must be converted to UTF-8", e.TextBody)
+	}
+	if e.HTMLBody != "<p>Grüße</p>" {
+		t.Fatal("html body must be converted to UTF-8", e.HTMLBody)
+	}
+}
+
+func TestBodyWithoutCharsetIsLeftAsItIs(t *testing.T) {
+	e := &email{TextBody: "Café"}
+
+	if err := e.convertBodiesToUTF8(); err != nil || e.TextBody != "Café" {
+		t.Fatal("UTF-8 body must not be changed", e.TextBody, err)
+	}
+}
+
+func TestUnknownCharset(t *testing.T) {
+	e := &email{BodyCharset: "klingon", TextBody: "cWFwbGEn"}
+
+	if err := e.convertBodiesToUTF8(); err == nil || err.Error() != `"klingon" charset is not supported` {
+		t.Fatal("unknown charset must fail", err)
+	}
+}
@@ -0,0 +1,733 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// config holds the mailer settings read from the environment on startup.
+type config struct {
+	// amqpURLs are the brokers dialed in order, the first one is the primary.
+	amqpURLs    []string
+	amqpQueue   string
+	fromAddress string
+	// fromAddresses are the verified identities the emails are sent from in turn,
+	// the first one is fromAddress.
+	fromAddresses []string
+	// selfTestTo are the addresses of the test email sent on startup, nothing is sent when empty.
+	selfTestTo string
+	// signingSecret is the shared secret the emails are signed with for the downstream processors.
+	signingSecret []byte
+	// fromFallbacks are tried in order when SES refuses the From identity of an email.
+	fromFallbacks []string
+
+	// verifyFromIdentities refuses to start when a From address isn't verified in
+	// the SES account, it is off for the identities of sending authorization.
+	verifyFromIdentities bool
+	// devMode sends through the local SES endpoint, e.g. LocalStack, and doesn't
+	// check the From addresses are verified.
+	devMode        bool
+	devSESEndpoint string
+	// sesRequireTLS refuses to start when the SES endpoint isn't https, it is
+	// on by default but under DEV_MODE.
+	sesRequireTLS bool
+
+	// entityRefID adds a random X-Entity-Ref-ID header to the emails without one.
+	entityRefID bool
+	// contentDigest is the algorithm of the X-Content-Digest header, one of
+	// digestAlgorithms, the header is not added when empty.
+	contentDigest string
+	// precedence is precedenceBulk or precedenceList set as the Precedence of
+	// the marketing emails, it is not set when empty.
+	precedence string
+
+	// maxDestinations is the most recipients sent to in a single SES call, the
+	// emails with more are sent in chunks, sesMaxDestinations when it is 0.
+	maxDestinations int
+
+	// recipientWarningThreshold is the recipient count above which a warning is logged.
+	recipientWarningThreshold int
+	// maxRecipientLength caps the length of the To, Cc and Bcc strings.
+	maxRecipientLength int
+
+	// returnPaths maps the From address domain to the Return-Path used for it,
+	// defaultReturnPath is used for the domains which are not mapped.
+	returnPaths       map[string]string
+	defaultReturnPath string
+
+	// configurationSet is the SES configuration set of every email, an email may
+	// pick another one of allowedConfigurationSets.
+	configurationSet         string
+	allowedConfigurationSets map[string]bool
+
+	// typeConfigurationSets, typeFromAddresses and typeRateLimits are the defaults
+	// of the email types, the rate limits are in emails per second.
+	typeConfigurationSets map[string]string
+	typeFromAddresses     map[string]string
+	typeRateLimits        map[string]float64
+
+	// domainRateLimits are the emails per second sent to the recipient domains
+	// which throttle inbound mail, every recipient takes a send.
+	domainRateLimits map[string]float64
+
+	// groupsFile or groupsURL define the groups the @group recipients expand to.
+	groupsFile string
+	groupsURL  string
+	// addressRewriteFile is the path of the JSON file of the recipient address
+	// rewrite rules, e.g. for a domain migration.
+	addressRewriteFile string
+
+	// defaultCc and defaultBcc are added to the recipients of every email.
+	defaultCc  []string
+	defaultBcc []string
+	// defaultReplyTo is the Reply-To of the emails which have none.
+	defaultReplyTo []string
+
+	// amqpPrefetchSize limits the total size in bytes of unacknowledged messages.
+	amqpPrefetchSize int
+	amqpHeartbeat    time.Duration
+	// amqpChannelRetryDelay is the pause before a closed channel is reopened on the same connection.
+	amqpChannelRetryDelay time.Duration
+	// amqpPassiveDeclare only verifies the queue exists instead of declaring it.
+	amqpPassiveDeclare bool
+	// amqpDelayedExchange is the exchange of the rabbitmq-delayed-message-exchange
+	// plugin used to hold back delayed emails.
+	amqpDelayedExchange string
+
+	// amqpRetryDelay holds the retried messages back in the retry queue, a TTL
+	// queue dead-lettering them to the main queue, they are requeued at once
+	// when it is 0.
+	amqpRetryDelay time.Duration
+	// maxRetries moves the emails of normal priority retried this often to the
+	// dead letter queue, the retries are counted with amqpRetryDelay or by a
+	// quorum queue. The retries are not limited when it is 0.
+	maxRetries int
+
+	// amqpDLQ is the queue receiving the emails taken out of the main queue.
+	amqpDLQ string
+	// webhookURL receives a POST with every send result, signed with webhookSecret when set.
+	webhookURL    string
+	webhookSecret []byte
+	// amqpStatusQueue receives one status message per recipient of every send attempt.
+	amqpStatusQueue string
+	// rejectionPolicy is rejectionAck or rejectionDLQ for the emails permanently rejected by SES.
+	rejectionPolicy string
+
+	// emfMetrics writes the send metrics to stdout in the CloudWatch Embedded Metric Format.
+	emfMetrics bool
+	// emitSendEvents writes a JSON event line per send to stdout for the log based analytics.
+	emitSendEvents bool
+	// emfMaxTenants caps the distinct tenants labelled in the metrics.
+	emfMaxTenants int
+
+	// invalidRecipientPolicy is invalidRecipientFail or invalidRecipientDrop for
+	// the addresses SES rejects with InvalidParameterValue.
+	invalidRecipientPolicy string
+
+	// batchFailurePolicy is batchRequeue or batchSplit for the batches with failed emails.
+	batchFailurePolicy string
+
+	// statsAddr is the listen address of the /stats endpoint, it is disabled when empty.
+	statsAddr string
+
+	// messageDeadline bounds the time spent on fetching, building and sending one email.
+	messageDeadline time.Duration
+	// maxSendTimeout caps send_timeout_ms of the emails, defaultMaxSendTimeout when it is 0.
+	maxSendTimeout time.Duration
+
+	// payloadSchema is the path of the JSON Schema file of the email payloads.
+	payloadSchema string
+	// maxMessageAge drops the messages published longer ago, it is disabled when 0.
+	maxMessageAge time.Duration
+	// maxPayloadBytes dead-letters the larger AMQP messages before decoding, it is disabled when 0.
+	maxPayloadBytes int
+	// maxEmailSize rejects the emails estimated to be larger before fetching the
+	// attachments, the size is not estimated when it is 0. It also caps a
+	// downloaded attachment.
+	maxEmailSize int
+
+	// maxHeaderSize rejects the emails whose header section is larger, it is disabled when 0.
+	maxHeaderSize int
+
+	// attachmentCacheSize is the memory in bytes kept for the decoded attachments
+	// shared by the emails, the cache is disabled when 0.
+	attachmentCacheSize int
+
+	// attachmentFetchPolicy is either attachmentFetchFail or attachmentFetchDegrade.
+	attachmentFetchPolicy string
+	// attachmentFetchConcurrency bounds the attachments of an email downloaded
+	// at once, maxAttachmentFetches the downloads of all the emails, there is
+	// no global bound when it is 0.
+	attachmentFetchConcurrency int
+	maxAttachmentFetches       int
+
+	// subjectRegexp is the policy every subject must match, it is not checked when nil.
+	subjectRegexp *regexp.Regexp
+	// subjectLengthPolicy is subjectLengthReject or subjectLengthTruncate, the
+	// subject length is not checked when it is empty.
+	subjectLengthPolicy string
+
+	// duplicateAttachmentPolicy is duplicateAttachmentReject or
+	// duplicateAttachmentRename, the file names are not checked when it is empty.
+	duplicateAttachmentPolicy string
+	// attachmentVolume is the directory of the shared volume the attachments
+	// given by path are read from.
+	attachmentVolume string
+	// attachmentMIMETypes maps the lowercase attachment extensions, with the
+	// leading dot, to the media types used instead of the system ones.
+	attachmentMIMETypes map[string]string
+	// emptyAttachmentPolicy is emptyAttachmentReject or emptyAttachmentSkip, the
+	// attachments without content are sent as they are when it is empty.
+	emptyAttachmentPolicy string
+	// plusAddressing is plusAddressingPreserve or plusAddressingStrip.
+	plusAddressing string
+	// htmlValidation is htmlValidationWarn or htmlValidationReject, the html
+	// bodies are not checked when it is empty.
+	htmlValidation string
+	// contentGuard is contentGuardWarn or contentGuardReject, the checks are disabled when empty.
+	contentGuard        string
+	contentGuardMinBody int
+
+	// feedbackForwarding turns the bounce and complaint emails of the From identity
+	// on or off, it is left as it is when nil.
+	feedbackForwarding *bool
+
+	// chaosFailureRate is the share of sends failed on purpose, from 0 to 1.
+	chaosFailureRate float64
+
+	// trustProducer honors the skip_validation flag of the emails.
+	trustProducer bool
+
+	// logSESRequestIDs logs the request id of every SES call for support cases.
+	logSESRequestIDs bool
+
+	// templateDir holds the templates the emails may render their bodies from.
+	templateDir string
+	// multipartPayloads accepts the multipart deliveries carrying the attachments as raw parts.
+	multipartPayloads bool
+	// allowEmptyBody lets the emails with attachments but no body through with a placeholder text body.
+	allowEmptyBody bool
+	// keepBodyWhitespace leaves the leading and trailing whitespace of the bodies.
+	keepBodyWhitespace bool
+
+	stripTrackingPixels bool
+	inlineCSS           bool
+	// validateMX enables the recipient domain MX lookup, it slows down validation.
+	validateMX bool
+	// lookupFailurePolicy is lookupFailOpen or lookupFailClosed.
+	lookupFailurePolicy string
+	// sesSimulator allows the SES mailbox simulator addresses and logs their simulated outcome.
+	sesSimulator bool
+
+	// quotaCheckInterval is how often the SES sending quota is checked, it is
+	// not checked when 0. With quotaPause set, sending waits while no more than
+	// quotaReserve sends are left.
+	quotaCheckInterval time.Duration
+	quotaReserve       int
+	quotaPause         bool
+
+	// accountPauseCheckInterval is how often SES is asked whether the paused
+	// account sends again, defaultAccountPauseCheckInterval when it is 0.
+	accountPauseCheckInterval time.Duration
+
+	// interMessageDelay is the pause between the handling of two messages, e.g.
+	// for an account with a tiny send rate.
+	interMessageDelay time.Duration
+	// adaptiveMaxRate and adaptiveMinRate bound the sends per second paced to the
+	// SES throttling, the pacing is disabled when adaptiveMaxRate is 0.
+	adaptiveMaxRate float64
+	adaptiveMinRate float64
+	// maxRecipientsPerHour caps the distinct recipients emailed in the last hour.
+	maxRecipientsPerHour int
+
+	// warmUpSchedule lists the daily sending caps, one per day starting at warmUpStart.
+	warmUpSchedule []int
+	warmUpStart    time.Time
+}
+
+func loadConfig() *config {
+	cfg := &config{
+		amqpQueue: getEnv("AMQP_QUEUE"),
+	}
+	cfg.amqpURLs = parseAMQPURLs(os.Getenv("AMQP_URLS"))
+	if len(cfg.amqpURLs) == 0 {
+		cfg.amqpURLs = []string{getEnv("AMQP_URL")}
+	}
+	cfg.fromAddresses = getEnvAddresses("AWS_FROM_ADDRESSES")
+	if len(cfg.fromAddresses) == 0 {
+		cfg.fromAddresses = []string{getEnv("AWS_VERIFIED_FROM_EMAIL_ADDRESS")}
+	}
+	cfg.fromAddress = cfg.fromAddresses[0]
+	if !emailRegexp.MatchString(cfg.fromAddress) {
+		log.Fatalf("AWS_VERIFIED_FROM_EMAIL_ADDRESS: \"%s\" is not valid email\n", cfg.fromAddress)
+	}
+	cfg.fromFallbacks = getEnvAddresses("FROM_FALLBACK_ADDRESSES")
+	cfg.selfTestTo = strings.Join(getEnvAddresses("STARTUP_SELF_TEST_TO"), ",")
+	if v := os.Getenv("MAILER_SIGNING_SECRET"); v != "" {
+		cfg.signingSecret = []byte(v)
+	}
+	cfg.entityRefID = getEnvBool("ENTITY_REF_ID")
+	cfg.contentDigest = os.Getenv("CONTENT_DIGEST")
+	if _, ok := digestAlgorithms[cfg.contentDigest]; cfg.contentDigest != "" && !ok {
+		log.Fatalf("CONTENT_DIGEST must be sha1, sha256 or sha512\n")
+	}
+	cfg.precedence = os.Getenv("PRECEDENCE")
+	if cfg.precedence != "" && cfg.precedence != precedenceBulk && cfg.precedence != precedenceList {
+		log.Fatalf("PRECEDENCE must be %s or %s\n", precedenceBulk, precedenceList)
+	}
+	cfg.maxDestinations = getEnvInt("SES_MAX_DESTINATIONS")
+	if cfg.maxDestinations > sesMaxDestinations || os.Getenv("SES_MAX_DESTINATIONS") != "" && cfg.maxDestinations < 1 {
+		log.Fatalf("SES_MAX_DESTINATIONS must be between 1 and %d\n", sesMaxDestinations)
+	}
+	cfg.verifyFromIdentities = getEnvBool("VERIFY_FROM_IDENTITIES")
+	cfg.devMode = getEnvBool("DEV_MODE")
+	if cfg.devMode {
+		cfg.devSESEndpoint = os.Getenv("DEV_SES_ENDPOINT")
+		if cfg.devSESEndpoint == "" {
+			cfg.devSESEndpoint = defaultDevSESEndpoint
+		}
+	}
+	cfg.sesRequireTLS = !cfg.devMode
+	if os.Getenv("SES_REQUIRE_TLS") != "" {
+		cfg.sesRequireTLS = getEnvBool("SES_REQUIRE_TLS")
+	}
+
+	if v := os.Getenv("RETURN_PATH_BY_DOMAIN"); v != "" {
+		returnPaths, err := parseReturnPaths(v)
+		if err != nil {
+			log.Fatalf("RETURN_PATH_BY_DOMAIN: %v\n", err)
+		}
+		cfg.returnPaths = returnPaths
+	}
+	if v := os.Getenv("RETURN_PATH"); v != "" {
+		if !emailRegexp.MatchString(v) {
+			log.Fatalf("RETURN_PATH: \"%s\" is not valid email\n", v)
+		}
+		cfg.defaultReturnPath = v
+	}
+
+	cfg.configurationSet = os.Getenv("AWS_SES_CONFIGURATION_SET")
+	cfg.allowedConfigurationSets = map[string]bool{}
+	if v := os.Getenv("AWS_SES_ALLOWED_CONFIGURATION_SETS"); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); len(name) > 0 {
+				cfg.allowedConfigurationSets[name] = true
+			}
+		}
+	}
+	if v := os.Getenv("TYPE_CONFIGURATION_SETS"); v != "" {
+		configurationSets, err := parseTypeValues(v)
+		if err != nil {
+			log.Fatalf("TYPE_CONFIGURATION_SETS: %v\n", err)
+		}
+		cfg.typeConfigurationSets = configurationSets
+	}
+	if v := os.Getenv("TYPE_FROM_ADDRESSES"); v != "" {
+		fromAddresses, err := parseTypeValues(v)
+		if err != nil {
+			log.Fatalf("TYPE_FROM_ADDRESSES: %v\n", err)
+		}
+		for _, address := range fromAddresses {
+			if !emailRegexp.MatchString(address) {
+				log.Fatalf("TYPE_FROM_ADDRESSES: \"%s\" is not valid email\n", address)
+			}
+		}
+		cfg.typeFromAddresses = fromAddresses
+	}
+	if v := os.Getenv("TYPE_RATE_LIMITS"); v != "" {
+		rates, err := parseTypeRates(v)
+		if err != nil {
+			log.Fatalf("TYPE_RATE_LIMITS: %v\n", err)
+		}
+		cfg.typeRateLimits = rates
+	}
+	if v := os.Getenv("DOMAIN_RATE_LIMITS"); v != "" {
+		rates, err := parseDomainRates(v)
+		if err != nil {
+			log.Fatalf("DOMAIN_RATE_LIMITS: %v\n", err)
+		}
+		cfg.domainRateLimits = rates
+	}
+
+	cfg.recipientWarningThreshold = getEnvInt("RECIPIENT_WARNING_THRESHOLD")
+	cfg.maxRecipientLength = defaultMaxRecipientLength
+	if os.Getenv("MAX_RECIPIENT_LENGTH") != "" {
+		cfg.maxRecipientLength = getEnvInt("MAX_RECIPIENT_LENGTH")
+	}
+	cfg.groupsFile = os.Getenv("GROUPS_FILE")
+	cfg.addressRewriteFile = os.Getenv("ADDRESS_REWRITE_FILE")
+	cfg.groupsURL = os.Getenv("GROUPS_URL")
+	cfg.defaultCc = getEnvAddresses("DEFAULT_CC")
+	cfg.defaultBcc = getEnvAddresses("DEFAULT_BCC")
+	cfg.defaultReplyTo = getEnvAddresses("DEFAULT_REPLY_TO")
+
+	if v := os.Getenv("AMQP_PREFETCH_SIZE"); v != "" {
+		prefetchSize, err := strconv.Atoi(v)
+		if err != nil || prefetchSize < 0 {
+			log.Fatalf("AMQP_PREFETCH_SIZE: %s is not valid size\n", v)
+		}
+		cfg.amqpPrefetchSize = prefetchSize
+	}
+
+	cfg.amqpHeartbeat = getEnvDuration("AMQP_HEARTBEAT")
+	cfg.amqpChannelRetryDelay = getEnvDuration("AMQP_CHANNEL_RETRY_DELAY")
+	if cfg.amqpChannelRetryDelay == 0 {
+		cfg.amqpChannelRetryDelay = defaultAMQPChannelRetryDelay
+	}
+	cfg.amqpPassiveDeclare = getEnvBool("AMQP_PASSIVE_DECLARE")
+	cfg.amqpDelayedExchange = os.Getenv("AMQP_DELAYED_EXCHANGE")
+	cfg.amqpRetryDelay = getEnvDuration("AMQP_RETRY_DELAY")
+	if cfg.amqpRetryDelay > 0 && cfg.amqpRetryDelay < time.Millisecond {
+		log.Fatalf("AMQP_RETRY_DELAY must be at least 1ms\n")
+	}
+	cfg.maxRetries = getEnvInt("MAX_RETRIES")
+	cfg.amqpDLQ = os.Getenv("AMQP_DLQ")
+	cfg.amqpStatusQueue = os.Getenv("AMQP_STATUS_QUEUE")
+	cfg.webhookURL = os.Getenv("WEBHOOK_URL")
+	if v := os.Getenv("WEBHOOK_SECRET"); v != "" {
+		cfg.webhookSecret = []byte(v)
+	}
+	cfg.rejectionPolicy = rejectionAck
+	if v := os.Getenv("SES_REJECTION_POLICY"); v != "" {
+		if v != rejectionAck && v != rejectionDLQ {
+			log.Fatalf("SES_REJECTION_POLICY must be %s or %s\n", rejectionAck, rejectionDLQ)
+		}
+		if v == rejectionDLQ && cfg.amqpDLQ == "" {
+			log.Fatalf("SES_REJECTION_POLICY: %s requires AMQP_DLQ\n", rejectionDLQ)
+		}
+		cfg.rejectionPolicy = v
+	}
+	cfg.invalidRecipientPolicy = invalidRecipientFail
+	if v := os.Getenv("INVALID_RECIPIENT_POLICY"); v != "" {
+		if v != invalidRecipientFail && v != invalidRecipientDrop {
+			log.Fatalf("INVALID_RECIPIENT_POLICY must be %s or %s\n", invalidRecipientFail, invalidRecipientDrop)
+		}
+		cfg.invalidRecipientPolicy = v
+	}
+	if v := os.Getenv("DUPLICATE_ATTACHMENT_POLICY"); v != "" {
+		if v != duplicateAttachmentReject && v != duplicateAttachmentRename {
+			log.Fatalf("DUPLICATE_ATTACHMENT_POLICY must be %s or %s\n", duplicateAttachmentReject, duplicateAttachmentRename)
+		}
+		cfg.duplicateAttachmentPolicy = v
+	}
+	cfg.attachmentVolume = os.Getenv("ATTACHMENT_VOLUME")
+	if v := os.Getenv("ATTACHMENT_MIME_TYPES"); v != "" {
+		mimeTypes, err := parseMIMETypes(v)
+		if err != nil {
+			log.Fatalf("ATTACHMENT_MIME_TYPES: %v\n", err)
+		}
+		cfg.attachmentMIMETypes = mimeTypes
+	}
+	if v := os.Getenv("EMPTY_ATTACHMENT_POLICY"); v != "" {
+		if v != emptyAttachmentReject && v != emptyAttachmentSkip {
+			log.Fatalf("EMPTY_ATTACHMENT_POLICY must be %s or %s\n", emptyAttachmentReject, emptyAttachmentSkip)
+		}
+		cfg.emptyAttachmentPolicy = v
+	}
+	cfg.plusAddressing = plusAddressingPreserve
+	if v := os.Getenv("PLUS_ADDRESSING"); v != "" {
+		if v != plusAddressingPreserve && v != plusAddressingStrip {
+			log.Fatalf("PLUS_ADDRESSING must be %s or %s\n", plusAddressingPreserve, plusAddressingStrip)
+		}
+		cfg.plusAddressing = v
+	}
+	cfg.batchFailurePolicy = batchRequeue
+	if v := os.Getenv("BATCH_FAILURE_POLICY"); v != "" {
+		if v != batchRequeue && v != batchSplit {
+			log.Fatalf("BATCH_FAILURE_POLICY must be %s or %s\n", batchRequeue, batchSplit)
+		}
+		cfg.batchFailurePolicy = v
+	}
+	cfg.statsAddr = os.Getenv("STATS_ADDR")
+	cfg.emfMetrics = getEnvBool("EMF_METRICS")
+	cfg.emitSendEvents = getEnvBool("EMIT_SEND_EVENTS")
+	cfg.emfMaxTenants = getEnvInt("EMF_MAX_TENANTS")
+	cfg.messageDeadline = getEnvDuration("MESSAGE_DEADLINE")
+	cfg.maxSendTimeout = getEnvDuration("MAX_SEND_TIMEOUT")
+	cfg.maxPayloadBytes = getEnvInt("MAX_PAYLOAD_BYTES")
+	cfg.maxMessageAge = getEnvDuration("MAX_MESSAGE_AGE")
+	cfg.payloadSchema = os.Getenv("PAYLOAD_SCHEMA")
+	cfg.maxEmailSize = getEnvInt("MAX_EMAIL_SIZE")
+	cfg.maxHeaderSize = getEnvInt("MAX_HEADER_SIZE")
+	cfg.attachmentCacheSize = getEnvInt("ATTACHMENT_CACHE_SIZE")
+	cfg.attachmentFetchPolicy = attachmentFetchFail
+	if v := os.Getenv("ATTACHMENT_FETCH_POLICY"); v != "" {
+		if v != attachmentFetchFail && v != attachmentFetchDegrade {
+			log.Fatalf("ATTACHMENT_FETCH_POLICY must be %s or %s\n", attachmentFetchFail, attachmentFetchDegrade)
+		}
+		cfg.attachmentFetchPolicy = v
+	}
+	cfg.attachmentFetchConcurrency = defaultAttachmentFetchConcurrency
+	if os.Getenv("ATTACHMENT_FETCH_CONCURRENCY") != "" {
+		cfg.attachmentFetchConcurrency = getEnvInt("ATTACHMENT_FETCH_CONCURRENCY")
+		if cfg.attachmentFetchConcurrency < 1 {
+			log.Fatalf("ATTACHMENT_FETCH_CONCURRENCY must be at least 1\n")
+		}
+	}
+	cfg.maxAttachmentFetches = getEnvInt("MAX_ATTACHMENT_FETCHES")
+	if v := os.Getenv("SUBJECT_REGEX"); v != "" {
+		subjectRegexp, err := regexp.Compile(v)
+		if err != nil {
+			log.Fatalf("SUBJECT_REGEX: %v\n", err)
+		}
+		cfg.subjectRegexp = subjectRegexp
+	}
+	if v := os.Getenv("SUBJECT_LENGTH_POLICY"); v != "" {
+		if v != subjectLengthReject && v != subjectLengthTruncate {
+			log.Fatalf("SUBJECT_LENGTH_POLICY must be %s or %s\n", subjectLengthReject, subjectLengthTruncate)
+		}
+		cfg.subjectLengthPolicy = v
+	}
+	cfg.htmlValidation = os.Getenv("HTML_VALIDATION")
+	if cfg.htmlValidation != "" && cfg.htmlValidation != htmlValidationWarn && cfg.htmlValidation != htmlValidationReject {
+		log.Fatalf("HTML_VALIDATION must be %s or %s\n", htmlValidationWarn, htmlValidationReject)
+	}
+	cfg.contentGuard = os.Getenv("CONTENT_GUARD")
+	if cfg.contentGuard != "" && cfg.contentGuard != contentGuardWarn && cfg.contentGuard != contentGuardReject {
+		log.Fatalf("CONTENT_GUARD must be %s or %s\n", contentGuardWarn, contentGuardReject)
+	}
+	cfg.contentGuardMinBody = defaultContentGuardMinBody
+	if v := os.Getenv("CONTENT_GUARD_MIN_BODY"); v != "" {
+		minBody, err := strconv.Atoi(v)
+		if err != nil || minBody < 0 {
+			log.Fatalf("CONTENT_GUARD_MIN_BODY: %s is not valid length\n", v)
+		}
+		cfg.contentGuardMinBody = minBody
+	}
+	if os.Getenv("SES_FEEDBACK_FORWARDING") != "" {
+		feedbackForwarding := getEnvBool("SES_FEEDBACK_FORWARDING")
+		cfg.feedbackForwarding = &feedbackForwarding
+	}
+	if getEnvBool("CHAOS_MODE") {
+		rate, err := strconv.ParseFloat(getEnv("CHAOS_FAILURE_RATE"), 64)
+		if err != nil || rate < 0 || rate > 100 {
+			log.Fatalf("CHAOS_FAILURE_RATE must be a percentage from 0 to 100\n")
+		}
+		cfg.chaosFailureRate = rate / 100
+	}
+	cfg.trustProducer = getEnvBool("TRUST_PRODUCER")
+	cfg.logSESRequestIDs = getEnvBool("LOG_SES_REQUEST_IDS")
+	cfg.keepBodyWhitespace = getEnvBool("KEEP_BODY_WHITESPACE")
+	cfg.allowEmptyBody = getEnvBool("ALLOW_EMPTY_BODY")
+	cfg.multipartPayloads = getEnvBool("MULTIPART_PAYLOADS")
+	cfg.templateDir = os.Getenv("TEMPLATE_DIR")
+	cfg.stripTrackingPixels = getEnvBool("STRIP_TRACKING_PIXELS")
+	cfg.inlineCSS = getEnvBool("INLINE_CSS")
+	cfg.validateMX = getEnvBool("VALIDATE_MX")
+	cfg.lookupFailurePolicy = lookupFailOpen
+	if v := os.Getenv("LOOKUP_FAILURE_POLICY"); v != "" {
+		if v != lookupFailOpen && v != lookupFailClosed {
+			log.Fatalf("LOOKUP_FAILURE_POLICY must be %s or %s\n", lookupFailOpen, lookupFailClosed)
+		}
+		cfg.lookupFailurePolicy = v
+	}
+	cfg.sesSimulator = getEnvBool("SES_SIMULATOR")
+
+	cfg.interMessageDelay = getEnvDuration("INTER_MESSAGE_DELAY")
+	if v := os.Getenv("SES_ADAPTIVE_MAX_RATE"); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil || rate <= 0 {
+			log.Fatalf("SES_ADAPTIVE_MAX_RATE: %s is not valid rate\n", v)
+		}
+		cfg.adaptiveMaxRate = rate
+		cfg.adaptiveMinRate = defaultAdaptiveMinRate
+	}
+	if v := os.Getenv("SES_ADAPTIVE_MIN_RATE"); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil || rate <= 0 {
+			log.Fatalf("SES_ADAPTIVE_MIN_RATE: %s is not valid rate\n", v)
+		}
+		cfg.adaptiveMinRate = rate
+	}
+	cfg.maxRecipientsPerHour = getEnvInt("MAX_RECIPIENTS_PER_HOUR")
+	cfg.quotaCheckInterval = getEnvDuration("SES_QUOTA_CHECK_INTERVAL")
+	cfg.quotaReserve = getEnvInt("SES_QUOTA_RESERVE")
+	cfg.quotaPause = getEnvBool("SES_QUOTA_PAUSE")
+	cfg.accountPauseCheckInterval = getEnvDuration("SES_PAUSE_CHECK_INTERVAL")
+
+	if v := os.Getenv("WARMUP_SCHEDULE"); v != "" {
+		schedule, err := parseWarmUpSchedule(v)
+		if err != nil {
+			log.Fatalf("WARMUP_SCHEDULE: %v\n", err)
+		}
+		cfg.warmUpSchedule = schedule
+		// the schedule must not start over on every restart
+		start, err := time.Parse("2006-01-02", os.Getenv("WARMUP_START"))
+		if err != nil {
+			log.Fatalf("WARMUP_START must be set with WARMUP_SCHEDULE: %v\n", err)
+		}
+		cfg.warmUpStart = start
+		if cfg.amqpDelayedExchange == "" && cfg.amqpRetryDelay == 0 {
+			log.Fatalf("WARMUP_SCHEDULE requires AMQP_DELAYED_EXCHANGE or AMQP_RETRY_DELAY to defer the emails over the daily cap\n")
+		}
+	}
+
+	return cfg
+}
+
+// allFromAddresses lists the round-robin From addresses followed by the fallback
+// ones and the ones configured for the email types.
+func (cfg *config) allFromAddresses() []string {
+	var addresses []string
+	seen := map[string]bool{}
+	for _, address := range append(append([]string{}, cfg.fromAddresses...), cfg.fromFallbacks...) {
+		if !seen[address] {
+			seen[address] = true
+			addresses = append(addresses, address)
+		}
+	}
+	var typeAddresses []string
+	for _, address := range cfg.typeFromAddresses {
+		if !seen[address] {
+			seen[address] = true
+			typeAddresses = append(typeAddresses, address)
+		}
+	}
+	sort.Strings(typeAddresses)
+	return append(addresses, typeAddresses...)
+}
+
+// maxDestinationsPerCall returns the configured recipients per SES call or the SES limit.
+func (cfg *config) maxDestinationsPerCall() int {
+	if cfg.maxDestinations > 0 {
+		return cfg.maxDestinations
+	}
+	return sesMaxDestinations
+}
+
+// returnPathFor picks the Return-Path matching the domain of the From address.
+func (cfg *config) returnPathFor(fromAddress string) string {
+	domain := strings.ToLower(fromAddress[strings.LastIndex(fromAddress, "@")+1:])
+	if returnPath, ok := cfg.returnPaths[domain]; ok {
+		return returnPath
+	}
+	return cfg.defaultReturnPath
+}
+
+// configurationSetFor picks the configuration set requested by the email or the global one.
+func (cfg *config) configurationSetFor(e *email) string {
+	if len(e.ConfigurationSet) > 0 {
+		return e.ConfigurationSet
+	}
+	if configurationSet, ok := cfg.typeConfigurationSets[e.Type]; ok {
+		return configurationSet
+	}
+	return cfg.configurationSet
+}
+
+// validateConfigurationSet checks the configuration set requested by the email is allowed.
+func (cfg *config) validateConfigurationSet(e *email) error {
+	if len(e.ConfigurationSet) == 0 || e.ConfigurationSet == cfg.configurationSet || cfg.allowedConfigurationSets[e.ConfigurationSet] {
+		return nil
+	}
+	return errInvalidEmail{fmt.Errorf(`configuration set "%s" is not allowed`, e.ConfigurationSet)}
+}
+
+// parseReturnPaths parses the comma-separated domain=address pairs.
+func parseReturnPaths(v string) (map[string]string, error) {
+	returnPaths := map[string]string{}
+	for _, pair := range strings.Split(v, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || len(strings.TrimSpace(parts[0])) == 0 {
+			return nil, fmt.Errorf(`"%s" must be in domain=address form`, pair)
+		}
+		domain := strings.ToLower(strings.TrimSpace(parts[0]))
+		address := strings.TrimSpace(parts[1])
+		if !emailRegexp.MatchString(address) {
+			return nil, fmt.Errorf(`"%s" is not valid email`, address)
+		}
+		returnPaths[domain] = address
+	}
+	return returnPaths, nil
+}
+
+func parseMIMETypes(v string) (map[string]string, error) {
+	mimeTypes := map[string]string{}
+	for _, pair := range strings.Split(v, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || len(strings.TrimSpace(parts[0])) == 0 {
+			return nil, fmt.Errorf(`"%s" must be in extension=type form`, pair)
+		}
+		ext := strings.ToLower(strings.TrimSpace(parts[0]))
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		mediaType := strings.TrimSpace(parts[1])
+		if _, _, err := mime.ParseMediaType(mediaType); err != nil {
+			return nil, fmt.Errorf(`"%s" is not valid media type`, mediaType)
+		}
+		mimeTypes[ext] = mediaType
+	}
+	return mimeTypes, nil
+}
+
+func parseWarmUpSchedule(v string) ([]int, error) {
+	var schedule []int
+	for _, c := range strings.Split(v, ",") {
+		dailyCap, err := strconv.Atoi(strings.TrimSpace(c))
+		if err != nil || dailyCap <= 0 {
+			return nil, fmt.Errorf(`"%s" is not valid daily cap`, c)
+		}
+		schedule = append(schedule, dailyCap)
+	}
+	return schedule, nil
+}
+
+func getEnvBool(k string) bool {
+	v := os.Getenv(k)
+	if v == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Fatalf("%v must be true or false\n", k)
+	}
+	return b
+}
+
+func getEnvAddresses(k string) []string {
+	v := os.Getenv(k)
+	if v == "" {
+		return nil
+	}
+	var addresses []string
+	for _, address := range strings.Split(v, ",") {
+		address = strings.TrimSpace(address)
+		if !emailRegexp.MatchString(address) {
+			log.Fatalf("%v: \"%s\" is not valid email\n", k, address)
+		}
+		addresses = append(addresses, address)
+	}
+	return addresses
+}
+
+func getEnvDuration(k string) time.Duration {
+	v := os.Getenv(k)
+	if v == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Fatalf("%v must be a duration like 30s\n", k)
+	}
+	return d
+}
+
+func getEnvInt(k string) int {
+	v := os.Getenv(k)
+	if v == "" {
+		return 0
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 0 {
+		log.Fatalf("%v must be a positive number\n", k)
+	}
+	return i
+}
@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+func TestReturnPathFor(t *testing.T) {
+	returnPaths, err := parseReturnPaths("a.com=bounces@a.com, B.com = bounces@b.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config{returnPaths: returnPaths, defaultReturnPath: "bounces@default.com"}
+
+	testCases := []struct {
+		from       string
+		returnPath string
+	}{
+		{"info@a.com", "bounces@a.com"},
+		{"info@b.com", "bounces@b.com"},
+		{"info@B.COM", "bounces@b.com"},
+		{"info@c.com", "bounces@default.com"},
+	}
+	for _, testCase := range testCases {
+		if returnPath := cfg.returnPathFor(testCase.from); returnPath != testCase.returnPath {
+			t.Fatalf("%s must use %s, got %s", testCase.from, testCase.returnPath, returnPath)
+		}
+	}
+}
+
+func TestParseReturnPathsValidatesAddresses(t *testing.T) {
+	for _, v := range []string{"a.com=invalid", "a.com", "=bounces@a.com"} {
+		if _, err := parseReturnPaths(v); err == nil {
+			t.Fatalf("%s must be rejected", v)
+		}
+	}
+}
+
+func TestConfigurationSetOverride(t *testing.T) {
+	cfg := &config{configurationSet: "default", allowedConfigurationSets: map[string]bool{"marketing": true}}
+
+	if set := cfg.configurationSetFor(&email{}); set != "default" {
+		t.Fatal("global configuration set must be used by default, got", set)
+	}
+	if set := cfg.configurationSetFor(&email{ConfigurationSet: "marketing"}); set != "marketing" {
+		t.Fatal("email configuration set must override the global one, got", set)
+	}
+	for _, allowed := range []string{"", "default", "marketing"} {
+		if err := cfg.validateConfigurationSet(&email{ConfigurationSet: allowed}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := cfg.validateConfigurationSet(&email{ConfigurationSet: "unknown"})
+	if err == nil || err.Error() != `configuration set "unknown" is not allowed` {
+		t.Fatal("configuration set must be allowlist-checked, got", err)
+	}
+}
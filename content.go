@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// contentGuardWarn logs the emails failing the minimum-content checks.
+	contentGuardWarn = "warn"
+	// contentGuardReject rejects the emails failing the minimum-content checks.
+	contentGuardReject = "reject"
+
+	defaultContentGuardMinBody = 20
+)
+
+var (
+	urlOnlyRegexp = regexp.MustCompile(`(?i)^(https?://|www\.)\S+$`)
+	htmlTagRegexp = regexp.MustCompile(`<[^>]*>`)
+)
+
+// checkContent returns the reasons why the email looks like spam to the filters.
+func checkContent(e *email, minBodyLength int) []string {
+	var problems []string
+	if urlOnlyRegexp.MatchString(e.Subject) {
+		problems = append(problems, "subject is only a URL")
+	}
+
+	body := e.TextBody
+	if len(body) == 0 {
+		body = htmlTagRegexp.ReplaceAllString(e.HTMLBody, "")
+	}
+	if bodyLength := utf8.RuneCountInString(strings.TrimSpace(body)); bodyLength < minBodyLength {
+		problems = append(problems, fmt.Sprintf("body is %d characters, it is shorter than %d", bodyLength, minBodyLength))
+	}
+	return problems
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"github.com/streadway/amqp"
+	"testing"
+)
+
+func TestCheckContent(t *testing.T) {
+	testCases := []struct {
+		email    email
+		problems []string
+	}{
+		{
+			email{Subject: "Your order", TextBody: "Your order has been shipped today."},
+			nil,
+		},
+		{
+			email{Subject: "Your order", TextBody: "Hi"},
+			[]string{"body is 2 characters, it is shorter than 20"},
+		},
+		{
+			email{Subject: "Your order", HTMLBody: "<p><strong>Hi</strong> there</p>"},
+			[]string{"body is 8 characters, it is shorter than 20"},
+		},
+		{
+			email{Subject: "https://example.com/offer", TextBody: "Your order has been shipped today."},
+			[]string{"subject is only a URL"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		problems := checkContent(&testCase.email, defaultContentGuardMinBody)
+		if len(problems) != len(testCase.problems) {
+			t.Fatalf("%#v must be flagged with %v, got %v", testCase.email, testCase.problems, problems)
+		}
+		for i := range problems {
+			if problems[i] != testCase.problems[i] {
+				t.Fatalf("%#v must be flagged with %v, got %v", testCase.email, testCase.problems, problems)
+			}
+		}
+	}
+}
+
+func TestContentGuardRejectsEmail(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{contentGuard: contentGuardReject, contentGuardMinBody: defaultContentGuardMinBody}, sender)
+	acknowledger := &fakeAcknowledger{}
+
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: []byte(`{"to":"to@test.com","subject":"Wow","text_body":"Hi"}`)})
+
+	if len(sender.inputs) != 0 {
+		t.Fatal("email failing the content check must not be sent")
+	}
+	if acknowledger.nacked != 1 || acknowledger.requeued != 0 {
+		t.Fatal("email must be rejected without requeue", acknowledger)
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import "github.com/vanng822/go-premailer/premailer"
+
+// inlineCSS moves the rules of the <style> blocks of the HTML body into the
+// style attributes of the matching elements, as many email clients ignore <style>.
+func (e *email) inlineCSS() error {
+	if len(e.HTMLBody) == 0 {
+		return nil
+	}
+	prem, err := premailer.NewPremailerFromString(e.HTMLBody, premailer.NewOptions())
+	if err != nil {
+		return err
+	}
+	htmlBody, err := prem.Transform()
+	if err != nil {
+		return err
+	}
+	e.HTMLBody = htmlBody
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInlineCSS(t *testing.T) {
+	e := &email{HTMLBody: `<html><head><style>
+h1 { color: red; }
+.note { font-size: 12px; }
+</style></head><body><h1>Hello</h1><p class="note">Small print</p><p>Plain</p></body></html>`}
+
+	err := e.inlineCSS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(e.HTMLBody, `<h1 style="color:red">Hello</h1>`) {
+		t.Fatal("h1 rule must be inlined", e.HTMLBody)
+	}
+	if !strings.Contains(e.HTMLBody, `<p class="note" style="font-size:12px">Small print</p>`) {
+		t.Fatal("class rule must be inlined", e.HTMLBody)
+	}
+	if !strings.Contains(e.HTMLBody, `<p>Plain</p>`) {
+		t.Fatal("unmatched elements must be kept as they are", e.HTMLBody)
+	}
+	if strings.Contains(e.HTMLBody, "<style>") {
+		t.Fatal("inlined style block must be removed", e.HTMLBody)
+	}
+}
+
+func TestInlineCSSWithoutHTMLBody(t *testing.T) {
+	e := &email{TextBody: "text body"}
+
+	if err := e.inlineCSS(); err != nil || e.HTMLBody != "" {
+		t.Fatal("text only email must be left as it is", err, e.HTMLBody)
+	}
+}
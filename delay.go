@@ -0,0 +1,57 @@
+package main
+
+import (
+	"github.com/streadway/amqp"
+	"time"
+)
+
+const (
+	delayedExchangeType = "x-delayed-message"
+	// maxDelay is the longest delay_ms, the x-delay of the delayed message
+	// exchange is an unsigned 32-bit number of milliseconds.
+	maxDelay = (1<<32 - 1) * time.Millisecond
+)
+
+// delay returns how long the email asks to be held back before sending.
+func (e *email) delay(now time.Time) time.Duration {
+	delay := maxDelay
+	// delay_ms is clamped before it is converted, it could overflow the duration
+	if e.DelayMs < int64(maxDelay/time.Millisecond) {
+		delay = time.Duration(e.DelayMs) * time.Millisecond
+	}
+	if !e.SendAfter.IsZero() {
+		if untilSendAfter := e.SendAfter.Sub(now); untilSendAfter > delay {
+			delay = untilSendAfter
+		}
+	}
+	return delay
+}
+
+// retryExpired reports whether the retry deadline of the email passed.
+func (e *email) retryExpired(now time.Time) bool {
+	return !e.RetryUntil.IsZero() && now.After(e.RetryUntil)
+}
+
+// isDelayed reports whether the message already went through the delayed exchange.
+func isDelayed(message amqp.Delivery) bool {
+	_, ok := message.Headers["x-delay"]
+	return ok
+}
+
+func declareDelayedExchange(amqpChannel amqpChannel, cfg *config) error {
+	err := amqpChannel.ExchangeDeclare(cfg.amqpDelayedExchange, delayedExchangeType, true, false, false, false, amqp.Table{
+		"x-delayed-type": "direct",
+	})
+	if err != nil {
+		return err
+	}
+	return amqpChannel.QueueBind(cfg.amqpQueue, cfg.amqpQueue, cfg.amqpDelayedExchange, false, nil)
+}
+
+// publishDelayed republishes the message with its properties to the delayed
+// exchange, so the broker routes it back to the queue once the delay elapses.
+func publishDelayed(amqpChannel amqpChannel, cfg *config, message amqp.Delivery, delay time.Duration) error {
+	publishing := republishing(message)
+	publishing.Headers["x-delay"] = int64(delay / time.Millisecond)
+	return amqpChannel.Publish(cfg.amqpDelayedExchange, cfg.amqpQueue, false, false, publishing)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"github.com/streadway/amqp"
+	"testing"
+	"time"
+)
+
+func TestEmailDelay(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		email email
+		delay time.Duration
+	}{
+		{email{}, 0},
+		{email{DelayMs: 1500}, 1500 * time.Millisecond},
+		{email{SendAfter: now.Add(time.Hour)}, time.Hour},
+		{email{SendAfter: now.Add(-time.Hour)}, 0},
+		{email{DelayMs: 1000, SendAfter: now.Add(time.Minute)}, time.Minute},
+		{email{DelayMs: 1 << 62}, maxDelay},
+		{email{DelayMs: int64(maxDelay / time.Millisecond)}, maxDelay},
+	}
+
+	for _, testCase := range testCases {
+		if delay := testCase.email.delay(now); delay != testCase.delay {
+			t.Fatalf("%#v must be delayed by %s, got %s", testCase.email, testCase.delay, delay)
+		}
+	}
+}
+
+func TestPublishDelayedSetsDelayHeader(t *testing.T) {
+	channel := &fakeChannel{}
+	cfg := &config{amqpQueue: "aws.ses.mailer", amqpDelayedExchange: "aws.ses.mailer.delayed"}
+	message := amqp.Delivery{
+		Body:          []byte(`{"delay_ms":90000}`),
+		Headers:       amqp.Table{"x-trace": "abc"},
+		ContentType:   "application/json",
+		CorrelationId: "order-42",
+		MessageId:     "message-42",
+		ReplyTo:       "replies",
+		Priority:      5,
+		Timestamp:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	err := publishDelayed(channel, cfg, message, 90*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(channel.published) != 1 {
+		t.Fatal("published messages", channel.published)
+	}
+	published := channel.published[0]
+	if published.exchange != "aws.ses.mailer.delayed" || published.key != "aws.ses.mailer" {
+		t.Fatal("published to", published.exchange, published.key)
+	}
+	if published.msg.Headers["x-delay"] != int64(90000) {
+		t.Fatal("x-delay header", published.msg.Headers["x-delay"])
+	}
+	if published.msg.Headers["x-trace"] != "abc" {
+		t.Fatal("original headers must be kept", published.msg.Headers)
+	}
+	if string(published.msg.Body) != `{"delay_ms":90000}` {
+		t.Fatal("body", string(published.msg.Body))
+	}
+	if published.msg.CorrelationId != "order-42" || published.msg.MessageId != "message-42" || published.msg.ReplyTo != "replies" ||
+		published.msg.Priority != 5 || !published.msg.Timestamp.Equal(message.Timestamp) || published.msg.ContentType != "application/json" {
+		t.Fatal("message properties must be kept", published.msg)
+	}
+	if _, ok := message.Headers["x-delay"]; ok {
+		t.Fatal("consumed message headers must not be modified", message.Headers)
+	}
+	if !isDelayed(amqp.Delivery{Headers: published.msg.Headers}) {
+		t.Fatal("delayed message must be recognized")
+	}
+}
+
+func TestDeclareDelayedExchange(t *testing.T) {
+	channel := &fakeChannel{}
+	cfg := &config{amqpQueue: "aws.ses.mailer", amqpDelayedExchange: "aws.ses.mailer.delayed"}
+
+	err := declareDelayedExchange(channel, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(channel.exchanges) != 1 || channel.exchanges[0] != "aws.ses.mailer.delayed:x-delayed-message" {
+		t.Fatal("declared exchanges", channel.exchanges)
+	}
+	if len(channel.bindings) != 1 || channel.bindings[0] != "aws.ses.mailer.delayed->aws.ses.mailer" {
+		t.Fatal("queue bindings", channel.bindings)
+	}
+}
+
+func TestRetriesStopAfterRetryUntil(t *testing.T) {
+	m := newTestMailer(&config{amqpDLQ: "aws.ses.mailer.dlq"}, &fakeSES{})
+	channel := m.channel.(*fakeChannel)
+	body := []byte(`{"to":"to@test.com","subject":"Your code","text_body":"123456"}`)
+
+	pending := &fakeAcknowledger{}
+	m.retryLater(amqp.Delivery{Acknowledger: pending, Body: body}, &email{RetryUntil: time.Now().Add(time.Hour)}, errors.New("temporary failure"))
+	if pending.requeued != 1 || len(channel.published) != 0 {
+		t.Fatal("email must be retried before the deadline", pending)
+	}
+
+	expired := &fakeAcknowledger{}
+	m.retryLater(amqp.Delivery{Acknowledger: expired, Body: body}, &email{RetryUntil: time.Now().Add(-time.Second)}, errors.New("temporary failure"))
+	if expired.requeued != 0 || expired.acked != 1 {
+		t.Fatal("email must not be retried after the deadline", expired)
+	}
+	if len(channel.published) != 1 || channel.published[0].key != "aws.ses.mailer.dlq" {
+		t.Fatal("email must be dead-lettered after the deadline", channel.published)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"sort"
+	"strings"
+)
+
+// contentDigestHeader carries the digest of the email content, so downstream
+// systems can detect the duplicates sent under different idempotency keys.
+const contentDigestHeader = "X-Content-Digest"
+
+// digestAlgorithms are the supported values of CONTENT_DIGEST.
+var digestAlgorithms = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// contentDigest returns the "algorithm=hex" digest of the subject, the bodies
+// and the To and Cc recipients, which are lowercased and sorted like for the
+// signature. Bcc is left out as it is not in the headers the receivers see.
+func (e *email) contentDigest(algorithm string) string {
+	recipients := e.signedRecipients()
+	normalized := make([]string, 0, len(recipients))
+	for _, recipient := range recipients {
+		normalized = append(normalized, strings.ToLower(strings.TrimSpace(recipient)))
+	}
+	sort.Strings(normalized)
+
+	h := digestAlgorithms[algorithm]()
+	// the fields are NUL separated, so content can't move from one to the other
+	for _, field := range []string{e.Subject, e.TextBody, e.HTMLBody, strings.Join(normalized, ",")} {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	return algorithm + "=" + hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestContentDigest(t *testing.T) {
+	e := &email{To: "a@test.com,B@test.com", Cc: "c@test.com", Bcc: "d@test.com", Subject: "Wow", TextBody: "text body", HTMLBody: "<p>html body</p>"}
+	same := &email{To: "b@test.com", Cc: "c@test.com,a@test.com", Subject: "Wow", TextBody: "text body", HTMLBody: "<p>html body</p>"}
+	digest := e.contentDigest("sha256")
+	if !strings.HasPrefix(digest, "sha256=") || len(digest) != len("sha256=")+64 {
+		t.Fatal("digest", digest)
+	}
+	if same.contentDigest("sha256") != digest {
+		t.Fatal("identical content must have the same digest")
+	}
+
+	for _, differing := range []*email{
+		{To: "a@test.com,b@test.com", Cc: "c@test.com", Subject: "Wow!", TextBody: "text body", HTMLBody: "<p>html body</p>"},
+		{To: "a@test.com,b@test.com", Cc: "c@test.com", Subject: "Wow", TextBody: "text body.", HTMLBody: "<p>html body</p>"},
+		{To: "a@test.com,b@test.com", Cc: "e@test.com", Subject: "Wow", TextBody: "text body", HTMLBody: "<p>html body</p>"},
+		// the text moved from one field to the other
+		{To: "a@test.com,b@test.com", Cc: "c@test.com", Subject: "Wow", TextBody: "", HTMLBody: "text body<p>html body</p>"},
+	} {
+		if differing.contentDigest("sha256") == digest {
+			t.Fatal("differing content must have another digest", differing)
+		}
+	}
+
+	if digest := e.contentDigest("sha512"); !strings.HasPrefix(digest, "sha512=") || len(digest) != len("sha512=")+128 {
+		t.Fatal("sha512 digest", digest)
+	}
+}
+
+func TestContentDigestHeader(t *testing.T) {
+	e := &email{To: "to@test.com", Subject: "Wow", TextBody: "text body"}
+	input := createEmail(emailOptions{from: "from@someone.com", digestAlgorithm: "sha1"}, e)
+	msg, err := mail.ReadMessage(bytes.NewReader(input.RawMessage.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Header.Get(contentDigestHeader) != e.contentDigest("sha1") {
+		t.Fatal("digest header", msg.Header.Get(contentDigestHeader))
+	}
+
+	input = createEmail(emailOptions{from: "from@someone.com"}, e)
+	if strings.Contains(string(input.RawMessage.Data), contentDigestHeader) {
+		t.Fatal("digest header must not be added by default")
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"github.com/streadway/amqp"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// parseDomainRates parses the comma-separated domain=emails per second pairs.
+func parseDomainRates(v string) (map[string]float64, error) {
+	rates := map[string]float64{}
+	for _, pair := range strings.Split(v, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || len(strings.TrimSpace(parts[0])) == 0 {
+			return nil, fmt.Errorf(`"%s" must be in domain=rate form`, pair)
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf(`"%s" is not valid rate`, strings.TrimSpace(parts[1]))
+		}
+		rates[strings.ToLower(strings.TrimSpace(parts[0]))] = rate
+	}
+	return rates, nil
+}
+
+// newDomainLimiters creates the rate limiter of every recipient domain with a rate.
+func newDomainLimiters(rates map[string]float64) map[string]*rateLimiter {
+	limiters := map[string]*rateLimiter{}
+	for domain, rate := range rates {
+		limiters[domain] = newRateLimiter(rate)
+	}
+	return limiters
+}
+
+// paceDomains waits for a send slot of every recipient at a rate limited
+// domain, so an email to many mailboxes of one domain takes as many slots. It
+// reports whether the email was handed back instead, see holdBack.
+func (m *mailer) paceDomains(message amqp.Delivery, e *email) bool {
+	if len(m.domainLimiters) == 0 {
+		return false
+	}
+	for _, recipient := range e.recipients() {
+		domain := strings.ToLower(recipient[strings.LastIndex(recipient, "@")+1:])
+		limiter := m.domainLimiters[domain]
+		for wait := limiter.reserve(); wait > 0; wait = limiter.reserve() {
+			log.Println(domain, "send rate limit is reached, waiting", wait)
+			if m.holdBack(message, e, wait) {
+				return true
+			}
+		}
+	}
+	return false
+}
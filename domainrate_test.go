@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/streadway/amqp"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDomainRates(t *testing.T) {
+	rates, err := parseDomainRates("BigCorp.com=2, small.org = 0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rates["bigcorp.com"] != 2 || rates["small.org"] != 0.5 {
+		t.Fatal("rates", rates)
+	}
+	for _, v := range []string{"bigcorp.com", "=2", "bigcorp.com=0", "bigcorp.com=fast"} {
+		if _, err := parseDomainRates(v); err == nil {
+			t.Fatal(v, "must be rejected")
+		}
+	}
+}
+
+func TestPaceDomains(t *testing.T) {
+	log.SetOutput(&bytes.Buffer{})
+	defer log.SetOutput(os.Stderr)
+
+	m := newTestMailer(&config{}, &fakeSES{})
+	m.domainLimiters = newDomainLimiters(map[string]float64{"bigcorp.com": 100})
+
+	var recipients []string
+	for i := 0; i < 6; i++ {
+		recipients = append(recipients, fmt.Sprintf("user%d@BigCorp.com", i))
+	}
+	start := time.Now()
+	m.paceDomains(amqp.Delivery{Acknowledger: &fakeAcknowledger{}}, &email{To: strings.Join(recipients, ",")})
+	if elapsed := time.Since(start); elapsed < 45*time.Millisecond {
+		t.Fatal("recipients of the limited domain must be paced", elapsed)
+	}
+
+	start = time.Now()
+	m.paceDomains(amqp.Delivery{Acknowledger: &fakeAcknowledger{}}, &email{To: "a@other.com,b@other.com,c@other.com"})
+	if elapsed := time.Since(start); elapsed > 5*time.Millisecond {
+		t.Fatal("recipients of the other domains must not wait", elapsed)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	emfNamespace = "aws-ses-mailer"
+
+	// defaultEMFMaxTenants is the number of distinct tenants labelled in the
+	// metrics, each one is a separate CloudWatch metric.
+	defaultEMFMaxTenants = 100
+	// emfOtherTenant labels the tenants seen after the limit is reached.
+	emfOtherTenant = "other"
+)
+
+// emfEmitter writes the send metrics in the CloudWatch Embedded Metric Format,
+// one JSON line per send, CloudWatch extracts the metrics from the logs.
+type emfEmitter struct {
+	mu  sync.Mutex
+	out io.Writer
+	now func() time.Time
+	// maxTenants caps the distinct tenant labels, tenants holds the labelled ones.
+	maxTenants int
+	tenants    map[string]bool
+}
+
+func newEMFEmitter(out io.Writer) *emfEmitter {
+	return &emfEmitter{out: out, now: time.Now, maxTenants: defaultEMFMaxTenants, tenants: map[string]bool{}}
+}
+
+type emfMetric struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit"`
+}
+
+type emfDirective struct {
+	Namespace  string      `json:"Namespace"`
+	Dimensions [][]string  `json:"Dimensions"`
+	Metrics    []emfMetric `json:"Metrics"`
+}
+
+type emfMetadata struct {
+	Timestamp         int64          `json:"Timestamp"`
+	CloudWatchMetrics []emfDirective `json:"CloudWatchMetrics"`
+}
+
+type emfRecord struct {
+	AWS      emfMetadata `json:"_aws"`
+	Service  string      `json:"Service"`
+	Type     string      `json:"Type,omitempty"`
+	Tenant   string      `json:"Tenant,omitempty"`
+	Sent     int         `json:"Sent"`
+	Failed   int         `json:"Failed"`
+	Duration float64     `json:"Duration"`
+}
+
+// emit writes the outcome and the duration of one send, it does nothing when
+// the emitter is disabled. The emails with a type or a tenant are also counted
+// per type or tenant.
+func (e *emfEmitter) emit(messageType, tenant string, sent bool, duration time.Duration) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	dimensions := [][]string{{"Service"}}
+	if len(messageType) > 0 {
+		dimensions = append(dimensions, []string{"Service", "Type"})
+	}
+	if len(tenant) > 0 {
+		tenant = e.tenantLabel(tenant)
+		dimensions = append(dimensions, []string{"Service", "Tenant"})
+	}
+	record := emfRecord{
+		AWS: emfMetadata{
+			Timestamp: e.now().UnixNano() / int64(time.Millisecond),
+			CloudWatchMetrics: []emfDirective{{
+				Namespace:  emfNamespace,
+				Dimensions: dimensions,
+				Metrics: []emfMetric{
+					{Name: "Sent", Unit: "Count"},
+					{Name: "Failed", Unit: "Count"},
+					{Name: "Duration", Unit: "Milliseconds"},
+				},
+			}},
+		},
+		Service:  emfNamespace,
+		Type:     messageType,
+		Tenant:   tenant,
+		Duration: float64(duration) / float64(time.Millisecond),
+	}
+	if sent {
+		record.Sent = 1
+	} else {
+		record.Failed = 1
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.Println("emf metrics err", err)
+		return
+	}
+	e.out.Write(append(line, '\n'))
+}
+
+// tenantLabel returns the tenant itself while it is one of the first
+// maxTenants tenants seen and "other" afterwards.
+func (e *emfEmitter) tenantLabel(tenant string) string {
+	if e.tenants[tenant] {
+		return tenant
+	}
+	if len(e.tenants) >= e.maxTenants {
+		return emfOtherTenant
+	}
+	e.tenants[tenant] = true
+	return tenant
+}
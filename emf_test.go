@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/streadway/amqp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEMFLineAfterSend(t *testing.T) {
+	var out bytes.Buffer
+	m := newTestMailer(&config{}, &fakeSES{})
+	m.emf = newEMFEmitter(&out)
+	m.emf.now = func() time.Time { return time.Date(2020, 1, 17, 10, 0, 0, 0, time.UTC) }
+
+	m.handle(amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body"}`)})
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 1 {
+		t.Fatal("one EMF line must be written per send", out.String())
+	}
+	var record struct {
+		AWS struct {
+			Timestamp         int64
+			CloudWatchMetrics []struct {
+				Namespace  string
+				Dimensions [][]string
+				Metrics    []struct{ Name, Unit string }
+			}
+		} `json:"_aws"`
+		Service  string
+		Sent     int
+		Failed   int
+		Duration *float64
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.AWS.Timestamp != 1579255200000 || len(record.AWS.CloudWatchMetrics) != 1 {
+		t.Fatal("EMF metadata", lines[0])
+	}
+	directive := record.AWS.CloudWatchMetrics[0]
+	if directive.Namespace != emfNamespace || len(directive.Metrics) != 3 || len(directive.Dimensions) != 1 || directive.Dimensions[0][0] != "Service" {
+		t.Fatal("EMF directive", lines[0])
+	}
+	if record.Service != emfNamespace || record.Sent != 1 || record.Failed != 0 || record.Duration == nil {
+		t.Fatal("EMF values", lines[0])
+	}
+}
+
+func TestDisabledEMFEmitter(t *testing.T) {
+	var e *emfEmitter
+	e.emit("", "", true, time.Second)
+}
+
+func TestEMFLabelsTypeAndTenant(t *testing.T) {
+	var out bytes.Buffer
+	m := newTestMailer(&config{}, &fakeSES{})
+	m.emf = newEMFEmitter(&out)
+	m.emf.maxTenants = 2
+
+	for _, body := range []string{
+		`{"type":"marketing","tenant":"acme","to":"to@test.com","subject":"Sale","text_body":"text body"}`,
+		`{"type":"transactional","tenant":"globex","to":"to@test.com","subject":"Receipt","text_body":"text body"}`,
+		`{"type":"transactional","tenant":"initech","to":"to@test.com","subject":"Receipt","text_body":"text body"}`,
+		`{"tenant":"acme","to":"to@test.com","subject":"Hello","text_body":"text body"}`,
+	} {
+		m.handle(amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: []byte(body)})
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatal("one EMF line must be written per send", out.String())
+	}
+	expected := []struct {
+		messageType, tenant string
+		dimensions          int
+	}{
+		{"marketing", "acme", 3},
+		{"transactional", "globex", 3},
+		{"transactional", emfOtherTenant, 3},
+		{"", "acme", 2},
+	}
+	for i, line := range lines {
+		var record struct {
+			AWS struct {
+				CloudWatchMetrics []struct{ Dimensions [][]string }
+			} `json:"_aws"`
+			Type   string
+			Tenant string
+		}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		if record.Type != expected[i].messageType || record.Tenant != expected[i].tenant {
+			t.Fatal("labels", line)
+		}
+		dimensions := record.AWS.CloudWatchMetrics[0].Dimensions
+		if len(dimensions) != expected[i].dimensions || strings.Join(dimensions[len(dimensions)-1], ",") != "Service,Tenant" {
+			t.Fatal("dimensions", line)
+		}
+	}
+}
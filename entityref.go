@@ -0,0 +1,34 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+)
+
+// entityRefIDHeader makes Gmail treat every email as a separate conversation,
+// it doesn't thread the emails with one subject then.
+const entityRefIDHeader = "X-Entity-Ref-ID"
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	uuid := make([]byte, 16)
+	rand.Read(uuid)
+	uuid[6] = uuid[6]&0x0f | 0x40
+	uuid[8] = uuid[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:])
+}
+
+// addEntityRefID sets a random X-Entity-Ref-ID unless the producer set one in
+// the headers of the email.
+func (e *email) addEntityRefID() {
+	for name := range e.Headers {
+		if strings.EqualFold(name, entityRefIDHeader) {
+			return
+		}
+	}
+	if e.Headers == nil {
+		e.Headers = map[string]string{}
+	}
+	e.Headers[entityRefIDHeader] = newUUID()
+}
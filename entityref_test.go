@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"github.com/streadway/amqp"
+	"net/mail"
+	"regexp"
+	"testing"
+)
+
+var uuidRegexp = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestUniqueEntityRefIDPerEmail(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{entityRefID: true}, sender)
+	body := []byte(`{"to":"to@test.com","subject":"Your code","text_body":"text body"}`)
+
+	m.handle(amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: body})
+	m.handle(amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: body})
+
+	if len(sender.inputs) != 2 {
+		t.Fatal("emails must be sent")
+	}
+	var ids []string
+	for _, input := range sender.inputs {
+		msg, err := mail.ReadMessage(bytes.NewReader(input.RawMessage.Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		id := msg.Header.Get(entityRefIDHeader)
+		if !uuidRegexp.MatchString(id) {
+			t.Fatal("X-Entity-Ref-ID must be a UUID", id)
+		}
+		ids = append(ids, id)
+	}
+	if ids[0] == ids[1] {
+		t.Fatal("X-Entity-Ref-ID must be unique", ids)
+	}
+}
+
+func TestEntityRefIDFromPayloadIsKept(t *testing.T) {
+	e := &email{Headers: map[string]string{"x-entity-ref-id": "order-42"}}
+
+	e.addEntityRefID()
+
+	if len(e.Headers) != 1 || e.Headers["x-entity-ref-id"] != "order-42" {
+		t.Fatal("producer X-Entity-Ref-ID must be kept", e.Headers)
+	}
+}
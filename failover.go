@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// errNoBrokers is returned when none of the AMQP_URLS could be dialed.
+var errNoBrokers = errors.New("no broker could be dialed")
+
+// parseAMQPURLs splits the comma-separated broker URLs, the first one is the primary.
+func parseAMQPURLs(v string) []string {
+	var urls []string
+	for _, url := range strings.Split(v, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// dialFirst dials the brokers in order and returns the URL of the first one
+// the dial succeeded to, so the mailer falls back to the next broker of a
+// cluster while the primary one is down. The failures are logged redacted.
+func dialFirst(urls []string, dial func(url string) error) (string, error) {
+	for _, url := range urls {
+		err := dial(url)
+		if err == nil {
+			return url, nil
+		}
+		logDialError(url, err)
+	}
+	return "", errNoBrokers
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ses"
+)
+
+// feedbackForwardingSetter is the part of the SES client used to configure feedback forwarding.
+type feedbackForwardingSetter interface {
+	SetIdentityFeedbackForwardingEnabled(input *ses.SetIdentityFeedbackForwardingEnabledInput) (*ses.SetIdentityFeedbackForwardingEnabledOutput, error)
+}
+
+// setFeedbackForwarding turns the bounce and complaint notifications by email
+// on or off for the identity. SES allows to turn them off only when the
+// identity publishes bounces and complaints to SNS topics.
+func setFeedbackForwarding(setter feedbackForwardingSetter, identity string, enabled bool) error {
+	_, err := setter.SetIdentityFeedbackForwardingEnabled(&ses.SetIdentityFeedbackForwardingEnabledInput{
+		Identity:          aws.String(identity),
+		ForwardingEnabled: aws.Bool(enabled),
+	})
+	return err
+}
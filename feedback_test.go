@@ -0,0 +1,35 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"testing"
+)
+
+type fakeFeedbackForwardingSetter struct {
+	inputs []*ses.SetIdentityFeedbackForwardingEnabledInput
+}
+
+func (s *fakeFeedbackForwardingSetter) SetIdentityFeedbackForwardingEnabled(input *ses.SetIdentityFeedbackForwardingEnabledInput) (*ses.SetIdentityFeedbackForwardingEnabledOutput, error) {
+	s.inputs = append(s.inputs, input)
+	return &ses.SetIdentityFeedbackForwardingEnabledOutput{}, nil
+}
+
+func TestSetFeedbackForwarding(t *testing.T) {
+	setter := &fakeFeedbackForwardingSetter{}
+
+	err := setFeedbackForwarding(setter, "info@someone.com", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(setter.inputs) != 1 {
+		t.Fatal("calls", len(setter.inputs))
+	}
+	if aws.StringValue(setter.inputs[0].Identity) != "info@someone.com" {
+		t.Fatal("identity", aws.StringValue(setter.inputs[0].Identity))
+	}
+	if setter.inputs[0].ForwardingEnabled == nil || *setter.inputs[0].ForwardingEnabled {
+		t.Fatal("forwarding must be disabled", setter.inputs[0].ForwardingEnabled)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"log"
+	"strings"
+)
+
+// isIdentityRejection reports whether SES refused the From identity of the
+// email, e.g. the address is no longer verified.
+func isIdentityRejection(err error) bool {
+	var awsErr awserr.Error
+	if !errors.As(err, &awsErr) {
+		return false
+	}
+	switch awsErr.Code() {
+	case ses.ErrCodeMailFromDomainNotVerifiedException:
+		return true
+	case ses.ErrCodeMessageRejected:
+		return strings.Contains(strings.ToLower(awsErr.Message()), "not verified")
+	}
+	return false
+}
+
+// sendFromFallbacks resends the email from the fallback From addresses in order
+// while SES refuses the From identity, every fallback is tried once. It returns
+// the receipts of the fallback sends and the error of the last send.
+func (m *mailer) sendFromFallbacks(ctx context.Context, e *email, input *ses.SendRawEmailInput, err error, opts ...request.Option) ([]sendReceipt, error) {
+	var receipts []sendReceipt
+	for _, from := range m.cfg.fromFallbacks {
+		if !isIdentityRejection(err) {
+			return receipts, err
+		}
+		log.Println("From identity is refused, retrying from", from, err)
+		fallback := createEmail(m.emailOptionsFor(from), e)
+		fallback.ConfigurationSetName = input.ConfigurationSetName
+		var fallbackReceipts []sendReceipt
+		fallbackReceipts, err = sendEmailWithReceipts(ctx, m.ses, fallback, m.cfg.maxDestinationsPerCall(), opts...)
+		receipts = append(receipts, fallbackReceipts...)
+		if err == nil {
+			log.Println("email is sent from the fallback", from, e.Subject, e.To)
+		}
+	}
+	return receipts, err
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/streadway/amqp"
+	"strings"
+	"testing"
+)
+
+// unverifiedFromSES refuses the emails sent from the unverified address.
+type unverifiedFromSES struct {
+	fakeSES
+	unverified string
+}
+
+func (s *unverifiedFromSES) SendRawEmailWithContext(ctx aws.Context, input *ses.SendRawEmailInput, opts ...request.Option) (*ses.SendRawEmailOutput, error) {
+	if bytes.Contains(input.RawMessage.Data, []byte("From: "+s.unverified+"\r\n")) {
+		s.inputs = append(s.inputs, input)
+		return nil, awserr.New(ses.ErrCodeMessageRejected, "Email address is not verified. The following identities failed the check in region US-EAST-1: "+s.unverified, nil)
+	}
+	return s.fakeSES.SendRawEmailWithContext(ctx, input, opts...)
+}
+
+func TestRejectedFromFallsBack(t *testing.T) {
+	sender := &unverifiedFromSES{unverified: "primary@someone.com"}
+	m := newTestMailer(&config{
+		fromAddress:   "primary@someone.com",
+		fromFallbacks: []string{"secondary@someone.com"},
+	}, sender)
+	acknowledger := &fakeAcknowledger{}
+
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body"}`)})
+
+	if len(sender.inputs) != 2 {
+		t.Fatal("email must be resent once from the fallback", len(sender.inputs))
+	}
+	if !strings.Contains(string(sender.inputs[1].RawMessage.Data), "From: secondary@someone.com\r\n") {
+		t.Fatal("fallback From must be used", string(sender.inputs[1].RawMessage.Data))
+	}
+	if acknowledger.acked != 1 {
+		t.Fatal("email sent from the fallback must be acked")
+	}
+}
+
+func TestOtherErrorsDontFallBack(t *testing.T) {
+	sender := &fakeSES{err: awserr.New("Throttling", "slow down", nil)}
+	m := newTestMailer(&config{fromFallbacks: []string{"secondary@someone.com"}}, sender)
+
+	_, err := m.sendFromFallbacks(context.Background(), &email{To: "to@test.com"}, &ses.SendRawEmailInput{}, errAWSSendingEmail{err: sender.err})
+
+	if len(sender.inputs) != 0 || err == nil {
+		t.Fatal("email must not be resent after an error unrelated to the From identity", err)
+	}
+}
+
+func TestIsIdentityRejection(t *testing.T) {
+	if !isIdentityRejection(errAWSSendingEmail{err: awserr.New(ses.ErrCodeMailFromDomainNotVerifiedException, "not verified", nil)}) {
+		t.Fatal("MAIL FROM domain rejection")
+	}
+	if isIdentityRejection(awserr.New(ses.ErrCodeMessageRejected, "Email contains a virus", nil)) {
+		t.Fatal("content rejection is not about the From identity")
+	}
+}
@@ -4,6 +4,11 @@ go 1.13
 
 require (
 	github.com/aws/aws-sdk-go v1.25.21
+	github.com/russross/blackfriday/v2 v2.1.0
 	github.com/streadway/amqp v0.0.0-20190827072141-edfb9018d271
+	github.com/vanng822/go-premailer v1.20.2
+	github.com/xeipuuv/gojsonschema v1.2.0
+	golang.org/x/net v0.0.0-20200904194848-62affa334b73
+	golang.org/x/text v0.3.3
 	gopkg.in/gomail.v2 v2.0.0-20160411212932-81ebce5c23df
 )
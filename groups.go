@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const groupLookupTimeout = 10 * time.Second
+
+// errUnknownGroup is returned by the resolvers for the groups they don't define.
+var errUnknownGroup = errors.New("group is not defined")
+
+// groupResolver expands the named group into the addresses of its members.
+type groupResolver interface {
+	resolve(ctx context.Context, group string) ([]string, error)
+}
+
+// fileGroupResolver reads the groups from a JSON file mapping the group names
+// to the addresses, the file is read on every lookup so it may be changed at
+// any time.
+type fileGroupResolver struct {
+	path string
+}
+
+func (r fileGroupResolver) resolve(ctx context.Context, group string) ([]string, error) {
+	data, err := ioutil.ReadFile(r.path)
+	if err != nil {
+		return nil, err
+	}
+	var groups map[string][]string
+	if err := json.Unmarshal(data, &groups); err != nil {
+		return nil, fmt.Errorf("%s: %v", r.path, err)
+	}
+	addresses, ok := groups[group]
+	if !ok {
+		return nil, errUnknownGroup
+	}
+	return addresses, nil
+}
+
+// httpGroupResolver gets the JSON array of the addresses from the base URL
+// followed by the group name, 404 means the group is not defined.
+type httpGroupResolver struct {
+	baseURL string
+	client  *http.Client
+}
+
+func newHTTPGroupResolver(baseURL string) *httpGroupResolver {
+	return &httpGroupResolver{baseURL: strings.TrimSuffix(baseURL, "/"), client: &http.Client{Timeout: groupLookupTimeout}}
+}
+
+func (r *httpGroupResolver) resolve(ctx context.Context, group string) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.baseURL+"/"+url.PathEscape(group), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errUnknownGroup
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var addresses []string
+	if err := json.NewDecoder(resp.Body).Decode(&addresses); err != nil {
+		return nil, err
+	}
+	return addresses, nil
+}
+
+// isGroup reports whether the recipient names a group like @team-alerts.
+func isGroup(recipient string) bool {
+	return strings.HasPrefix(recipient, "@") && len(recipient) > 1 && !strings.Contains(recipient[1:], "@")
+}
+
+// expandGroups replaces the groups in To, Cc and Bcc with their members, the
+// members which are recipients already are left out.
+func (e *email) expandGroups(ctx context.Context, resolver groupResolver) error {
+	seen := map[string]bool{}
+	for _, recipient := range e.recipients() {
+		if !isGroup(recipient) {
+			seen[strings.ToLower(recipient)] = true
+		}
+	}
+	expand := func(field string) (string, error) {
+		if len(field) == 0 {
+			return field, nil
+		}
+		var recipients []string
+		for _, recipient := range strings.Split(field, ",") {
+			if !isGroup(recipient) {
+				recipients = append(recipients, recipient)
+				continue
+			}
+			members, err := resolver.resolve(ctx, recipient[1:])
+			if err != nil {
+				return "", fmt.Errorf(`group "%s" could not be resolved: %w`, recipient, err)
+			}
+			for _, member := range members {
+				member = strings.TrimSpace(member)
+				if !seen[strings.ToLower(member)] {
+					seen[strings.ToLower(member)] = true
+					recipients = append(recipients, member)
+				}
+			}
+		}
+		return strings.Join(recipients, ","), nil
+	}
+
+	var err error
+	if e.To, err = expand(e.To); err != nil {
+		return err
+	}
+	if e.Cc, err = expand(e.Cc); err != nil {
+		return err
+	}
+	e.Bcc, err = expand(e.Bcc)
+	return err
+}
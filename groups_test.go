@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+type fakeGroupResolver map[string][]string
+
+func (r fakeGroupResolver) resolve(ctx context.Context, group string) ([]string, error) {
+	addresses, ok := r[group]
+	if !ok {
+		return nil, errUnknownGroup
+	}
+	return addresses, nil
+}
+
+func TestExpandGroups(t *testing.T) {
+	resolver := fakeGroupResolver{"team-alerts": {"oncall@test.com", "lead@test.com", "Boss@test.com"}}
+	e := &email{To: "@team-alerts,boss@test.com", Subject: "Wow", TextBody: "text body"}
+
+	if err := e.expandGroups(context.Background(), resolver); err != nil {
+		t.Fatal(err)
+	}
+
+	if e.To != "oncall@test.com,lead@test.com,boss@test.com" {
+		t.Fatal("group must be expanded into its members", e.To)
+	}
+	if err := e.validate(); err != nil {
+		t.Fatal("resolved addresses must pass validation", err)
+	}
+}
+
+func TestExpandUnknownGroup(t *testing.T) {
+	e := &email{To: "@nobody"}
+
+	err := e.expandGroups(context.Background(), fakeGroupResolver{})
+
+	if err == nil || !strings.Contains(err.Error(), `group "@nobody" could not be resolved`) {
+		t.Fatal("unknown group must fail", err)
+	}
+}
+
+func TestFileGroupResolver(t *testing.T) {
+	file, err := ioutil.TempFile("", "groups*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(`{"team-alerts": ["oncall@test.com", "lead@test.com"]}`)
+	file.Close()
+	resolver := fileGroupResolver{path: file.Name()}
+
+	addresses, err := resolver.resolve(context.Background(), "team-alerts")
+	if err != nil || strings.Join(addresses, ",") != "oncall@test.com,lead@test.com" {
+		t.Fatal("group members", addresses, err)
+	}
+	if _, err := resolver.resolve(context.Background(), "nobody"); err != errUnknownGroup {
+		t.Fatal("unknown group", err)
+	}
+}
+
+func TestHTTPGroupResolver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/groups/team-alerts" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`["oncall@test.com","lead@test.com"]`))
+	}))
+	defer server.Close()
+	resolver := newHTTPGroupResolver(server.URL + "/groups/")
+
+	addresses, err := resolver.resolve(context.Background(), "team-alerts")
+	if err != nil || strings.Join(addresses, ",") != "oncall@test.com,lead@test.com" {
+		t.Fatal("group members", addresses, err)
+	}
+	if _, err := resolver.resolve(context.Background(), "nobody"); err != errUnknownGroup {
+		t.Fatal("unknown group", err)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// headerNameRegexp matches the printable ASCII header field names without colon, RFC 5322 2.2.
+var headerNameRegexp = regexp.MustCompile(`^[!-9;-~]+$`)
+
+// reservedHeaders are set by the mailer from the email fields and the config,
+// the custom headers must not override them.
+var reservedHeaders = map[string]bool{
+	"from":                      true,
+	"sender":                    true,
+	"to":                        true,
+	"cc":                        true,
+	"bcc":                       true,
+	"reply-to":                  true,
+	"subject":                   true,
+	"return-path":               true,
+	"mime-version":              true,
+	"content-type":              true,
+	"content-transfer-encoding": true,
+	"content-language":          true,
+	"x-content-digest":          true,
+	"x-dropped-attachments":     true,
+}
+
+// validateHeaders checks the custom headers can be written as they are and
+// don't override the headers of the mailer.
+func (e *email) validateHeaders() error {
+	for name, value := range e.Headers {
+		if !headerNameRegexp.MatchString(name) {
+			return errInvalidEmail{fmt.Errorf(`"%s" is not valid header name`, name)}
+		}
+		if isReservedHeader(name) {
+			return errInvalidEmail{fmt.Errorf(`"%s" header is set by the mailer`, name)}
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return errInvalidEmail{fmt.Errorf(`"%s" header must not contain line breaks`, name)}
+		}
+	}
+	return nil
+}
+
+func isReservedHeader(name string) bool {
+	name = strings.ToLower(name)
+	return reservedHeaders[name] || strings.HasPrefix(name, "x-mailer-")
+}
+
+// headerBlockSize returns the size of the header section of the raw message.
+func headerBlockSize(raw []byte) int {
+	if i := bytes.Index(raw, []byte("\r\n\r\n")); i >= 0 {
+		return i + len("\r\n")
+	}
+	return len(raw)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"github.com/streadway/amqp"
+	"strings"
+	"testing"
+)
+
+func TestCustomHeadersAreSet(t *testing.T) {
+	raw := string(createEmail(emailOptions{from: "from@someone.com"}, &email{
+		To:       "to@test.com",
+		Subject:  "Wow",
+		TextBody: "text body",
+		Headers:  map[string]string{"X-Campaign": "spring"},
+	}).RawMessage.Data)
+
+	if !strings.Contains(raw, "X-Campaign: spring\r\n") {
+		t.Fatal("custom header must be set", raw)
+	}
+}
+
+func TestValidateHeaders(t *testing.T) {
+	for _, headers := range []map[string]string{
+		{"X Campaign": "spring"},
+		{"X-Campaign:": "spring"},
+		{"X-Campaign": "spring\r\nBcc: hidden@test.com"},
+		{"From": "ceo@someone.com"},
+		{"reply-to": "attacker@test.com"},
+		{"Content-Type": "text/html"},
+		{"MIME-Version": "1.0"},
+		{"Return-Path": "bounces@test.com"},
+		{"X-Mailer-Tracking-Id": "forged"},
+	} {
+		e := &email{Headers: headers}
+		if err := e.validateHeaders(); err == nil {
+			t.Fatal("headers must be rejected", headers)
+		}
+	}
+}
+
+func TestReservedHeaderIsRejected(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{}, sender)
+	acknowledger := &fakeAcknowledger{}
+	body := []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body","headers":{"Subject":"Other"}}`)
+
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: body})
+
+	if acknowledger.nacked != 1 || acknowledger.requeued != 0 {
+		t.Fatal("email overriding a mailer header must be rejected without requeue", acknowledger)
+	}
+	if len(sender.inputs) != 0 {
+		t.Fatal("email must not be sent", len(sender.inputs))
+	}
+}
+
+func TestExcessiveHeadersAreRejected(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{maxHeaderSize: 4096}, sender)
+	acknowledger := &fakeAcknowledger{}
+	var headers []string
+	for i := 0; i < 200; i++ {
+		headers = append(headers, fmt.Sprintf(`"X-Custom-%d":"value of the custom header"`, i))
+	}
+	body := []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body","headers":{` + strings.Join(headers, ",") + `}}`)
+
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: body})
+
+	if acknowledger.nacked != 1 || acknowledger.requeued != 0 {
+		t.Fatal("email with excessive headers must be rejected without requeue", acknowledger)
+	}
+	if len(sender.inputs) != 0 {
+		t.Fatal("email must not be sent", len(sender.inputs))
+	}
+}
+
+func TestHeaderBlockSize(t *testing.T) {
+	if size := headerBlockSize([]byte("Subject: Wow\r\nTo: to@test.com\r\n\r\nbody")); size != 31 {
+		t.Fatal("header block size", size)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"io"
+	"strings"
+)
+
+const (
+	// htmlValidationWarn logs the emails whose html body is malformed.
+	htmlValidationWarn = "warn"
+	// htmlValidationReject rejects the emails whose html body is malformed.
+	htmlValidationReject = "reject"
+)
+
+// criticalTags are the elements whose end tag can't be left out, an unclosed
+// one swallows the rest of the body, e.g. into a link or a table.
+var criticalTags = map[atom.Atom]bool{
+	atom.A:      true,
+	atom.Center: true,
+	atom.Div:    true,
+	atom.Font:   true,
+	atom.Ol:     true,
+	atom.Style:  true,
+	atom.Table:  true,
+	atom.Title:  true,
+	atom.Ul:     true,
+}
+
+// checkHTML returns the critical elements of the html body which are not
+// closed or closed without being open.
+func checkHTML(body string) []string {
+	var problems []string
+	var open []atom.Atom
+	tokenizer := html.NewTokenizer(strings.NewReader(body))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			if err := tokenizer.Err(); err != io.EOF {
+				problems = append(problems, err.Error())
+			}
+			break
+		}
+		if tokenType != html.StartTagToken && tokenType != html.EndTagToken {
+			continue
+		}
+		name, _ := tokenizer.TagName()
+		tag := atom.Lookup(name)
+		if !criticalTags[tag] {
+			continue
+		}
+		if tokenType == html.StartTagToken {
+			open = append(open, tag)
+			continue
+		}
+		i := len(open) - 1
+		for i >= 0 && open[i] != tag {
+			i--
+		}
+		if i < 0 {
+			problems = append(problems, fmt.Sprintf("</%s> closes no open element", tag))
+			continue
+		}
+		for _, unclosed := range open[i+1:] {
+			problems = append(problems, fmt.Sprintf("<%s> is not closed", unclosed))
+		}
+		open = open[:i]
+	}
+	for _, unclosed := range open {
+		problems = append(problems, fmt.Sprintf("<%s> is not closed", unclosed))
+	}
+	return problems
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"github.com/streadway/amqp"
+	"strings"
+	"testing"
+)
+
+func TestCheckHTML(t *testing.T) {
+	testCases := []struct {
+		body     string
+		problems []string
+	}{
+		{`<div><p>Hi<br>there<table><tr><td>1<td>2</table></div>`, nil},
+		{`<div><a href="https://example.com">offer</div>`, []string{"<a> is not closed"}},
+		{`<table><tr><td>total</td></tr>`, []string{"<table> is not closed"}},
+		{`<p>Hi</p></div>`, []string{"</div> closes no open element"}},
+	}
+
+	for _, testCase := range testCases {
+		problems := checkHTML(testCase.body)
+		if strings.Join(problems, "|") != strings.Join(testCase.problems, "|") {
+			t.Fatalf("%s must be flagged with %v, got %v", testCase.body, testCase.problems, problems)
+		}
+	}
+}
+
+func TestHTMLValidationPolicies(t *testing.T) {
+	malformed := &email{To: "to@test.com", Subject: "Wow", HTMLBody: `<div><a href="https://example.com">offer</div>`}
+
+	warning := newTestMailer(&config{htmlValidation: htmlValidationWarn}, &fakeSES{})
+	if err := warning.validate(malformed); err != nil {
+		t.Fatal("malformed html must only be logged", err)
+	}
+
+	rejecting := newTestMailer(&config{htmlValidation: htmlValidationReject}, &fakeSES{})
+	if err := rejecting.validate(malformed); err == nil || err.Error() != "html body is malformed: <a> is not closed" {
+		t.Fatal("malformed html must be rejected", err)
+	}
+
+	wellFormed := &email{To: "to@test.com", Subject: "Wow", HTMLBody: `<div><a href="https://example.com">offer</a></div>`}
+	if err := rejecting.validate(wellFormed); err != nil {
+		t.Fatal("well-formed html must be accepted", err)
+	}
+}
+
+func TestMalformedHTMLIsRejected(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{htmlValidation: htmlValidationReject}, sender)
+	acknowledger := &fakeAcknowledger{}
+
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: []byte(`{"to":"to@test.com","subject":"Wow","html_body":"<div><a href=\"https://example.com\">offer</div>"}`)})
+
+	if len(sender.inputs) != 0 {
+		t.Fatal("malformed html must not be sent")
+	}
+	if acknowledger.nacked != 1 || acknowledger.requeued != 0 {
+		t.Fatal("email must be rejected without requeue", acknowledger)
+	}
+}
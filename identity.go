@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// defaultDevSESEndpoint is the LocalStack endpoint used under DEV_MODE.
+const defaultDevSESEndpoint = "http://localhost:4566"
+
+// identityVerifier is the part of the SES client checking the From identities.
+type identityVerifier interface {
+	GetIdentityVerificationAttributes(input *ses.GetIdentityVerificationAttributesInput) (*ses.GetIdentityVerificationAttributesOutput, error)
+}
+
+// verifyFromIdentities checks SES may send from every From address, either the
+// address or its domain must be verified. The check is opt-in, the identities
+// of a cross-account sending authorization aren't verified in the account. It
+// is skipped under DEV_MODE, the From addresses are only checked syntactically
+// when the config is loaded.
+// The check is left out with a warning when SES can't be asked, e.g. without
+// the ses:GetIdentityVerificationAttributes permission.
+func verifyFromIdentities(verifier identityVerifier, cfg *config) error {
+	if !cfg.verifyFromIdentities {
+		return nil
+	}
+	if cfg.devMode {
+		log.Println("DEV MODE: the From addresses are not checked to be verified in SES")
+		return nil
+	}
+
+	var identities []string
+	seen := map[string]bool{}
+	for _, address := range cfg.allFromAddresses() {
+		for _, identity := range []string{address, address[strings.LastIndex(address, "@")+1:]} {
+			if !seen[identity] {
+				seen[identity] = true
+				identities = append(identities, identity)
+			}
+		}
+	}
+	output, err := verifier.GetIdentityVerificationAttributes(&ses.GetIdentityVerificationAttributesInput{
+		Identities: aws.StringSlice(identities),
+	})
+	if err != nil {
+		log.Println("From identities could not be checked", err)
+		return nil
+	}
+
+	verified := func(identity string) bool {
+		attributes, ok := output.VerificationAttributes[identity]
+		return ok && aws.StringValue(attributes.VerificationStatus) == ses.VerificationStatusSuccess
+	}
+	for _, address := range cfg.allFromAddresses() {
+		if !verified(address) && !verified(address[strings.LastIndex(address, "@")+1:]) {
+			return fmt.Errorf(`"%s" is not verified in SES`, address)
+		}
+	}
+	return nil
+}
+
+// checkSESEndpoint makes sure the credentials and the emails never go to SES in
+// plaintext, e.g. through an http endpoint override left over from testing.
+func checkSESEndpoint(endpoint string, requireTLS bool) error {
+	if !requireTLS {
+		return nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf(`SES endpoint "%s" is not https`, endpoint)
+	}
+	return nil
+}
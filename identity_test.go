@@ -0,0 +1,71 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"testing"
+)
+
+type fakeIdentityVerifier struct {
+	verified []string
+	calls    int
+}
+
+func (v *fakeIdentityVerifier) GetIdentityVerificationAttributes(input *ses.GetIdentityVerificationAttributesInput) (*ses.GetIdentityVerificationAttributesOutput, error) {
+	v.calls++
+	output := &ses.GetIdentityVerificationAttributesOutput{VerificationAttributes: map[string]*ses.IdentityVerificationAttributes{}}
+	for _, identity := range v.verified {
+		output.VerificationAttributes[identity] = &ses.IdentityVerificationAttributes{VerificationStatus: aws.String(ses.VerificationStatusSuccess)}
+	}
+	return output, nil
+}
+
+func TestVerifyFromIdentities(t *testing.T) {
+	verifier := &fakeIdentityVerifier{verified: []string{"a@someone.com", "verified.com"}}
+
+	if err := verifyFromIdentities(verifier, &config{verifyFromIdentities: true, fromAddresses: []string{"a@someone.com", "info@verified.com"}}); err != nil {
+		t.Fatal("verified addresses and domains must be accepted", err)
+	}
+	err := verifyFromIdentities(verifier, &config{verifyFromIdentities: true, fromAddresses: []string{"b@someone.com"}})
+	if err == nil || err.Error() != `"b@someone.com" is not verified in SES` {
+		t.Fatal("unverified address must be rejected", err)
+	}
+}
+
+func TestVerifyFromIdentitiesIsSkippedInDevMode(t *testing.T) {
+	verifier := &fakeIdentityVerifier{}
+
+	err := verifyFromIdentities(verifier, &config{verifyFromIdentities: true, devMode: true, fromAddresses: []string{"dev@localhost.test"}})
+
+	if err != nil || verifier.calls != 0 {
+		t.Fatal("verification must be skipped under DEV_MODE", err, verifier.calls)
+	}
+}
+
+func TestVerifyFromIdentitiesIsOptIn(t *testing.T) {
+	verifier := &fakeIdentityVerifier{}
+
+	err := verifyFromIdentities(verifier, &config{fromAddresses: []string{"authorized@partner.com"}})
+
+	if err != nil || verifier.calls != 0 {
+		t.Fatal("verification must be skipped without VERIFY_FROM_IDENTITIES", err, verifier.calls)
+	}
+}
+
+func TestCheckSESEndpointRejectsHTTPOverride(t *testing.T) {
+	sess := session.Must(session.NewSession(aws.NewConfig().WithRegion("us-east-1")))
+
+	client := ses.New(sess, aws.NewConfig().WithEndpoint("http://ses.internal.test"))
+	if err := checkSESEndpoint(client.Endpoint, true); err == nil {
+		t.Fatal("http endpoint is accepted")
+	}
+	if err := checkSESEndpoint(client.Endpoint, false); err != nil {
+		t.Fatal("http endpoint is rejected without TLS enforcement", err)
+	}
+
+	client = ses.New(sess)
+	if err := checkSESEndpoint(client.Endpoint, true); err != nil {
+		t.Fatal("default endpoint is rejected", err)
+	}
+}
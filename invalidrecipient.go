@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"log"
+	"strings"
+	"unicode"
+)
+
+const (
+	errCodeInvalidParameterValue = "InvalidParameterValue"
+
+	// invalidRecipientFail fails the whole email when SES rejects one of its addresses.
+	invalidRecipientFail = "fail"
+	// invalidRecipientDrop resends the email to the rest of the recipients.
+	invalidRecipientDrop = "drop"
+)
+
+// droppedRecipients are the recipients of a chunk the dropper dropped with the
+// SES errors, they are reported failed instead of sent.
+type droppedRecipients map[string]string
+
+type droppedRecipientsKey struct{}
+
+// withDroppedRecipients returns the context the dropper records the dropped
+// recipients of the send in.
+func withDroppedRecipients(ctx context.Context) (context.Context, droppedRecipients) {
+	dropped := droppedRecipients{}
+	return context.WithValue(ctx, droppedRecipientsKey{}, dropped), dropped
+}
+
+// invalidRecipientDropper drops the recipient SES rejected with
+// InvalidParameterValue and resends the email to the rest of them. The rejected
+// address is found in the error message, the error is returned as it is when
+// the message doesn't name any of the recipients.
+type invalidRecipientDropper struct {
+	sender sesSender
+}
+
+func newInvalidRecipientDropper(sender sesSender) *invalidRecipientDropper {
+	return &invalidRecipientDropper{sender: sender}
+}
+
+func (d *invalidRecipientDropper) SendRawEmailWithContext(ctx aws.Context, input *ses.SendRawEmailInput, opts ...request.Option) (*ses.SendRawEmailOutput, error) {
+	resendInput := *input
+	for {
+		output, err := d.sender.SendRawEmailWithContext(ctx, &resendInput, opts...)
+		rejected, ok := rejectedRecipient(err, resendInput.Destinations)
+		if !ok || len(resendInput.Destinations) == 1 {
+			return output, err
+		}
+		log.Printf(`recipient "%s" is dropped, SES rejected it: %v`, aws.StringValue(resendInput.Destinations[rejected]), err)
+		if dropped, ok := ctx.Value(droppedRecipientsKey{}).(droppedRecipients); ok {
+			dropped[aws.StringValue(resendInput.Destinations[rejected])] = err.Error()
+		}
+		destinations := make([]*string, 0, len(resendInput.Destinations)-1)
+		destinations = append(destinations, resendInput.Destinations[:rejected]...)
+		resendInput.Destinations = append(destinations, resendInput.Destinations[rejected+1:]...)
+	}
+}
+
+// rejectedRecipient finds the destination named by the InvalidParameterValue
+// error. Whole addresses are compared, a@x.com must not match ba@x.com.
+func rejectedRecipient(err error, destinations []*string) (int, bool) {
+	var awsErr awserr.Error
+	if !errors.As(err, &awsErr) || awsErr.Code() != errCodeInvalidParameterValue {
+		return 0, false
+	}
+	tokens := addressTokens(awsErr.Message())
+	for i, destination := range destinations {
+		if tokens[strings.ToLower(aws.StringValue(destination))] {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// addressTokens returns the lowercased words of the error message which may be
+// addresses, e.g. bad@x.com of "Illegal address: <bad@x.com>." or "'bad@x.com'".
+func addressTokens(message string) map[string]bool {
+	tokens := map[string]bool{}
+	words := strings.FieldsFunc(strings.ToLower(message), func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(`"'<>()[],;:`, r)
+	})
+	for _, word := range words {
+		if strings.Contains(word, "@") {
+			tokens[word] = true
+			tokens[strings.TrimRight(word, ".")] = true
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"strings"
+	"testing"
+)
+
+// rejectingSES rejects the sends listing the invalid address like SES does.
+type rejectingSES struct {
+	fakeSES
+	invalid string
+}
+
+func (s *rejectingSES) SendRawEmailWithContext(ctx aws.Context, input *ses.SendRawEmailInput, opts ...request.Option) (*ses.SendRawEmailOutput, error) {
+	for _, destination := range input.Destinations {
+		if aws.StringValue(destination) == s.invalid {
+			return nil, awserr.New(errCodeInvalidParameterValue, "Illegal address: "+s.invalid, nil)
+		}
+	}
+	return s.fakeSES.SendRawEmailWithContext(ctx, input, opts...)
+}
+
+func TestInvalidRecipientIsDroppedAndEmailResent(t *testing.T) {
+	sender := &rejectingSES{invalid: "bad..address@test.com"}
+	input := &ses.SendRawEmailInput{
+		Destinations: aws.StringSlice([]string{"first@test.com", "bad..address@test.com", "second@test.com"}),
+		RawMessage:   &ses.RawMessage{Data: []byte("raw")},
+	}
+
+	err := sendEmail(context.Background(), newInvalidRecipientDropper(sender), input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sender.inputs) != 1 {
+		t.Fatal("email must be resent once", len(sender.inputs))
+	}
+	if destinations := strings.Join(aws.StringValueSlice(sender.inputs[0].Destinations), ","); destinations != "first@test.com,second@test.com" {
+		t.Fatal("email must be resent to the rest of the recipients", destinations)
+	}
+	if len(input.Destinations) != 3 {
+		t.Fatal("input must not be modified", aws.StringValueSlice(input.Destinations))
+	}
+}
+
+func TestInvalidRecipientOverlappingAnotherIsDropped(t *testing.T) {
+	sender := &rejectingSES{invalid: "ba@test.com"}
+	input := &ses.SendRawEmailInput{
+		Destinations: aws.StringSlice([]string{"a@test.com", "ba@test.com", "c@test.com"}),
+		RawMessage:   &ses.RawMessage{Data: []byte("raw")},
+	}
+
+	err := sendEmail(context.Background(), newInvalidRecipientDropper(sender), input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if destinations := strings.Join(aws.StringValueSlice(sender.inputs[0].Destinations), ","); destinations != "a@test.com,c@test.com" {
+		t.Fatal("only the rejected address must be dropped", destinations)
+	}
+}
+
+func TestRejectedRecipient(t *testing.T) {
+	destinations := aws.StringSlice([]string{"a@test.com", "ba@test.com"})
+	testCases := []struct {
+		message  string
+		rejected int
+		ok       bool
+	}{
+		{"Illegal address: <ba@test.com>.", 1, true},
+		{"Illegal address: 'BA@test.com'", 1, true},
+		{`Local address contains control or whitespace: "ba@test.com"`, 1, true},
+		{"Illegal address: a@test.com", 0, true},
+		{"Illegal address: cba@test.com", 0, false},
+	}
+
+	for _, testCase := range testCases {
+		rejected, ok := rejectedRecipient(awserr.New(errCodeInvalidParameterValue, testCase.message, nil), destinations)
+		if rejected != testCase.rejected || ok != testCase.ok {
+			t.Fatalf("%s must name recipient %d (%t), got %d (%t)", testCase.message, testCase.rejected, testCase.ok, rejected, ok)
+		}
+	}
+}
+
+func TestInvalidRecipientErrorWithoutAddressIsReturned(t *testing.T) {
+	sender := &fakeSES{err: awserr.New(errCodeInvalidParameterValue, "Missing final '@domain'", nil)}
+	input := &ses.SendRawEmailInput{Destinations: aws.StringSlice([]string{"first@test.com", "second@test.com"})}
+
+	err := sendEmail(context.Background(), newInvalidRecipientDropper(sender), input)
+
+	if err == nil || len(sender.inputs) != 1 {
+		t.Fatal("error not naming a recipient must fail the email", err, len(sender.inputs))
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// languageTagRegexp matches the well-formed BCP 47 language tags, RFC 5646 2.1:
+// language, script, region, variants, extensions and private use subtags.
+var languageTagRegexp = regexp.MustCompile(`(?i)^(` +
+	`[a-z]{2,3}(-[a-z]{3}){0,3}|[a-z]{4,8})` +
+	`(-[a-z]{4})?` +
+	`(-([a-z]{2}|[0-9]{3}))?` +
+	`(-([a-z0-9]{5,8}|[0-9][a-z0-9]{3}))*` +
+	`(-[0-9a-wy-z](-[a-z0-9]{2,8})+)*` +
+	`(-x(-[a-z0-9]{1,8})+)?$`)
+
+// validateLanguage checks the language is a well-formed BCP 47 tag.
+func (e *email) validateLanguage() error {
+	if len(e.Language) > 0 && !languageTagRegexp.MatchString(e.Language) {
+		return errInvalidEmail{fmt.Errorf(`"%s" is not valid BCP 47 language tag`, e.Language)}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/streadway/amqp"
+	"strings"
+	"testing"
+)
+
+func TestContentLanguageHeader(t *testing.T) {
+	e := &email{To: "to@test.com", Subject: "Wow", TextBody: "text body", Language: "pt-BR"}
+	if err := e.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	raw := string(createEmail(emailOptions{from: "from@someone.com"}, e).RawMessage.Data)
+
+	if !strings.Contains(raw, "Content-Language: pt-BR\r\n") {
+		t.Fatal("Content-Language header must be set", raw)
+	}
+}
+
+func TestValidateLanguage(t *testing.T) {
+	for _, tag := range []string{"en", "en-US", "zh-Hant-TW", "es-419", "de-CH-1996", "sr-Latn-RS", "en-x-private"} {
+		if err := (&email{Language: tag}).validateLanguage(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tag := range []string{"1en", "en_US", "e", "en-", "en-US-", "en\r\nBcc: x@test.com"} {
+		if err := (&email{Language: tag}).validateLanguage(); err == nil {
+			t.Fatalf("%q must be rejected", tag)
+		}
+	}
+}
+
+func TestEmailWithInvalidLanguageIsRejected(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{}, sender)
+	acknowledger := &fakeAcknowledger{}
+
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body","language":"en_US"}`)})
+
+	if acknowledger.nacked != 1 || acknowledger.requeued != 0 {
+		t.Fatal("email with invalid language must be rejected without requeue", acknowledger)
+	}
+	if len(sender.inputs) != 0 {
+		t.Fatal("email must not be sent", len(sender.inputs))
+	}
+}
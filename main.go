@@ -2,19 +2,27 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ses"
 	"github.com/streadway/amqp"
+	"github.com/xeipuuv/gojsonschema"
 	"gopkg.in/gomail.v2"
 	"io"
 	"log"
+	"net"
 	"os"
+	"os/signal"
 	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -35,22 +43,124 @@ func (e errAWSSendingEmail) Unwrap() error {
 	return e.err
 }
 
+// errInvalidEmail is a validation error which retrying doesn't fix, the email
+// is rejected and the consumer goes on with the next one.
+type errInvalidEmail struct {
+	err error
+}
+
+func (e errInvalidEmail) Error() string {
+	return e.err.Error()
+}
+
+func (e errInvalidEmail) Unwrap() error {
+	return e.err
+}
+
 type email struct {
+	Version  int           `json:"version"`
 	To       string        `json:"to"`
 	Cc       string        `json:"cc"`
+	Bcc      string        `json:"bcc"`
 	ReplyTo  string        `json:"reply_to"`
 	Subject  string        `json:"subject"`
 	HTMLBody string        `json:"html_body"`
 	TextBody string        `json:"text_body"`
 	Attaches []emailAttach `json:"attaches"`
+	// CompressAttachments gzips the text attachments, e.g. large CSV reports,
+	// and sends them as .gz files.
+	CompressAttachments bool `json:"compress_attachments"`
+
+	// FromName is the sender display name, e.g. "Support" in the language of the
+	// recipient, the address stays the verified From address.
+	FromName string `json:"from_name"`
+	// BodyContentType is the content type of HTMLBody, text/html by default.
+	// A text/markdown body is rendered to HTML.
+	BodyContentType string `json:"body_content_type"`
+	// BodyCharset is the charset of the base64 encoded bodies of a producer which
+	// can't send UTF-8, they are converted to UTF-8.
+	BodyCharset string `json:"body_charset"`
+
+	// MarkdownBody is rendered to HTMLBody, it is also sent as TextBody when
+	// MarkdownAsText is set.
+	MarkdownBody   string `json:"markdown_body"`
+	MarkdownAsText bool   `json:"markdown_as_text"`
+
+	// HTMLTemplate and TextTemplate name the templates of TEMPLATE_DIR the bodies
+	// are rendered from with TemplateData.
+	HTMLTemplate string                 `json:"html_template"`
+	TextTemplate string                 `json:"text_template"`
+	TemplateData map[string]interface{} `json:"template_data"`
+
+	// Language is the BCP 47 tag set as Content-Language.
+	Language string `json:"language"`
+
+	// Headers are added to the message as they are.
+	Headers map[string]string `json:"headers"`
+	// ARCHeaders are the pre-computed ARC sets of a forwarded email, they are
+	// written on top of the message in the given order.
+	ARCHeaders []arcHeader `json:"arc_headers"`
+
+	// Type is the category of the email, e.g. transactional or marketing, it picks
+	// the configuration set, From address and rate limit configured for the type.
+	Type string `json:"type"`
+	// Priority is "high", "normal" (default) or "low", urgent emails are retried
+	// sooner and more often.
+	Priority string `json:"priority"`
+	// Tenant labels the metrics and the logs of the email, e.g. the customer it is sent for.
+	Tenant string `json:"tenant"`
+	// ConfigurationSet overrides the global SES configuration set, it must be allowed by the config.
+	ConfigurationSet string `json:"configuration_set"`
+
+	// SkipValidation leaves out the address, subject and body checks, it is only
+	// honored when the producer is trusted.
+	SkipValidation bool `json:"skip_validation"`
+	// ForceRawSend asks for the SendRawEmail path. The mailer has no structured
+	// SendEmail path, every email is built as MIME and sent raw, so the flag is
+	// accepted for the producers which set it and changes nothing.
+	ForceRawSend bool `json:"force_raw_send"`
+
+	// RawMIME is a base64 encoded MIME message sent as it is instead of the fields above.
+	RawMIME string `json:"raw_mime"`
+
+	// SendTimeoutMs overrides MESSAGE_DEADLINE for the email, e.g. a longer one
+	// for large attachments, it is capped by MAX_SEND_TIMEOUT.
+	SendTimeoutMs int64 `json:"send_timeout_ms"`
+
+	DelayMs   int64     `json:"delay_ms"`
+	SendAfter time.Time `json:"send_after"`
+	// RetryUntil is the deadline of the retries, the email is dead-lettered
+	// instead of retried once it passed, e.g. a one-time code sent too late.
+	RetryUntil time.Time `json:"retry_until"`
+
+	droppedAttachments []string
+	rawMessage         []byte
+	// trackingID correlates the queue message with the SES message id in the logs.
+	trackingID string
 }
 
 type emailAttach struct {
 	FileName                 string `json:"file_name"`
 	FileContentBase64Encoded string `json:"file_content_base64_encoded"`
+	// URL is where the file content is downloaded from instead of being passed encoded.
+	URL string `json:"url"`
+	// Part is the index of the raw part holding the content in a multipart
+	// payload, 1 is the part after the JSON email.
+	Part int `json:"part"`
+	// Disposition is "attachment" (default) or "inline" to show the file, e.g.
+	// an image, in the message body.
+	Disposition string `json:"disposition"`
+	// Path is where the file is read from on the shared ATTACHMENT_VOLUME, its
+	// content must match the hex SHA256 checksum.
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+
+	content []byte
 }
 
-func (e *email) trimFields() {
+// trimFields trims the addresses, the subject and the attachment fields, the
+// bodies are trimmed unless keepBodies is set as their whitespace may matter.
+func (e *email) trimFields(keepBodies bool) {
 	tos := strings.Split(e.To, ",")
 	for i, to := range tos {
 		tos[i] = strings.TrimSpace(to)
@@ -65,13 +175,88 @@ func (e *email) trimFields() {
 		e.Cc = strings.Join(carbonCopies, ",")
 	}
 
+	if len(e.Bcc) > 0 {
+		blindCarbonCopies := strings.Split(e.Bcc, ",")
+		for i, bcc := range blindCarbonCopies {
+			blindCarbonCopies[i] = strings.TrimSpace(bcc)
+		}
+		e.Bcc = strings.Join(blindCarbonCopies, ",")
+	}
+
 	e.Subject = strings.TrimSpace(e.Subject)
-	e.HTMLBody = strings.TrimSpace(e.HTMLBody)
-	e.TextBody = strings.TrimSpace(e.TextBody)
+	e.FromName = strings.TrimSpace(e.FromName)
+	if !keepBodies {
+		e.HTMLBody = strings.TrimSpace(e.HTMLBody)
+		e.TextBody = strings.TrimSpace(e.TextBody)
+	}
 
 	for i, attach := range e.Attaches {
 		e.Attaches[i].FileName = strings.TrimSpace(attach.FileName)
 		e.Attaches[i].FileContentBase64Encoded = strings.TrimSpace(attach.FileContentBase64Encoded)
+		e.Attaches[i].URL = strings.TrimSpace(attach.URL)
+		e.Attaches[i].Disposition = strings.TrimSpace(attach.Disposition)
+		e.Attaches[i].Path = strings.TrimSpace(attach.Path)
+		e.Attaches[i].SHA256 = strings.TrimSpace(attach.SHA256)
+	}
+}
+
+// recipients returns all To, Cc and Bcc addresses.
+func (e *email) recipients() []string {
+	recipients := strings.Split(e.To, ",")
+	if len(e.Cc) > 0 {
+		recipients = append(recipients, strings.Split(e.Cc, ",")...)
+	}
+	if len(e.Bcc) > 0 {
+		recipients = append(recipients, strings.Split(e.Bcc, ",")...)
+	}
+	return recipients
+}
+
+// addDefaultCopies adds the default Cc and Bcc addresses which are not recipients yet.
+func (e *email) addDefaultCopies(defaultCc, defaultBcc []string) {
+	recipients := map[string]bool{}
+	for _, recipient := range e.recipients() {
+		recipients[strings.ToLower(recipient)] = true
+	}
+	add := func(field string, addresses []string) string {
+		for _, address := range addresses {
+			if recipients[strings.ToLower(address)] {
+				continue
+			}
+			recipients[strings.ToLower(address)] = true
+			if len(field) > 0 {
+				field += ","
+			}
+			field += address
+		}
+		return field
+	}
+	e.Cc = add(e.Cc, defaultCc)
+	e.Bcc = add(e.Bcc, defaultBcc)
+}
+
+// addDefaultReplyTo sets the default Reply-To when the email has none.
+func (e *email) addDefaultReplyTo(defaultReplyTo []string) {
+	if len(e.ReplyTo) == 0 {
+		e.ReplyTo = strings.Join(defaultReplyTo, ",")
+	}
+}
+
+// emptyBodyPlaceholder is the text body of the attachment-only emails.
+const emptyBodyPlaceholder = "Please see the attached files."
+
+// addPlaceholderBody sets the placeholder text body when the email has attachments
+// but no body, e.g. a fax-style PDF send.
+func (e *email) addPlaceholderBody() {
+	if len(e.HTMLBody) == 0 && len(e.TextBody) == 0 && len(e.Attaches) > 0 {
+		e.TextBody = emptyBodyPlaceholder
+	}
+}
+
+// warnManyRecipients logs a warning when the email has more recipients than the threshold.
+func (e *email) warnManyRecipients(threshold int) {
+	if count := len(e.recipients()); count > threshold {
+		log.Printf("email has %d recipients, more than %d: %s", count, threshold, e.Subject)
 	}
 }
 
@@ -104,63 +289,849 @@ func (e *email) validate() error {
 		}
 	}
 
+	if len(e.Bcc) > 0 {
+		bccopies := strings.Split(e.Bcc, ",")
+		for _, bcc := range bccopies {
+			if !emailRegexp.MatchString(bcc) {
+				return fmt.Errorf(`"%s" is not valid blind carbon copy email`, bcc)
+			}
+			if _, ok := specifiedDestEmails[bcc]; ok {
+				return fmt.Errorf(`"%s" is used twice`, bcc)
+			}
+			specifiedDestEmails[bcc] = true
+		}
+	}
+
 	if len(e.Subject) == 0 {
 		return errors.New("subject must not be empty")
 	}
 
+	if len(e.HTMLBody) == 0 && len(e.TextBody) == 0 && len(e.Attaches) == 0 {
+		return errEmptyEmail
+	}
 	if len(e.HTMLBody) == 0 && len(e.TextBody) == 0 {
 		return errors.New("at least text_body must be set")
 	}
 
-	return nil
+	if len(e.MarkdownBody) > 0 {
+		return errInvalidEmail{errors.New("markdown_body must not be combined with html_body")}
+	}
+
+	if len(e.BodyContentType) > 0 && !bodyContentTypes[e.BodyContentType] {
+		return errInvalidEmail{fmt.Errorf(`"%s" body content type is not supported`, e.BodyContentType)}
+	}
+
+	if err := e.validateCompressedNames(); err != nil {
+		return err
+	}
+	if err := e.validatePriority(); err != nil {
+		return err
+	}
+	for _, attach := range e.Attaches {
+		if len(attach.Disposition) > 0 && !attachmentDispositions[attach.Disposition] {
+			return errInvalidEmail{fmt.Errorf(`"%s" attachment disposition is not supported`, attach.Disposition)}
+		}
+	}
+
+	return e.validateSafety()
+}
+
+// validateSafety checks the fields written to the headers can't inject other
+// headers, it is run even for the trusted producers.
+func (e *email) validateSafety() error {
+	for _, field := range []struct{ name, value string }{
+		{"to", e.To},
+		{"cc", e.Cc},
+		{"bcc", e.Bcc},
+		{"reply_to", e.ReplyTo},
+		{"subject", e.Subject},
+		{"from_name", e.FromName},
+	} {
+		if strings.ContainsAny(field.value, "\r\n") {
+			return fmt.Errorf("%s must not contain line breaks", field.name)
+		}
+	}
+	if err := e.validateLanguage(); err != nil {
+		return err
+	}
+	if err := e.validateARCHeaders(); err != nil {
+		return err
+	}
+	return e.validateHeaders()
+}
+
+// errEmptyEmail tells the email without any content apart from the one which
+// has only attachments.
+var errEmptyEmail = errors.New("email is empty, it has no text_body, html_body or attachments")
+
+// sendingRetryDelay is how long the mailer waits after SES failed to send an email.
+const sendingRetryDelay = 5 * time.Minute
+
+type mailer struct {
+	cfg     *config
+	channel amqpChannel
+	ses     sesSender
+	fetcher attachmentFetcher
+	warmUp  *warmUpThrottle
+	quota   *quotaWatcher
+	// groups expands the @group recipients, they are not expanded when nil.
+	groups groupResolver
+	// webhook receives the send results, it is disabled when nil.
+	webhook *webhookNotifier
+	// sendRate paces the sends adaptively to the SES throttling, it is disabled when nil.
+	sendRate *adaptiveRate
+	// typeLimiters limit the send rate of the email types with a configured rate.
+	typeLimiters map[string]*rateLimiter
+	// domainLimiters limit the send rate to the recipient domains with a configured rate.
+	domainLimiters map[string]*rateLimiter
+	// accountPause holds the sending back while the SES account is paused, it is
+	// disabled when nil.
+	accountPause *accountPause
+	// recipientCap limits the distinct recipients per hour, it is disabled when nil.
+	recipientCap *recipientCap
+	mx           *mxValidator
+	stats        *stats
+	emf          *emfEmitter
+	// sendEvents writes a JSON event per send to stdout, it is disabled when nil.
+	sendEvents *sendEventEmitter
+	// attachments caches the decoded inline attachments shared by the emails.
+	attachments *attachmentCache
+	// payloadSchema validates the JSON payloads before they are decoded, they are
+	// not checked when it is nil.
+	payloadSchema *gojsonschema.Schema
+	// addressRewrites rewrite the recipient addresses before the emails are validated.
+	addressRewrites []addressRewrite
+	// retryDelay is how long the consumer is held back after a failed attempt.
+	retryDelay time.Duration
+	// stopping is closed once a stop signal arrives, the retry delay is not
+	// waited out then.
+	stopping chan struct{}
+	// fromIndex counts the picked From addresses for the round-robin.
+	fromIndex uint64
 }
 
 func main() {
-	getEnv("AMQP_URL")
-	getEnv("AMQP_QUEUE")
-	getEnv("AWS_VERIFIED_FROM_EMAIL_ADDRESS")
-	fromAddress := getEnv("AWS_VERIFIED_FROM_EMAIL_ADDRESS")
-
-	for message := range rabbitMQMessageChan() {
-		emailToSendMessage := &email{}
-		err := json.Unmarshal(message.Body, emailToSendMessage)
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		replayCommand(os.Args[2:])
+		return
+	}
+	cfg := loadConfig()
+	m := &mailer{
+		cfg:            cfg,
+		warmUp:         newWarmUpThrottle(cfg.warmUpSchedule, cfg.warmUpStart),
+		recipientCap:   newRecipientCap(cfg.maxRecipientsPerHour),
+		typeLimiters:   newTypeLimiters(cfg.typeRateLimits),
+		domainLimiters: newDomainLimiters(cfg.domainRateLimits),
+		sendRate:       newAdaptiveRate(cfg.adaptiveMaxRate, cfg.adaptiveMinRate),
+		webhook:        newWebhookNotifier(cfg.webhookURL, cfg.webhookSecret),
+		stats:          &stats{},
+		fetcher:        newHTTPAttachmentFetcher(cfg.maxEmailSize),
+		retryDelay:     sendingRetryDelay,
+	}
+	if cfg.payloadSchema != "" {
+		schema, err := loadPayloadSchema(cfg.payloadSchema)
 		if err != nil {
-			message.Nack(false, true)
-			log.Fatal("message could not be decoded", message.Body)
+			log.Fatal("payload schema err", err)
 		}
-		log.Println("new email message:", emailToSendMessage.Subject, emailToSendMessage.To)
-		emailToSendMessage.trimFields()
-		err = emailToSendMessage.validate()
+		m.payloadSchema = schema
+	}
+	if cfg.addressRewriteFile != "" {
+		rewrites, err := loadAddressRewrites(cfg.addressRewriteFile)
 		if err != nil {
-			message.Nack(false, true)
-			log.Fatal("validation error", err)
+			log.Fatal("address rewrite rules err", err)
+		}
+		m.addressRewrites = rewrites
+	}
+	sess, err := session.NewSession()
+	if err != nil {
+		log.Fatal(errAWSSessionCreation, err)
+	}
+	var sesConfigs []*aws.Config
+	if cfg.devMode {
+		log.Printf("DEV MODE: sending through %s without From verification, never enable it in production", cfg.devSESEndpoint)
+		sesConfigs = append(sesConfigs, aws.NewConfig().WithEndpoint(cfg.devSESEndpoint))
+	}
+	sesClient := ses.New(sess, sesConfigs...)
+	err = checkSESEndpoint(sesClient.Endpoint, cfg.sesRequireTLS)
+	if err != nil {
+		log.Fatal("SES endpoint err", err)
+	}
+	err = verifyFromIdentities(sesClient, cfg)
+	if err != nil {
+		log.Fatal("From verification err", err)
+	}
+	if cfg.selfTestTo != "" {
+		err = sendSelfTest(sesClient, cfg)
+		if err != nil {
+			log.Fatal("startup self-test err", err)
+		}
+	}
+	m.ses = sesClient
+	m.accountPause = newAccountPause(sesClient, cfg.accountPauseCheckInterval)
+	if cfg.quotaCheckInterval > 0 {
+		m.quota = newQuotaWatcher(sesClient, cfg.quotaCheckInterval, cfg.quotaReserve, cfg.quotaPause)
+	}
+	if cfg.invalidRecipientPolicy == invalidRecipientDrop {
+		m.ses = newInvalidRecipientDropper(m.ses)
+	}
+	if cfg.chaosFailureRate > 0 {
+		log.Printf("CHAOS MODE: %.1f%% of sends fail on purpose, never enable it in production", cfg.chaosFailureRate*100)
+		m.ses = newChaosSender(m.ses, cfg.chaosFailureRate)
+	}
+	if cfg.feedbackForwarding != nil {
+		for _, fromAddress := range cfg.allFromAddresses() {
+			err = setFeedbackForwarding(sesClient, fromAddress, *cfg.feedbackForwarding)
+			if err != nil {
+				log.Fatal("feedback forwarding setup err", err)
+			}
+		}
+	}
+	if cfg.validateMX {
+		m.mx = newMXValidator(net.DefaultResolver)
+		m.mx.allowSimulator = cfg.sesSimulator
+		m.mx.failClosed = cfg.lookupFailurePolicy == lookupFailClosed
+	}
+	if cfg.groupsFile != "" {
+		m.groups = fileGroupResolver{path: cfg.groupsFile}
+	} else if cfg.groupsURL != "" {
+		m.groups = newHTTPGroupResolver(cfg.groupsURL)
+	}
+	if cfg.maxAttachmentFetches > 0 {
+		m.fetcher = newLimitedFetcher(m.fetcher, cfg.maxAttachmentFetches)
+	}
+	if cfg.attachmentCacheSize > 0 {
+		m.attachments = newAttachmentCache(cfg.attachmentCacheSize)
+	}
+	if cfg.emfMetrics {
+		m.emf = newEMFEmitter(os.Stdout)
+		if cfg.emfMaxTenants > 0 {
+			m.emf.maxTenants = cfg.emfMaxTenants
 		}
+	}
+	if cfg.emitSendEvents {
+		m.sendEvents = newSendEventEmitter(os.Stdout)
+	}
+	if cfg.statsAddr != "" {
+		go serveStats(cfg.statsAddr, m.stats)
+	}
 
-		sesEmail := createEmail(fromAddress, emailToSendMessage)
-		err = sendEmail(sesEmail)
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	if m.run(rabbitMQConnection(cfg), stop) {
+		// the unacknowledged messages go back to the queue with the connection
+		m.stats.logSummary()
+		return
+	}
+
+	log.Fatal("amqp connection is closed")
+}
+
+// run handles the deliveries of the queue until the connection is closed or a
+// stop signal arrives, it reports whether it was stopped. The email being sent
+// is finished first. A channel closed on its own, e.g. by a channel exception
+// after a failed ack, is reopened on the same connection and the consumer is
+// set up again. INTER_MESSAGE_DELAY is waited out after every message, and the
+// retry delay after a failed one, unless a stop signal arrives meanwhile.
+func (m *mailer) run(conn amqpConnection, stop <-chan os.Signal) bool {
+	m.stopping = make(chan struct{})
+	go func() {
+		<-stop
+		close(m.stopping)
+	}()
+	for {
+		amqpChannel, err := conn.channel()
 		if err != nil {
-			if err == errAWSSessionCreation {
-				message.Nack(false, true)
-				log.Fatal("message could not be decoded", message.Body)
+			if conn.closed() {
+				return false
+			}
+			log.Fatal("channel init err", err)
+		}
+		m.channel = amqpChannel
+		messages, err := consume(amqpChannel, m.cfg)
+		if err != nil {
+			if conn.closed() {
+				return false
 			}
-			if _, ok := err.(*errAWSSendingEmail); ok {
-				log.Println(err)
+			log.Fatal("consumer setup err", err)
+		}
+	handling:
+		for {
+			select {
+			case <-m.stopping:
+				log.Println("stop signal received, shutting down")
+				return true
+			case message, ok := <-messages:
+				if !ok {
+					break handling
+				}
+				m.handle(message)
+				if m.cfg.interMessageDelay > 0 {
+					select {
+					case <-m.stopping:
+						log.Println("stop signal received, shutting down")
+						return true
+					case <-time.After(m.cfg.interMessageDelay):
+					}
+				}
+			}
+		}
+		if conn.closed() {
+			return false
+		}
+		log.Println("amqp channel is closed, reopening it in", m.cfg.amqpChannelRetryDelay)
+		time.Sleep(m.cfg.amqpChannelRetryDelay)
+	}
+}
+
+func (m *mailer) handle(message amqp.Delivery) {
+	multipartPayload := m.cfg.multipartPayloads && isMultipart(message.ContentType)
+	batch := !multipartPayload && isBatch(message.Body)
+	if !batch {
+		// the emails of a batch are counted one by one as they are handled
+		m.stats.begin()
+		defer m.stats.end()
+	}
+	start := time.Now()
+
+	if m.cfg.maxPayloadBytes > 0 && len(message.Body) > m.cfg.maxPayloadBytes {
+		// the body is never decoded, it could take too much memory
+		m.deadLetter(message, fmt.Errorf("payload of %d bytes exceeds the limit of %d bytes", len(message.Body), m.cfg.maxPayloadBytes))
+		m.stats.failed()
+		return
+	}
+	if age := messageAge(message, start); m.cfg.maxMessageAge > 0 && age > m.cfg.maxMessageAge {
+		m.dropStale(message, age)
+		return
+	}
+
+	if batch {
+		m.handleBatch(message)
+		return
+	}
+
+	if m.payloadSchema != nil && !multipartPayload {
+		if err := validatePayload(m.payloadSchema, message.Body); err != nil {
+			m.deadLetter(message, err)
+			m.stats.failed()
+			return
+		}
+	}
+
+	var emailToSendMessage *email
+	var err error
+	if multipartPayload {
+		emailToSendMessage, err = decodeMultipartEmail(message.ContentType, message.Body)
+	} else {
+		emailToSendMessage, err = decodeEmail(message.Body)
+	}
+	var invalidErr errInvalidEmail
+	if errors.As(err, &invalidErr) {
+		m.deadLetter(message, err)
+		m.stats.failed()
+		return
+	}
+	if err != nil {
+		message.Nack(false, true)
+		log.Fatal("message could not be decoded", err, message.Body)
+	}
+	emailToSendMessage.trackingID = newUUID()
+	if err := emailToSendMessage.checkRecipientLength(m.cfg.maxRecipientLength); err != nil {
+		m.reject(message, emailToSendMessage, err)
+		return
+	}
+	log.Printf("new email message: %s %s%s\n", emailToSendMessage.Subject, emailToSendMessage.To, emailToSendMessage.logLabels())
+	if err := emailToSendMessage.convertBodiesToUTF8(); err != nil {
+		m.reject(message, emailToSendMessage, err)
+		return
+	}
+	emailToSendMessage.trimFields(m.cfg.keepBodyWhitespace)
+	err = readVolumeAttachments(m.cfg.attachmentVolume, emailToSendMessage)
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		// the file may not be written or the volume not mounted yet
+		m.retryLater(message, emailToSendMessage, err)
+		return
+	}
+	if err != nil {
+		m.reject(message, emailToSendMessage, err)
+		return
+	}
+	if m.groups != nil && len(emailToSendMessage.RawMIME) == 0 {
+		err = emailToSendMessage.expandGroups(context.Background(), m.groups)
+		if errors.Is(err, errUnknownGroup) {
+			m.reject(message, emailToSendMessage, err)
+			return
+		}
+		if err != nil {
+			m.retryLater(message, emailToSendMessage, err)
+			return
+		}
+	}
+	if len(emailToSendMessage.RawMIME) == 0 {
+		emailToSendMessage.addDefaultCopies(m.cfg.defaultCc, m.cfg.defaultBcc)
+		if len(m.addressRewrites) > 0 {
+			emailToSendMessage.rewriteAddresses(m.addressRewrites)
+		}
+		if m.cfg.plusAddressing == plusAddressingStrip {
+			emailToSendMessage.dropSameMailboxes()
+		}
+		emailToSendMessage.addDefaultReplyTo(m.cfg.defaultReplyTo)
+		if m.cfg.entityRefID {
+			emailToSendMessage.addEntityRefID()
+		}
+		if m.cfg.precedence != "" {
+			emailToSendMessage.addPrecedence(m.cfg.precedence)
+		}
+		if err := emailToSendMessage.renderTemplates(m.cfg.templateDir); err != nil {
+			m.reject(message, emailToSendMessage, err)
+			return
+		}
+		emailToSendMessage.renderMarkdown()
+		if m.cfg.emptyAttachmentPolicy == emptyAttachmentSkip {
+			emailToSendMessage.skipEmptyAttachments()
+		}
+		if m.cfg.duplicateAttachmentPolicy == duplicateAttachmentRename {
+			emailToSendMessage.renameDuplicateAttachments()
+		}
+		if m.cfg.allowEmptyBody {
+			emailToSendMessage.addPlaceholderBody()
+		}
+		if m.cfg.stripTrackingPixels {
+			emailToSendMessage.stripTrackingPixels()
+		}
+		if m.cfg.inlineCSS {
+			if err := emailToSendMessage.inlineCSS(); err != nil {
+				log.Printf("css could not be inlined, html body is sent as it is: %v%s\n", err, emailToSendMessage.logLabels())
+			}
+		}
+	}
+	err = m.validate(emailToSendMessage)
+	if errors.Is(err, errLookupFailed) {
+		m.retryLater(message, emailToSendMessage, err)
+		return
+	}
+	if errors.As(err, &invalidErr) {
+		m.reject(message, emailToSendMessage, err)
+		return
+	}
+	if err != nil {
+		message.Nack(false, true)
+		log.Fatalf("validation error: %v%s\n", err, emailToSendMessage.logLabels())
+	}
+
+	if delay := emailToSendMessage.delay(time.Now()); delay > 0 && !isDelayed(message) {
+		if m.cfg.amqpDelayedExchange == "" {
+			log.Printf("delayed exchange is not configured, sending without delay %s %s%s\n", emailToSendMessage.Subject, emailToSendMessage.To, emailToSendMessage.logLabels())
+		} else {
+			err = publishDelayed(m.channel, m.cfg, message, delay)
+			if err != nil {
 				message.Nack(false, true)
-				time.Sleep(5 * time.Minute)
-				continue
+				log.Fatal("delayed publishing err", err)
+			}
+			message.Ack(false)
+			log.Printf("email message delayed %s %s %s%s\n", delay, emailToSendMessage.Subject, emailToSendMessage.To, emailToSendMessage.logLabels())
+			return
+		}
+	}
+
+	for wait := m.warmUp.reserve(); wait > 0; wait = m.warmUp.reserve() {
+		log.Println("warm-up daily cap is reached, waiting", wait)
+		if m.holdBack(message, emailToSendMessage, wait) {
+			return
+		}
+	}
+	for {
+		wait, err := m.recipientCap.reserve(emailToSendMessage.recipients())
+		if err != nil {
+			m.reject(message, emailToSendMessage, err)
+			return
+		}
+		if wait == 0 {
+			break
+		}
+		log.Println("distinct recipients per hour cap is reached, waiting", wait)
+		if m.holdBack(message, emailToSendMessage, wait) {
+			return
+		}
+	}
+	for wait := m.typeLimiters[emailToSendMessage.Type].reserve(); wait > 0; wait = m.typeLimiters[emailToSendMessage.Type].reserve() {
+		log.Println(emailToSendMessage.Type, "send rate limit is reached, waiting", wait)
+		if m.holdBack(message, emailToSendMessage, wait) {
+			return
+		}
+	}
+	if m.paceDomains(message, emailToSendMessage) {
+		return
+	}
+	for wait := m.sendRate.reserve(); wait > 0; wait = m.sendRate.reserve() {
+		if m.holdBack(message, emailToSendMessage, wait) {
+			return
+		}
+	}
+	for wait := m.accountPause.reserveSend(); wait > 0; wait = m.accountPause.reserveSend() {
+		if m.holdBack(message, emailToSendMessage, wait) {
+			return
+		}
+	}
+	m.stats.setAccountPaused(false)
+	destinations := len(emailToSendMessage.recipients())
+	for wait := m.quota.reserveSend(destinations); wait > 0; wait = m.quota.reserveSend(destinations) {
+		log.Println("SES sending quota is nearly exhausted, waiting", wait)
+		if m.holdBack(message, emailToSendMessage, wait) {
+			return
+		}
+	}
+	m.stats.setPaused(false)
+	if m.quota != nil {
+		if remaining, ok := m.quota.remainingQuota(); ok {
+			m.stats.setQuotaRemaining(remaining)
+		}
+	}
+
+	if m.cfg.sesSimulator {
+		logSimulatedOutcomes(emailToSendMessage)
+	}
+	if m.cfg.recipientWarningThreshold > 0 {
+		emailToSendMessage.warnManyRecipients(m.cfg.recipientWarningThreshold)
+	}
+
+	// the deadline bounds fetching, building and sending of the email together
+	ctx := context.Background()
+	if timeout := m.cfg.sendTimeoutFor(emailToSendMessage); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if m.cfg.maxEmailSize > 0 {
+		if size := estimateEmailSize(ctx, m.fetcher, emailToSendMessage); size > int64(m.cfg.maxEmailSize) {
+			m.reject(message, emailToSendMessage, fmt.Errorf("email of about %d bytes exceeds the limit of %d bytes", size, m.cfg.maxEmailSize))
+			return
+		}
+	}
+
+	err = fetchAttachments(ctx, m.fetcher, emailToSendMessage, m.cfg.attachmentFetchPolicy, m.cfg.attachmentFetchConcurrency)
+	if errors.As(err, &invalidErr) {
+		m.reject(message, emailToSendMessage, err)
+		return
+	}
+	if err != nil {
+		m.retryLater(message, emailToSendMessage, err)
+		return
+	}
+
+	err = decodeAttachments(m.attachments, emailToSendMessage)
+	if err != nil {
+		m.reject(message, emailToSendMessage, err)
+		return
+	}
+	if emailToSendMessage.CompressAttachments {
+		if err := emailToSendMessage.compressAttachments(); err != nil {
+			m.reject(message, emailToSendMessage, err)
+			return
+		}
+	}
+
+	var sesEmail *ses.SendRawEmailInput
+	if len(emailToSendMessage.rawMessage) > 0 {
+		sesEmail = createRawEmail(emailToSendMessage)
+	} else {
+		sesEmail = createEmail(m.emailOptionsFor(m.fromFor(emailToSendMessage)), emailToSendMessage)
+	}
+	if configurationSet := m.cfg.configurationSetFor(emailToSendMessage); len(configurationSet) > 0 {
+		sesEmail.ConfigurationSetName = aws.String(configurationSet)
+	}
+	if m.cfg.maxHeaderSize > 0 {
+		if size := headerBlockSize(sesEmail.RawMessage.Data); size > m.cfg.maxHeaderSize {
+			m.reject(message, emailToSendMessage, fmt.Errorf("email headers of %d bytes exceed the limit of %d bytes", size, m.cfg.maxHeaderSize))
+			return
+		}
+	}
+
+	var sendOptions []request.Option
+	if m.cfg.logSESRequestIDs {
+		sendOptions = append(sendOptions, logRequestID)
+	}
+	receipts, err := sendEmailWithReceipts(ctx, m.ses, sesEmail, m.cfg.maxDestinationsPerCall(), sendOptions...)
+	if err != nil && len(emailToSendMessage.rawMessage) == 0 {
+		var fallbackReceipts []sendReceipt
+		fallbackReceipts, err = m.sendFromFallbacks(ctx, emailToSendMessage, sesEmail, err, sendOptions...)
+		receipts = append(receipts, fallbackReceipts...)
+	}
+	if m.cfg.amqpStatusQueue != "" {
+		m.publishStatuses(message, sesEmail.Destinations, receipts, err)
+	}
+	m.webhook.notify(newSendResult(message, emailToSendMessage, sesEmail.Destinations, receipts, err))
+	m.emf.emit(emailToSendMessage.Type, emailToSendMessage.Tenant, err == nil, time.Since(start))
+	m.sendEvents.emit(emailToSendMessage, sesEmail, receipts, time.Since(start), err)
+	emailToSendMessage.logSESMessageIDs(receipts)
+	if err != nil && isAccountSendingPaused(err) && m.accountPause != nil {
+		// the email isn't at fault, it is requeued without counting a retry
+		// and the next one waits until the account sends again
+		m.accountPause.pause()
+		m.stats.setAccountPaused(true)
+		log.Printf("%v%s\n", err, emailToSendMessage.logLabels())
+		message.Nack(false, true)
+		m.stats.failed()
+		m.stats.retried()
+		return
+	}
+	if err != nil && isThrottling(err) && m.sendRate != nil {
+		// the lowered rate is the backoff, the email is retried right away
+		m.sendRate.throttled()
+		log.Printf("%v%s\n", err, emailToSendMessage.logLabels())
+		message.Nack(false, true)
+		m.stats.failed()
+		m.stats.retried()
+		return
+	}
+	if err == nil {
+		m.sendRate.succeeded()
+	}
+	if err != nil {
+		m.stats.failed()
+		if isPermanentRejection(err) {
+			m.rejectPermanently(message, err)
+			return
+		}
+		if _, ok := err.(errAWSSendingEmail); ok {
+			m.retryLater(message, emailToSendMessage, err)
+			return
+		}
+	}
+
+	message.Ack(false)
+	m.stats.sent()
+	log.Printf("email message successfully sent %s %s%s\n", emailToSendMessage.Subject, emailToSendMessage.To, emailToSendMessage.logLabels())
+}
+
+// validate checks the email payload and runs the configured extra checks.
+func (m *mailer) validate(e *email) error {
+	// the raw MIME message is parsed anyway to find the recipients
+	if e.SkipValidation && len(e.RawMIME) == 0 {
+		if m.cfg.trustProducer {
+			// the producer validated the email already, only the safety checks are
+			// left and their failures reject the email
+			if err := e.validateSafety(); err != nil {
+				return errInvalidEmail{err}
+			}
+			if err := e.validateType(); err != nil {
+				return errInvalidEmail{err}
+			}
+			if err := m.cfg.validateConfigurationSet(e); err != nil {
+				return errInvalidEmail{err}
+			}
+			return nil
+		}
+		log.Println("skip_validation is ignored, TRUST_PRODUCER is not set")
+	}
+
+	var err error
+	if len(e.RawMIME) > 0 {
+		if err = e.validateRawMIME(); err != nil {
+			err = errInvalidEmail{err}
+		}
+	} else {
+		err = e.validate()
+	}
+	if err == nil {
+		err = e.validateType()
+	}
+	if err == nil {
+		err = m.cfg.validateConfigurationSet(e)
+	}
+	if err == nil && m.cfg.duplicateAttachmentPolicy == duplicateAttachmentReject {
+		err = e.validateAttachmentNames()
+	}
+	if err == nil && m.cfg.emptyAttachmentPolicy == emptyAttachmentReject {
+		err = e.validateAttachmentContents()
+	}
+	if err == nil && m.cfg.subjectLengthPolicy != "" && len(e.rawMessage) == 0 {
+		err = e.checkSubjectLength(m.cfg.subjectLengthPolicy)
+	}
+	if err == nil && m.cfg.subjectRegexp != nil && !m.cfg.subjectRegexp.MatchString(e.Subject) {
+		err = errInvalidEmail{fmt.Errorf(`subject "%s" doesn't match %s`, e.Subject, m.cfg.subjectRegexp)}
+	}
+	if err == nil && m.cfg.sesSimulator {
+		err = e.validateSimulatorAddresses()
+	}
+	if err == nil && m.mx != nil {
+		err = m.mx.validate(e)
+	}
+	if err == nil && m.cfg.htmlValidation != "" && len(e.HTMLBody) > 0 {
+		if problems := checkHTML(e.HTMLBody); len(problems) > 0 {
+			if m.cfg.htmlValidation == htmlValidationReject {
+				err = errInvalidEmail{fmt.Errorf("html body is malformed: %s", strings.Join(problems, ", "))}
+			} else {
+				log.Printf("html body is malformed: %s %s %s%s\n", strings.Join(problems, ", "), e.Subject, e.To, e.logLabels())
+			}
+		}
+	}
+	if err == nil && m.cfg.contentGuard != "" && len(e.rawMessage) == 0 {
+		if problems := checkContent(e, m.cfg.contentGuardMinBody); len(problems) > 0 {
+			if m.cfg.contentGuard == contentGuardReject {
+				err = errInvalidEmail{fmt.Errorf("minimum content check failed: %s", strings.Join(problems, ", "))}
+			} else {
+				log.Printf("minimum content check failed: %s %s %s%s\n", strings.Join(problems, ", "), e.Subject, e.To, e.logLabels())
 			}
 		}
+	}
+	return err
+}
+
+// retryLater requeues the message and holds the consumer back for a while,
+// the priority of the email scales the delay and the retries of MAX_RETRIES.
+// The email is dead-lettered once its retry deadline passed.
+// With AMQP_RETRY_DELAY the message waits in the retry queue instead and the
+// consumer goes on with the next one.
+func (m *mailer) retryLater(message amqp.Delivery, e *email, err error) {
+	log.Printf("%v%s\n", err, e.logLabels())
+	if e.retryExpired(time.Now()) {
+		m.deadLetter(message, fmt.Errorf("retry deadline %s passed: %w", e.RetryUntil.Format(time.RFC3339), err))
+		return
+	}
+	if maxRetries := e.maxRetries(m.cfg.maxRetries); maxRetries > 0 && retryCount(message) >= maxRetries {
+		m.deadLetter(message, fmt.Errorf("%d retries are exhausted: %w", maxRetries, err))
+		return
+	}
+	if m.cfg.amqpRetryDelay > 0 && m.requeueDelayed(message, e) {
+		m.stats.retried()
+		return
+	}
+	message.Nack(false, true)
+	m.stats.retried()
+	m.backOff(e.retryDelay(m.retryDelay))
+}
+
+// backOff holds the consumer back for the delay unless a stop signal arrives.
+func (m *mailer) backOff(delay time.Duration) {
+	m.stats.setBackoff(delay)
+	select {
+	case <-m.stopping:
+	case <-time.After(delay):
+	}
+	m.stats.setBackoff(0)
+}
+
+// maxHeldWait is the longest wait of a send limit slept out while holding the
+// delivery, RabbitMQ closes the channel of a consumer holding a delivery past
+// its consumer_timeout and the email is delivered again.
+const maxHeldWait = time.Minute
+
+// holdBack waits for a send limit and reports whether the email was handed
+// back instead. A short wait is slept out unless a stop signal arrives, the
+// email is moved to the delayed exchange or the retry queue for a longer one.
+// Without them the email is requeued and the consumer waits without holding it.
+func (m *mailer) holdBack(message amqp.Delivery, e *email, wait time.Duration) bool {
+	m.stats.setPaused(true)
+	if wait <= maxHeldWait {
+		select {
+		case <-m.stopping:
+			message.Nack(false, true)
+			m.stats.setPaused(false)
+			return true
+		case <-time.After(wait):
+			return false
+		}
+	}
+	if m.deferDelivery(message, wait) {
+		log.Printf("email message deferred %s %s %s%s\n", wait, e.Subject, e.To, e.logLabels())
+		m.stats.setPaused(false)
+		return true
+	}
+	message.Nack(false, true)
+	select {
+	case <-m.stopping:
+	case <-time.After(wait):
+	}
+	m.stats.setPaused(false)
+	return true
+}
+
+// deferDelivery moves the message to the delayed exchange or to the retry
+// queue, whose TTL caps the wait, and reports false when neither is configured
+// or the message could not be moved.
+func (m *mailer) deferDelivery(message amqp.Delivery, wait time.Duration) bool {
+	var err error
+	switch {
+	case m.cfg.amqpDelayedExchange != "":
+		err = publishDelayed(m.channel, m.cfg, message, wait)
+	case m.cfg.amqpRetryDelay > 0:
+		err = publishDeferred(m.channel, m.cfg, message, wait)
+	default:
+		return false
+	}
+	if err != nil {
+		log.Println("deferred publishing err", err)
+		return false
+	}
+	message.Ack(false)
+	return true
+}
+
+// reject drops the email which can never be sent instead of retrying it.
+func (m *mailer) reject(message amqp.Delivery, e *email, err error) {
+	log.Printf("%v, the email is rejected: %s %s%s\n", err, e.Subject, e.To, e.logLabels())
+	message.Nack(false, false)
+	m.stats.failed()
+	m.stats.dropped()
+}
+
+// nextFrom picks the From addresses in turn.
+func (m *mailer) nextFrom() string {
+	if len(m.cfg.fromAddresses) == 0 {
+		return m.cfg.fromAddress
+	}
+	i := atomic.AddUint64(&m.fromIndex, 1) - 1
+	return m.cfg.fromAddresses[i%uint64(len(m.cfg.fromAddresses))]
+}
 
-		message.Ack(false)
-		log.Println("email message successfully sent", emailToSendMessage.Subject, emailToSendMessage.To)
+// fromFor picks the From address configured for the type of the email or the next one in turn.
+func (m *mailer) fromFor(e *email) string {
+	if from, ok := m.cfg.typeFromAddresses[e.Type]; ok {
+		return from
 	}
+	return m.nextFrom()
+}
 
-	log.Fatal("must not be finished")
+// emailOptions are the mailer settings applied to the message built from an email.
+type emailOptions struct {
+	from       string
+	returnPath string
+	// signingSecret adds the X-Mailer-Id and X-Mailer-Signature headers when set.
+	signingSecret []byte
+	// mimeTypes maps the lowercase attachment extensions to their media types.
+	mimeTypes map[string]string
+	// digestAlgorithm adds the X-Content-Digest header when set.
+	digestAlgorithm string
+}
+
+// emailOptionsFor returns the settings of the email sent from the address.
+func (m *mailer) emailOptionsFor(from string) emailOptions {
+	return emailOptions{
+		from:            from,
+		returnPath:      m.cfg.returnPathFor(from),
+		signingSecret:   m.cfg.signingSecret,
+		mimeTypes:       m.cfg.attachmentMIMETypes,
+		digestAlgorithm: m.cfg.contentDigest,
+	}
 }
 
-func createEmail(fromAddress string, emailToSendMessage *email) *ses.SendRawEmailInput {
+// rawEmailBuffers holds the buffers the messages are written to, the written
+// bytes are copied out before a buffer is put back, so the buffers never leak
+// into the SES input.
+var rawEmailBuffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+func createEmail(opts emailOptions, emailToSendMessage *email) *ses.SendRawEmailInput {
 	email := gomail.NewMessage()
-	email.SetHeader("From", fromAddress)
+	if len(emailToSendMessage.FromName) > 0 {
+		email.SetAddressHeader("From", opts.from, emailToSendMessage.FromName)
+	} else {
+		email.SetHeader("From", opts.from)
+	}
+	if len(opts.returnPath) > 0 {
+		email.SetHeader("Return-Path", opts.returnPath)
+	}
 	email.SetHeader("To", strings.Split(emailToSendMessage.To, ",")...)
 	if len(emailToSendMessage.Cc) > 0 {
 		cc := strings.Split(emailToSendMessage.Cc, ",")
@@ -171,15 +1142,45 @@ func createEmail(fromAddress string, emailToSendMessage *email) *ses.SendRawEmai
 		email.SetHeader("Reply-To", replyTo...)
 	}
 	email.SetHeader("Subject", emailToSendMessage.Subject)
-	if len(emailToSendMessage.HTMLBody) > 0 {
-		email.SetBody("text/html", emailToSendMessage.HTMLBody)
+	if len(emailToSendMessage.Language) > 0 {
+		email.SetHeader("Content-Language", emailToSendMessage.Language)
 	}
+	// the preferred alternative goes last
 	if len(emailToSendMessage.TextBody) > 0 {
-		email.SetBody("text/plain", emailToSendMessage.TextBody)
+		email.AddAlternative("text/plain", emailToSendMessage.TextBody)
+	}
+	if len(emailToSendMessage.HTMLBody) > 0 {
+		email.AddAlternative(emailToSendMessage.htmlBodyContentType(), emailToSendMessage.HTMLBody)
+	}
+	for name, value := range emailToSendMessage.Headers {
+		email.SetHeader(name, value)
+	}
+	if len(emailToSendMessage.trackingID) > 0 {
+		email.SetHeader(trackingIDHeader, emailToSendMessage.trackingID)
+	}
+	if len(opts.digestAlgorithm) > 0 {
+		email.SetHeader(contentDigestHeader, emailToSendMessage.contentDigest(opts.digestAlgorithm))
+	}
+	if len(opts.signingSecret) > 0 {
+		id := newMailerID()
+		email.SetHeader(mailerIDHeader, id)
+		email.SetHeader(mailerSignatureHeader, mailerSignature(opts.signingSecret, id, emailToSendMessage.signedRecipients()))
+	}
+	if len(emailToSendMessage.droppedAttachments) > 0 {
+		email.SetHeader("X-Dropped-Attachments", strings.Join(emailToSendMessage.droppedAttachments, ", "))
 	}
 	for _, attach := range emailToSendMessage.Attaches {
+		headers := gomail.SetHeader(attachmentHeaders(attach.FileName, attach.Disposition, opts.mimeTypes))
+		if attach.content != nil {
+			content := attach.content
+			email.Attach(attach.FileName, headers, gomail.SetCopyFunc(func(w io.Writer) error {
+				_, err := w.Write(content)
+				return err
+			}))
+			continue
+		}
 		base64EncodedContent := attach.FileContentBase64Encoded
-		email.Attach(attach.FileName, gomail.SetCopyFunc(func(w io.Writer) error {
+		email.Attach(attach.FileName, headers, gomail.SetCopyFunc(func(w io.Writer) error {
 			fileContentDecoded, err := base64.StdEncoding.DecodeString(base64EncodedContent)
 			if err != nil {
 				return err
@@ -189,59 +1190,228 @@ func createEmail(fromAddress string, emailToSendMessage *email) *ses.SendRawEmai
 		}))
 	}
 
-	var emailRaw bytes.Buffer
-	email.WriteTo(&emailRaw)
+	emailRaw := rawEmailBuffers.Get().(*bytes.Buffer)
+	emailRaw.Reset()
+	email.WriteTo(emailRaw)
+	data := make([]byte, emailRaw.Len())
+	copy(data, emailRaw.Bytes())
+	rawEmailBuffers.Put(emailRaw)
+	data = prependARCHeaders(data, emailToSendMessage.ARCHeaders)
 	input := &ses.SendRawEmailInput{
-		RawMessage: &ses.RawMessage{Data: emailRaw.Bytes()},
+		// gomail doesn't write the Bcc header, so all recipients are listed explicitly
+		Destinations: aws.StringSlice(emailToSendMessage.recipients()),
+		RawMessage:   &ses.RawMessage{Data: data},
 	}
 
 	return input
 }
 
-func sendEmail(input *ses.SendRawEmailInput) error {
-	sess, err := session.NewSession()
-	if err != nil {
-		return errAWSSessionCreation
+// sesMaxDestinations is the maximum number of recipients SES accepts in a single call.
+const sesMaxDestinations = 50
+
+// sesSender is the part of the SES client used to send emails.
+type sesSender interface {
+	SendRawEmailWithContext(ctx aws.Context, input *ses.SendRawEmailInput, opts ...request.Option) (*ses.SendRawEmailOutput, error)
+}
+
+// sendEmail sends the same raw message with a separate SES call per chunk of
+// destinations, so it can have more recipients than a single call accepts.
+func sendEmail(ctx context.Context, sender sesSender, input *ses.SendRawEmailInput, opts ...request.Option) error {
+	_, err := sendEmailWithReceipts(ctx, sender, input, sesMaxDestinations, opts...)
+	return err
+}
+
+// sendReceipt is the SES message id of the send to a chunk of the destinations.
+type sendReceipt struct {
+	destinations []*string
+	messageID    string
+	// dropped are the destinations of the chunk SES rejected with their errors,
+	// the chunk was resent without them.
+	dropped droppedRecipients
+}
+
+// sendEmailWithReceipts sends the email like sendEmail in chunks of at most
+// maxDestinations and also returns the receipts of the chunks sent before an
+// error.
+func sendEmailWithReceipts(ctx context.Context, sender sesSender, input *ses.SendRawEmailInput, maxDestinations int, opts ...request.Option) ([]sendReceipt, error) {
+	var receipts []sendReceipt
+	for _, destinations := range chunkDestinations(input.Destinations, maxDestinations) {
+		chunkInput := *input
+		chunkInput.Destinations = destinations
+		chunkCtx, dropped := withDroppedRecipients(ctx)
+		output, err := sender.SendRawEmailWithContext(chunkCtx, &chunkInput, opts...)
+		if err != nil {
+			return receipts, errAWSSendingEmail{err: err}
+		}
+		receipt := sendReceipt{destinations: destinations, messageID: aws.StringValue(output.MessageId)}
+		if len(dropped) > 0 {
+			receipt.destinations = nil
+			for _, destination := range destinations {
+				if _, ok := dropped[aws.StringValue(destination)]; !ok {
+					receipt.destinations = append(receipt.destinations, destination)
+				}
+			}
+			receipt.dropped = dropped
+		}
+		receipts = append(receipts, receipt)
 	}
-	svc := ses.New(sess)
-	_, err = svc.SendRawEmail(input)
+	return receipts, nil
+}
 
-	if err != nil {
-		return errAWSSendingEmail{err: err}
+// logRequestID logs the SES request id of the send, AWS support asks for it.
+func logRequestID(r *request.Request) {
+	r.Handlers.Complete.PushBack(func(r *request.Request) {
+		if r.Error != nil {
+			log.Println("ses request id", r.RequestID, "failed:", r.Error)
+			return
+		}
+		log.Println("ses request id", r.RequestID)
+	})
+}
+
+func chunkDestinations(destinations []*string, size int) [][]*string {
+	if len(destinations) == 0 {
+		return [][]*string{destinations}
 	}
-	return nil
+	var chunks [][]*string
+	for len(destinations) > size {
+		chunks = append(chunks, destinations[:size])
+		destinations = destinations[size:]
+	}
+	return append(chunks, destinations)
 }
 
-func rabbitMQMessageChan() <-chan amqp.Delivery {
-	amqpUrl := getEnv("AMQP_URL")
-	amqpQueueName := getEnv("AMQP_QUEUE")
-	var amqpConn *amqp.Connection
+// defaultAMQPHeartbeat is the heartbeat interval used by amqp.Dial.
+const defaultAMQPHeartbeat = 10 * time.Second
+
+// defaultAMQPChannelRetryDelay is the pause before a closed channel is reopened.
+const defaultAMQPChannelRetryDelay = time.Second
+
+func rabbitMQConnection(cfg *config) amqpConnection {
 	for {
-		conn, err := amqp.Dial(amqpUrl)
+		var conn *amqp.Connection
+		_, err := dialFirst(cfg.amqpURLs, func(url string) (err error) {
+			conn, err = amqp.DialConfig(url, amqpDialConfig(cfg))
+			return err
+		})
 		if err != nil {
-			log.Println("dial err", err)
 			time.Sleep(1 * time.Second)
 			continue
 		}
-		amqpConn = conn
-		break
+		c := &rabbitMQConn{Connection: conn}
+		// the connection is known to be closed once its close notification arrives
+		closeNotifications := conn.NotifyClose(make(chan *amqp.Error, 1))
+		go func() {
+			<-closeNotifications
+			atomic.StoreInt32(&c.isClosed, 1)
+		}()
+		return c
+	}
+}
+
+// amqpConnection is the part of *amqp.Connection used by the mailer.
+type amqpConnection interface {
+	channel() (amqpChannel, error)
+	closed() bool
+}
+
+type rabbitMQConn struct {
+	*amqp.Connection
+	isClosed int32
+}
+
+func (c *rabbitMQConn) channel() (amqpChannel, error) {
+	return c.Channel()
+}
+
+func (c *rabbitMQConn) closed() bool {
+	return atomic.LoadInt32(&c.isClosed) == 1
+}
+
+// amqpDialConfig mirrors the defaults of amqp.Dial with the configured heartbeat.
+func amqpDialConfig(cfg *config) amqp.Config {
+	heartbeat := defaultAMQPHeartbeat
+	if cfg.amqpHeartbeat > 0 {
+		heartbeat = cfg.amqpHeartbeat
 	}
-	amqpChannel, err := amqpConn.Channel()
+	return amqp.Config{
+		Heartbeat: heartbeat,
+		Locale:    "en_US",
+	}
+}
+
+// amqpChannel is the part of *amqp.Channel used by the mailer.
+type amqpChannel interface {
+	Qos(prefetchCount, prefetchSize int, global bool) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueDeclarePassive(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+// declareQueue declares the durable queue, only its existence is checked when
+// the queues are managed elsewhere.
+func declareQueue(amqpChannel amqpChannel, cfg *config, name string) error {
+	var err error
+	if cfg.amqpPassiveDeclare {
+		_, err = amqpChannel.QueueDeclarePassive(name, true, false, false, false, nil)
+	} else {
+		_, err = amqpChannel.QueueDeclare(name, true, false, false, false, nil)
+	}
+	return err
+}
+
+// consume sets the channel up and starts consuming the queue. Any failed step
+// fails the setup, e.g. without the prefetch limit of Qos the broker would push
+// the whole queue to the mailer.
+func consume(amqpChannel amqpChannel, cfg *config) (<-chan amqp.Delivery, error) {
+	err := amqpChannel.Qos(1, cfg.amqpPrefetchSize, false)
 	if err != nil {
-		log.Fatal("channel init err", err)
+		return nil, fmt.Errorf("qos err: %w", err)
+	}
+	var amqpQueue amqp.Queue
+	if cfg.amqpPassiveDeclare {
+		// the queue is managed elsewhere, only check it exists
+		amqpQueue, err = amqpChannel.QueueDeclarePassive(cfg.amqpQueue, true, false, false, false, nil)
+	} else {
+		amqpQueue, err = amqpChannel.QueueDeclare(cfg.amqpQueue, true, false, false, false, nil)
 	}
-	amqpChannel.Qos(1, 0, false)
-	amqpQueue, err := amqpChannel.QueueDeclare(amqpQueueName, true, false, false, false, nil)
 	if err != nil {
-		log.Fatal("queue declaration err", err)
+		return nil, fmt.Errorf("queue declaration err: %w", err)
+	}
+	if cfg.amqpDelayedExchange != "" {
+		err = declareDelayedExchange(amqpChannel, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("delayed exchange declaration err: %w", err)
+		}
+	}
+	if cfg.amqpDLQ != "" {
+		err = declareDeadLetterQueue(amqpChannel, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("dead letter queue declaration err: %w", err)
+		}
+	}
+	if cfg.amqpRetryDelay > 0 {
+		err = declareRetryQueue(amqpChannel, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("retry queue declaration err: %w", err)
+		}
+	}
+	if cfg.amqpStatusQueue != "" {
+		err = declareQueue(amqpChannel, cfg, cfg.amqpStatusQueue)
+		if err != nil {
+			return nil, fmt.Errorf("status queue declaration err: %w", err)
+		}
 	}
 
 	messageChannel, err := amqpChannel.Consume(amqpQueue.Name, "", false, false, false, false, nil)
 	if err != nil {
-		log.Fatal("message consumption err", err)
+		return nil, fmt.Errorf("message consumption err: %w", err)
 	}
 
-	return messageChannel
+	return messageChannel, nil
 }
 
 func getEnv(k string) (v string) {
@@ -1,26 +1,45 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/streadway/amqp"
+	"io/ioutil"
+	"log"
+	"net/mail"
+	"os"
+	"regexp"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestTrimAllFields(t *testing.T) {
 	email := email{
 		To:       " email1@test.com, email2@test.com ,  email3@test.com",
 		Cc:       " email4@test.com, email5@test.com ,  email6@test.com",
+		Bcc:      " email7@test.com , email8@test.com",
 		Subject:  "      test       subject ",
 		HTMLBody: "  html body ",
 		TextBody: "  text body ",
 		Attaches: []emailAttach{
 			{
-				" file_name.pdf ",
-				" file_content ",
+				FileName:                 " file_name.pdf ",
+				FileContentBase64Encoded: " file_content ",
+				URL:                      " https://files.test.com/file_name.pdf ",
 			},
 		},
 	}
 
-	email.trimFields()
+	email.trimFields(false)
 
 	if email.To != "email1@test.com,email2@test.com,email3@test.com" {
 		t.Fatal("To trim", email.To)
@@ -28,6 +47,9 @@ func TestTrimAllFields(t *testing.T) {
 	if email.Cc != "email4@test.com,email5@test.com,email6@test.com" {
 		t.Fatal("Cc trim", email.Cc)
 	}
+	if email.Bcc != "email7@test.com,email8@test.com" {
+		t.Fatal("Bcc trim", email.Bcc)
+	}
 	if email.Subject != "test       subject" {
 		t.Fatal("Subject trim", email.Subject)
 	}
@@ -43,11 +65,40 @@ func TestTrimAllFields(t *testing.T) {
 	if email.Attaches[0].FileContentBase64Encoded != "file_content" {
 		t.Fatal("FileContentBase64Encoded trim", email.Attaches[0].FileContentBase64Encoded)
 	}
+	if email.Attaches[0].URL != "https://files.test.com/file_name.pdf" {
+		t.Fatal("URL trim", email.Attaches[0].URL)
+	}
+}
+
+func TestTrimFieldsKeepsBodies(t *testing.T) {
+	email := email{
+		To:       " to@test.com ",
+		Subject:  " Wow ",
+		HTMLBody: "<pre>\n    code\n</pre>\n",
+		TextBody: "    ASCII\n   art  \n",
+	}
+
+	email.trimFields(true)
+
+	if email.To != "to@test.com" || email.Subject != "Wow" {
+		t.Fatal("addresses and subject must be trimmed", email.To, email.Subject)
+	}
+	if email.HTMLBody != "<pre>\n    code\n</pre>\n" || email.TextBody != "    ASCII\n   art  \n" {
+		t.Fatalf("bodies must be kept as they are, got %q and %q", email.HTMLBody, email.TextBody)
+	}
 }
 
 func TestTrimEmptyEmailDoesntEmitFatals(t *testing.T) {
 	email := email{}
-	email.trimFields()
+	email.trimFields(false)
+}
+
+func TestValidateEmptyEmail(t *testing.T) {
+	e := &email{To: " to@test.com ", Subject: " Wow ", HTMLBody: " \n ", TextBody: "\t"}
+	e.trimFields(false)
+	if err := e.validate(); err != errEmptyEmail {
+		t.Fatal("fully empty email must get its own error", err)
+	}
 }
 
 func TestValidate(t *testing.T) {
@@ -79,6 +130,11 @@ func TestValidate(t *testing.T) {
 		{
 			email{To: "valid@email.com", Subject: "Wow"},
 			false,
+			`email is empty, it has no text_body, html_body or attachments`,
+		},
+		{
+			email{To: "valid@email.com", Subject: "Wow", Attaches: []emailAttach{{FileName: "report.pdf", FileContentBase64Encoded: "dGVzdCBpcyBvawo="}}},
+			false,
 			`at least text_body must be set`,
 		},
 		{
@@ -121,6 +177,21 @@ func TestValidate(t *testing.T) {
 			false,
 			`"valid@email.com" is used twice`,
 		},
+		{
+			email{To: "valid@email.com", Bcc: "invalid", Subject: "Wow", TextBody: "text body"},
+			false,
+			`"invalid" is not valid blind carbon copy email`,
+		},
+		{
+			email{To: "valid@email.com", Cc: "valid2@email.com", Bcc: "valid2@email.com", Subject: "Wow", TextBody: "text body"},
+			false,
+			`"valid2@email.com" is used twice`,
+		},
+		{
+			email{To: "valid@email.com", Bcc: "valid2@email.com,valid3@email.com", Subject: "Wow", TextBody: "text body"},
+			true,
+			"",
+		},
 	}
 
 	for _, testCase := range testCases {
@@ -139,6 +210,290 @@ func TestValidate(t *testing.T) {
 	}
 }
 
+func TestSkipValidationForTrustedProducer(t *testing.T) {
+	trusted := newTestMailer(&config{trustProducer: true, contentGuard: contentGuardReject}, &fakeSES{})
+	untrusted := newTestMailer(&config{}, &fakeSES{})
+	e := &email{To: "not an email", TextBody: "short", SkipValidation: true}
+
+	if err := trusted.validate(e); err != nil {
+		t.Fatal("validation must be skipped for the trusted producer", err)
+	}
+	if err := untrusted.validate(e); err == nil {
+		t.Fatal("skip_validation must be ignored without TRUST_PRODUCER")
+	}
+
+	injected := &email{To: "to@test.com", Subject: "Wow\r\nBcc: hidden@test.com", SkipValidation: true}
+	if err := trusted.validate(injected); err == nil || err.Error() != "subject must not contain line breaks" {
+		t.Fatal("header injection must be rejected even for the trusted producer", err)
+	}
+	withHeaders := &email{To: "to@test.com", Headers: map[string]string{"Bad Name": "value"}, SkipValidation: true}
+	if err := trusted.validate(withHeaders); err == nil {
+		t.Fatal("custom headers must be checked even for the trusted producer")
+	}
+
+	acknowledger := &fakeAcknowledger{}
+	trusted.handle(amqp.Delivery{Acknowledger: acknowledger, Body: []byte(`{"to":"to@test.com","subject":"Wow","headers":{"Bad Name":"value"},"skip_validation":true}`)})
+	if acknowledger.nacked != 1 || acknowledger.requeued != 0 {
+		t.Fatal("unsafe email of the trusted producer must be rejected without requeue", acknowledger)
+	}
+}
+
+func TestSubjectRegex(t *testing.T) {
+	m := newTestMailer(&config{subjectRegexp: regexp.MustCompile(`^\[[A-Z]+-[0-9]+\] `)}, &fakeSES{})
+
+	if err := m.validate(&email{To: "to@test.com", Subject: "[OPS-42] Disk is full", TextBody: "text body"}); err != nil {
+		t.Fatal("matching subject must be accepted", err)
+	}
+	err := m.validate(&email{To: "to@test.com", Subject: "Disk is full", TextBody: "text body"})
+	if err == nil || err.Error() != `subject "Disk is full" doesn't match ^\[[A-Z]+-[0-9]+\] ` {
+		t.Fatal("not matching subject must be rejected", err)
+	}
+
+	sender := &fakeSES{}
+	m.ses = sender
+	acknowledger := &fakeAcknowledger{}
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: []byte(`{"to":"to@test.com","subject":"Disk is full","text_body":"text body"}`)})
+	if len(sender.inputs) != 0 || acknowledger.nacked != 1 || acknowledger.requeued != 0 {
+		t.Fatal("email must be rejected without requeue", len(sender.inputs), acknowledger)
+	}
+}
+
+func TestAddDefaultCopies(t *testing.T) {
+	email := email{To: "to@test.com", Cc: "cc@test.com", Bcc: "Shared@test.com"}
+
+	email.addDefaultCopies([]string{"shared@test.com", "team@test.com"}, []string{"to@test.com", "archive@test.com"})
+
+	if email.Cc != "cc@test.com,team@test.com" {
+		t.Fatal("Cc", email.Cc)
+	}
+	if email.Bcc != "Shared@test.com,archive@test.com" {
+		t.Fatal("Bcc", email.Bcc)
+	}
+	if err := email.validate(); err != nil && err.Error() != "subject must not be empty" {
+		t.Fatal("defaults must not introduce duplicates", err)
+	}
+}
+
+func TestAddDefaultReplyTo(t *testing.T) {
+	withoutReplyTo := email{To: "to@test.com"}
+	withReplyTo := email{To: "to@test.com", ReplyTo: "own@test.com"}
+
+	withoutReplyTo.addDefaultReplyTo([]string{"inbox@test.com"})
+	withReplyTo.addDefaultReplyTo([]string{"inbox@test.com"})
+
+	if withoutReplyTo.ReplyTo != "inbox@test.com" {
+		t.Fatal("default Reply-To must be applied", withoutReplyTo.ReplyTo)
+	}
+	if withReplyTo.ReplyTo != "own@test.com" {
+		t.Fatal("own Reply-To must be kept", withReplyTo.ReplyTo)
+	}
+}
+
+func TestForceRawSendUsesSendRawEmail(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{}, sender)
+
+	m.handle(amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body","force_raw_send":true}`)})
+
+	if len(sender.inputs) != 1 || !strings.Contains(string(sender.inputs[0].RawMessage.Data), "Subject: Wow") {
+		t.Fatal("email must be sent with SendRawEmail")
+	}
+}
+
+func TestAddPlaceholderBody(t *testing.T) {
+	withAttachment := email{To: "to@test.com", Subject: "Fax", Attaches: []emailAttach{{FileName: "fax.pdf", FileContentBase64Encoded: "JVBERi0="}}}
+	withoutAttachment := email{To: "to@test.com", Subject: "Fax"}
+
+	withAttachment.addPlaceholderBody()
+	withoutAttachment.addPlaceholderBody()
+
+	if err := withAttachment.validate(); err != nil || withAttachment.TextBody != emptyBodyPlaceholder {
+		t.Fatal("attachment-only email must be allowed with the placeholder body", err)
+	}
+	if err := withoutAttachment.validate(); err == nil {
+		t.Fatal("email without a body and attachments must still be invalid")
+	}
+}
+
+func TestAddDefaultCopiesToEmptyFields(t *testing.T) {
+	email := email{To: "to@test.com"}
+
+	email.addDefaultCopies([]string{"team@test.com"}, []string{"archive@test.com"})
+
+	if email.Cc != "team@test.com" || email.Bcc != "archive@test.com" {
+		t.Fatal("defaults must be set", email.Cc, email.Bcc)
+	}
+}
+
+func TestCreateEmailListsAllDestinations(t *testing.T) {
+	input := createEmail(emailOptions{from: "from@someone.com"}, &email{
+		To:       "to@test.com",
+		Cc:       "cc@test.com",
+		Bcc:      "bcc@test.com",
+		Subject:  "Wow",
+		TextBody: "text body",
+	})
+
+	destinations := aws.StringValueSlice(input.Destinations)
+	if strings.Join(destinations, ",") != "to@test.com,cc@test.com,bcc@test.com" {
+		t.Fatal("destinations", destinations)
+	}
+	if strings.Contains(string(input.RawMessage.Data), "bcc@test.com") {
+		t.Fatal("Bcc must not be written to the message")
+	}
+}
+
+func TestWarnManyRecipients(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	e := &email{To: "to1@test.com,to2@test.com", Cc: "cc@test.com", Subject: "Wow"}
+
+	e.warnManyRecipients(3)
+	if logged.Len() != 0 {
+		t.Fatal("warning must not be logged at the threshold", logged.String())
+	}
+
+	e.warnManyRecipients(2)
+	if !strings.Contains(logged.String(), "email has 3 recipients, more than 2: Wow") {
+		t.Fatal("warning must be logged above the threshold", logged.String())
+	}
+	if strings.Contains(logged.String(), "to1@test.com") {
+		t.Fatal("addresses must not be logged", logged.String())
+	}
+}
+
+type fakeSES struct {
+	inputs []*ses.SendRawEmailInput
+	err    error
+}
+
+func (s *fakeSES) SendRawEmailWithContext(ctx aws.Context, input *ses.SendRawEmailInput, opts ...request.Option) (*ses.SendRawEmailOutput, error) {
+	s.inputs = append(s.inputs, input)
+	// the options run on the request like the SDK does
+	r := &request.Request{RequestID: fmt.Sprintf("request-%d", len(s.inputs)), Error: s.err}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.Handlers.Complete.Run(r)
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &ses.SendRawEmailOutput{MessageId: aws.String(fmt.Sprintf("message-%d", len(s.inputs)))}, nil
+}
+
+func TestSendEmailSplitsRecipientsIntoChunks(t *testing.T) {
+	var recipients []string
+	for i := 0; i < 120; i++ {
+		recipients = append(recipients, fmt.Sprintf("recipient%d@test.com", i))
+	}
+	input := createEmail(emailOptions{from: "from@someone.com"}, &email{
+		To:       "to@test.com",
+		Bcc:      strings.Join(recipients[1:], ","),
+		Subject:  "Wow",
+		TextBody: "text body",
+	})
+	sender := &fakeSES{}
+
+	err := sendEmail(context.Background(), sender, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sender.inputs) != 3 {
+		t.Fatal("SES calls", len(sender.inputs))
+	}
+	sentTo := 0
+	for i, chunkInput := range sender.inputs {
+		if len(chunkInput.Destinations) > sesMaxDestinations {
+			t.Fatalf("call %d has %d destinations", i, len(chunkInput.Destinations))
+		}
+		if !bytes.Equal(chunkInput.RawMessage.Data, input.RawMessage.Data) {
+			t.Fatalf("call %d must send the same message", i)
+		}
+		sentTo += len(chunkInput.Destinations)
+	}
+	if sentTo != 120 {
+		t.Fatal("recipients", sentTo)
+	}
+}
+
+func TestConfiguredRecipientsPerCall(t *testing.T) {
+	var recipients []string
+	for i := 0; i < 25; i++ {
+		recipients = append(recipients, fmt.Sprintf("recipient%d@test.com", i))
+	}
+	sender := &fakeSES{}
+	m := newTestMailer(&config{maxDestinations: 10}, sender)
+
+	m.handle(amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: []byte(`{"to":"` + strings.Join(recipients, ",") + `","subject":"Wow","text_body":"text body"}`)})
+
+	if len(sender.inputs) != 3 {
+		t.Fatal("SES calls", len(sender.inputs))
+	}
+	for i, size := range []int{10, 10, 5} {
+		if len(sender.inputs[i].Destinations) != size {
+			t.Fatalf("call %d has %d destinations", i, len(sender.inputs[i].Destinations))
+		}
+	}
+	if (&config{}).maxDestinationsPerCall() != sesMaxDestinations {
+		t.Fatal("SES limit must be the default")
+	}
+}
+
+func TestSendEmailError(t *testing.T) {
+	sender := &fakeSES{err: errors.New("throttled")}
+
+	err := sendEmail(context.Background(), sender, createEmail(emailOptions{from: "from@someone.com"}, &email{To: "to@test.com", Subject: "Wow", TextBody: "text body"}))
+
+	if _, ok := err.(errAWSSendingEmail); !ok {
+		t.Fatal("SES error must be wrapped", err)
+	}
+}
+
+func TestCreateEmailSetsReturnPath(t *testing.T) {
+	input := createEmail(emailOptions{from: "from@someone.com", returnPath: "bounces@someone.com"}, &email{
+		To:       "to@test.com",
+		Subject:  "Wow",
+		TextBody: "text body",
+	})
+
+	if !strings.Contains(string(input.RawMessage.Data), "Return-Path: bounces@someone.com\r\n") {
+		t.Fatal("Return-Path header must be set", string(input.RawMessage.Data))
+	}
+}
+
+func TestPerMessageFromName(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{fromAddress: "support@someone.com"}, sender)
+
+	m.handle(amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: []byte(`{"from_name":"Служба поддержки","to":"to@test.com","subject":"Wow","text_body":"text body"}`)})
+
+	if len(sender.inputs) != 1 {
+		t.Fatal("email must be sent")
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(sender.inputs[0].RawMessage.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	from, err := msg.Header.AddressList("From")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(from) != 1 || from[0].Name != "Служба поддержки" || from[0].Address != "support@someone.com" {
+		t.Fatal("From", msg.Header.Get("From"))
+	}
+}
+
+func TestFromNameLineBreaksAreInvalid(t *testing.T) {
+	e := &email{To: "to@test.com", Subject: "Wow", TextBody: "text body", FromName: "Support\r\nBcc: victim@test.com"}
+
+	if err := e.validate(); err == nil || err.Error() != "from_name must not contain line breaks" {
+		t.Fatal("line breaks in the From name must be rejected", err)
+	}
+}
+
 // TODO
 func Test_createMail(t *testing.T) {
 	jsonEncodedMsg := []byte(`{
@@ -166,5 +521,471 @@ func Test_createMail(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	createEmail("from@someone.com", emailToSendMessage)
+	createEmail(emailOptions{from: "from@someone.com"}, emailToSendMessage)
+}
+
+type fakeChannel struct {
+	prefetchCount int
+	prefetchSize  int
+	declared      []string
+	queueArgs     map[string]amqp.Table
+	passive       []string
+	consumed      []string
+	exchanges     []string
+	bindings      []string
+	published     []publishing
+	qosErr        error
+	publishErr    error
+	// deliveries are returned by Consume instead of a channel which is never closed.
+	deliveries chan amqp.Delivery
+}
+
+type publishing struct {
+	exchange string
+	key      string
+	msg      amqp.Publishing
+}
+
+func (c *fakeChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	if c.qosErr != nil {
+		return c.qosErr
+	}
+	c.prefetchCount = prefetchCount
+	c.prefetchSize = prefetchSize
+	return nil
+}
+
+func (c *fakeChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	c.declared = append(c.declared, name)
+	if args != nil {
+		if c.queueArgs == nil {
+			c.queueArgs = map[string]amqp.Table{}
+		}
+		c.queueArgs[name] = args
+	}
+	return amqp.Queue{Name: name}, nil
+}
+
+func (c *fakeChannel) QueueDeclarePassive(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	c.passive = append(c.passive, name)
+	return amqp.Queue{Name: name}, nil
+}
+
+func (c *fakeChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	c.consumed = append(c.consumed, queue)
+	if c.deliveries != nil {
+		return c.deliveries, nil
+	}
+	return make(chan amqp.Delivery), nil
+}
+
+func (c *fakeChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	c.exchanges = append(c.exchanges, name+":"+kind)
+	return nil
+}
+
+func (c *fakeChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	c.bindings = append(c.bindings, exchange+"->"+name)
+	return nil
+}
+
+func (c *fakeChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	if c.publishErr != nil {
+		return c.publishErr
+	}
+	c.published = append(c.published, publishing{exchange, key, msg})
+	return nil
+}
+
+// fakeConnection hands out its channels in order and is closed after the last one.
+type fakeConnection struct {
+	channels []*fakeChannel
+	opened   int
+}
+
+func (c *fakeConnection) channel() (amqpChannel, error) {
+	if c.opened == len(c.channels) {
+		return nil, amqp.ErrClosed
+	}
+	c.opened++
+	return c.channels[c.opened-1], nil
+}
+
+func (c *fakeConnection) closed() bool {
+	return c.opened == len(c.channels)
+}
+
+func TestRunReopensClosedChannel(t *testing.T) {
+	first := &fakeChannel{deliveries: make(chan amqp.Delivery, 1)}
+	acknowledger := &fakeAcknowledger{}
+	first.deliveries <- amqp.Delivery{Acknowledger: acknowledger, Body: []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body"}`)}
+	close(first.deliveries)
+	second := &fakeChannel{deliveries: make(chan amqp.Delivery)}
+	close(second.deliveries)
+	conn := &fakeConnection{channels: []*fakeChannel{first, second}}
+	m := newTestMailer(&config{amqpQueue: "aws.ses.mailer"}, &fakeSES{})
+
+	m.run(conn, nil)
+
+	if conn.opened != 2 {
+		t.Fatal("closed channel must be reopened on the same connection", conn.opened)
+	}
+	if len(first.consumed) != 1 || len(second.consumed) != 1 || second.consumed[0] != "aws.ses.mailer" {
+		t.Fatal("consumer must be set up again on the new channel", first.consumed, second.consumed)
+	}
+	if acknowledger.acked != 1 {
+		t.Fatal("delivery of the first channel must be handled")
+	}
+	if m.channel != second {
+		t.Fatal("mailer must use the reopened channel")
+	}
+}
+
+func TestAMQPDialConfigHeartbeat(t *testing.T) {
+	if heartbeat := amqpDialConfig(&config{}).Heartbeat; heartbeat != defaultAMQPHeartbeat {
+		t.Fatal("default heartbeat", heartbeat)
+	}
+	if heartbeat := amqpDialConfig(&config{amqpHeartbeat: 30 * time.Second}).Heartbeat; heartbeat != 30*time.Second {
+		t.Fatal("configured heartbeat", heartbeat)
+	}
+}
+
+func TestConsumePassesPrefetchSize(t *testing.T) {
+	channel := &fakeChannel{}
+	if _, err := consume(channel, &config{amqpQueue: "aws.ses.mailer", amqpPrefetchSize: 1 << 20}); err != nil {
+		t.Fatal(err)
+	}
+
+	if channel.prefetchCount != 1 {
+		t.Fatal("prefetch count", channel.prefetchCount)
+	}
+	if channel.prefetchSize != 1<<20 {
+		t.Fatal("prefetch size", channel.prefetchSize)
+	}
+	if len(channel.consumed) != 1 || channel.consumed[0] != "aws.ses.mailer" {
+		t.Fatal("consumed queues", channel.consumed)
+	}
+}
+
+func TestConsumeFailsOnQosError(t *testing.T) {
+	channel := &fakeChannel{qosErr: errors.New("NOT_IMPLEMENTED - prefetch_size!=0")}
+
+	messages, err := consume(channel, &config{amqpQueue: "aws.ses.mailer", amqpPrefetchSize: 1 << 20})
+
+	if err == nil || err.Error() != "qos err: NOT_IMPLEMENTED - prefetch_size!=0" || messages != nil {
+		t.Fatal("setup must fail when the prefetch limit is not applied", err)
+	}
+	if len(channel.consumed) != 0 {
+		t.Fatal("queue must not be consumed without the prefetch limit", channel.consumed)
+	}
+}
+
+func TestConsumeDeclaresQueue(t *testing.T) {
+	channel := &fakeChannel{}
+	if _, err := consume(channel, &config{amqpQueue: "aws.ses.mailer"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(channel.declared) != 1 || len(channel.passive) != 0 {
+		t.Fatal("queue must be declared", channel.declared, channel.passive)
+	}
+}
+
+func TestConsumePassiveDeclare(t *testing.T) {
+	channel := &fakeChannel{}
+	if _, err := consume(channel, &config{amqpQueue: "aws.ses.mailer", amqpPassiveDeclare: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(channel.declared) != 0 {
+		t.Fatal("queue must not be redeclared", channel.declared)
+	}
+	if len(channel.passive) != 1 || channel.passive[0] != "aws.ses.mailer" {
+		t.Fatal("queue must be declared passively", channel.passive)
+	}
+}
+
+type fakeAcknowledger struct {
+	acked    int
+	nacked   int
+	requeued int
+}
+
+func (a *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.acked++
+	return nil
+}
+
+func (a *fakeAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.nacked++
+	if requeue {
+		a.requeued++
+	}
+	return nil
+}
+
+func (a *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+type noopSES struct{}
+
+func (noopSES) SendRawEmailWithContext(ctx aws.Context, input *ses.SendRawEmailInput, opts ...request.Option) (*ses.SendRawEmailOutput, error) {
+	return &ses.SendRawEmailOutput{MessageId: aws.String("message-id")}, nil
+}
+
+func newTestMailer(cfg *config, sender sesSender) *mailer {
+	if cfg.fromAddress == "" {
+		cfg.fromAddress = "from@someone.com"
+	}
+	return &mailer{
+		cfg:     cfg,
+		channel: &fakeChannel{},
+		ses:     sender,
+		fetcher: &fakeFetcher{},
+		stats:   &stats{},
+	}
+}
+
+type slowFetcher struct{}
+
+func (slowFetcher) size(ctx context.Context, url string) (int64, error) {
+	return -1, nil
+}
+
+func (slowFetcher) fetch(ctx context.Context, url string) ([]byte, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(time.Second):
+		return []byte("content"), nil
+	}
+}
+
+func TestMessageDeadlineNacksSlowFetch(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{messageDeadline: 20 * time.Millisecond, attachmentFetchPolicy: attachmentFetchDegrade}, sender)
+	m.fetcher = slowFetcher{}
+	acknowledger := &fakeAcknowledger{}
+	body := []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body","attaches":[{"file_name":"report.pdf","url":"https://files.test.com/report.pdf"}]}`)
+
+	start := time.Now()
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: body})
+
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatal("processing must be aborted at the deadline", time.Since(start))
+	}
+	if acknowledger.requeued != 1 || acknowledger.acked != 0 {
+		t.Fatal("message must be nacked for retry", acknowledger)
+	}
+	if len(sender.inputs) != 0 {
+		t.Fatal("email must not be sent", len(sender.inputs))
+	}
+}
+
+func TestEmailConfigurationSetOverridesGlobal(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{configurationSet: "default", allowedConfigurationSets: map[string]bool{"marketing": true}}, sender)
+	acknowledger := &fakeAcknowledger{}
+
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body"}`)})
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body","configuration_set":"marketing"}`)})
+
+	if len(sender.inputs) != 2 {
+		t.Fatal("SES calls", len(sender.inputs))
+	}
+	if set := aws.StringValue(sender.inputs[0].ConfigurationSetName); set != "default" {
+		t.Fatal("global configuration set must be used, got", set)
+	}
+	if set := aws.StringValue(sender.inputs[1].ConfigurationSetName); set != "marketing" {
+		t.Fatal("email configuration set must override the global one, got", set)
+	}
+}
+
+func TestEmailWithUnknownConfigurationSetIsRejected(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{configurationSet: "default", allowedConfigurationSets: map[string]bool{"marketing": true}}, sender)
+	rejected := &fakeAcknowledger{}
+	acknowledger := &fakeAcknowledger{}
+
+	m.handle(amqp.Delivery{Acknowledger: rejected, Body: []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body","configuration_set":"unknown"}`)})
+	// the consumer goes on with the next email
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body"}`)})
+
+	if rejected.nacked != 1 || rejected.requeued != 0 {
+		t.Fatal("email with unknown configuration set must be rejected without requeue", rejected)
+	}
+	if acknowledger.acked != 1 || len(sender.inputs) != 1 {
+		t.Fatal("next email must be sent", acknowledger, len(sender.inputs))
+	}
+}
+
+func TestFromAddressesRoundRobin(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{fromAddresses: []string{"a@someone.com", "b@someone.com", "c@someone.com"}}, sender)
+	acknowledger := &fakeAcknowledger{}
+
+	for i := 0; i < 6; i++ {
+		m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body"}`)})
+	}
+
+	var froms []string
+	for _, input := range sender.inputs {
+		raw := string(input.RawMessage.Data)
+		from := raw[strings.Index(raw, "From: ")+len("From: "):]
+		froms = append(froms, from[:strings.Index(from, "\r\n")])
+	}
+	if strings.Join(froms, ",") != "a@someone.com,b@someone.com,c@someone.com,a@someone.com,b@someone.com,c@someone.com" {
+		t.Fatal("From addresses must be used in turn", froms)
+	}
+}
+
+func TestLogSESRequestIDs(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	sender := &fakeSES{}
+	m := newTestMailer(&config{logSESRequestIDs: true}, sender)
+	acknowledger := &fakeAcknowledger{}
+
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body"}`)})
+	sender.err = errors.New("throttled")
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body"}`)})
+
+	if !strings.Contains(logged.String(), "ses request id request-1\n") {
+		t.Fatal("request id of the successful send must be logged", logged.String())
+	}
+	if !strings.Contains(logged.String(), "ses request id request-2 failed: throttled\n") {
+		t.Fatal("request id of the failed send must be logged", logged.String())
+	}
+}
+
+func TestCreateEmailPooledBuffersAreNotShared(t *testing.T) {
+	first := createEmail(emailOptions{from: "from@someone.com"}, &email{To: "first@test.com", Subject: "First", TextBody: "first body"})
+	firstRaw := string(first.RawMessage.Data)
+	second := createEmail(emailOptions{from: "from@someone.com"}, &email{To: "second@test.com", Subject: "Second", TextBody: strings.Repeat("second body ", 100)})
+
+	if string(first.RawMessage.Data) != firstRaw {
+		t.Fatal("raw message must not change once the buffer is reused", string(first.RawMessage.Data))
+	}
+	if !strings.Contains(firstRaw, "Subject: First\r\n") || strings.Contains(firstRaw, "second") {
+		t.Fatal("first raw message", firstRaw)
+	}
+	secondRaw := string(second.RawMessage.Data)
+	if !strings.Contains(secondRaw, "Subject: Second\r\n") || strings.Contains(secondRaw, "first") {
+		t.Fatal("second raw message", secondRaw)
+	}
+}
+
+func BenchmarkPipeline(b *testing.B) {
+	attachment := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("%PDF-1.4 representative attachment content\n"), 2000))
+	body, err := json.Marshal(email{
+		To:       "to@test.com,second@test.com",
+		Cc:       "cc@test.com",
+		Subject:  "Import Pre Alert – JFK 180-62148741",
+		HTMLBody: "Hello all,<br/>\n<br/>\nPlease see attached pre-alert for inbound Shipment<br/>\n<br/>\nThanks",
+		TextBody: "Hello all,\n\nPlease see attached pre-alert for inbound Shipment\n\nThanks",
+		Attaches: []emailAttach{
+			{FileName: "MAWB.pdf", FileContentBase64Encoded: attachment},
+			{FileName: "shipping_control_document.csv", FileContentBase64Encoded: "RlJPTSxDTElFTlQsQ09VTlQKVkVMT1gsLDIK"},
+		},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	m := newTestMailer(&config{}, noopSES{})
+	acknowledger := &fakeAcknowledger{}
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: body})
+	}
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "msgs/s")
+
+	if acknowledger.acked != b.N {
+		b.Fatal("acked", acknowledger.acked)
+	}
+}
+
+// timedSES records when every email is sent.
+type timedSES struct {
+	fakeSES
+	sentAt []time.Time
+}
+
+func (s *timedSES) SendRawEmailWithContext(ctx aws.Context, input *ses.SendRawEmailInput, opts ...request.Option) (*ses.SendRawEmailOutput, error) {
+	s.sentAt = append(s.sentAt, time.Now())
+	return s.fakeSES.SendRawEmailWithContext(ctx, input, opts...)
+}
+
+func TestRunWaitsInterMessageDelay(t *testing.T) {
+	channel := &fakeChannel{deliveries: make(chan amqp.Delivery, 2)}
+	for i := 0; i < 2; i++ {
+		channel.deliveries <- amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body"}`)}
+	}
+	close(channel.deliveries)
+	sender := &timedSES{}
+	m := newTestMailer(&config{amqpQueue: "aws.ses.mailer", interMessageDelay: 100 * time.Millisecond}, sender)
+
+	m.run(&fakeConnection{channels: []*fakeChannel{channel}}, nil)
+
+	if len(sender.sentAt) != 2 {
+		t.Fatal("sent emails", len(sender.sentAt))
+	}
+	if gap := sender.sentAt[1].Sub(sender.sentAt[0]); gap < 100*time.Millisecond {
+		t.Fatal("delay between the sends", gap)
+	}
+}
+
+func TestRunStopsDuringInterMessageDelay(t *testing.T) {
+	channel := &fakeChannel{deliveries: make(chan amqp.Delivery, 1)}
+	channel.deliveries <- amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body"}`)}
+	m := newTestMailer(&config{amqpQueue: "aws.ses.mailer", interMessageDelay: time.Hour}, &fakeSES{})
+	stop := make(chan os.Signal, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		stop <- syscall.SIGTERM
+	}()
+
+	done := make(chan bool)
+	go func() { done <- m.run(&fakeConnection{channels: []*fakeChannel{channel}}, stop) }()
+	select {
+	case stopped := <-done:
+		if !stopped {
+			t.Fatal("run must report the stop")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stop signal must not wait for the delay")
+	}
+}
+
+func TestRunStopsDuringRetryDelay(t *testing.T) {
+	channel := &fakeChannel{deliveries: make(chan amqp.Delivery, 1)}
+	acknowledger := &fakeAcknowledger{}
+	channel.deliveries <- amqp.Delivery{Acknowledger: acknowledger, Body: []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body"}`)}
+	m := newTestMailer(&config{amqpQueue: "aws.ses.mailer"}, &fakeSES{err: errors.New("service unavailable")})
+	m.retryDelay = time.Hour
+	stop := make(chan os.Signal, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		stop <- syscall.SIGTERM
+	}()
+
+	done := make(chan bool)
+	go func() { done <- m.run(&fakeConnection{channels: []*fakeChannel{channel}}, stop) }()
+	select {
+	case stopped := <-done:
+		if !stopped {
+			t.Fatal("run must report the stop")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stop signal must not wait for the retry delay")
+	}
+	if acknowledger.requeued != 1 {
+		t.Fatal("failed email must be requeued", acknowledger)
+	}
 }
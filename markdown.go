@@ -0,0 +1,51 @@
+package main
+
+import "github.com/russross/blackfriday/v2"
+
+const (
+	contentTypeHTML     = "text/html"
+	contentTypeMarkdown = "text/markdown"
+	contentTypeEnriched = "text/enriched"
+)
+
+// bodyContentTypes are the supported content types of the html_body field.
+var bodyContentTypes = map[string]bool{
+	contentTypeHTML:     true,
+	contentTypeMarkdown: true,
+	contentTypeEnriched: true,
+}
+
+// markdownRenderer drops the raw HTML of the markdown and links only to the
+// safe protocols, so the rendered HTML needs no further sanitizing.
+var markdownRenderer = blackfriday.NewHTMLRenderer(blackfriday.HTMLRendererParameters{
+	Flags: blackfriday.CommonHTMLFlags | blackfriday.SkipHTML | blackfriday.Safelink,
+})
+
+func markdownToHTML(markdown string) string {
+	return string(blackfriday.Run([]byte(markdown), blackfriday.WithRenderer(markdownRenderer)))
+}
+
+// renderMarkdown replaces the markdown bodies with the HTML rendered from them.
+// The markdown body is kept as the text body when the email asks for it.
+func (e *email) renderMarkdown() {
+	if e.BodyContentType == contentTypeMarkdown {
+		e.HTMLBody = markdownToHTML(e.HTMLBody)
+		e.BodyContentType = contentTypeHTML
+	}
+	// both bodies are left for validation to reject
+	if len(e.MarkdownBody) > 0 && len(e.HTMLBody) == 0 {
+		e.HTMLBody = markdownToHTML(e.MarkdownBody)
+		if e.MarkdownAsText && len(e.TextBody) == 0 {
+			e.TextBody = e.MarkdownBody
+		}
+		e.MarkdownBody = ""
+	}
+}
+
+// htmlBodyContentType is the content type the html body is sent with.
+func (e *email) htmlBodyContentType() string {
+	if len(e.BodyContentType) == 0 {
+		return contentTypeHTML
+	}
+	return e.BodyContentType
+}
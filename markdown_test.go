@@ -0,0 +1,117 @@
+package main
+
+import (
+	"github.com/streadway/amqp"
+	"strings"
+	"testing"
+)
+
+func TestMarkdownBodyIsRenderedToHTML(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{}, sender)
+	body := []byte(`{"to":"to@test.com","subject":"Wow","html_body":"Hello *all*","body_content_type":"text/markdown"}`)
+
+	m.handle(amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: body})
+
+	if len(sender.inputs) != 1 {
+		t.Fatal("SES calls", len(sender.inputs))
+	}
+	raw := string(sender.inputs[0].RawMessage.Data)
+	if !strings.Contains(raw, "Content-Type: text/html; charset=UTF-8") || !strings.Contains(raw, "<p>Hello <em>all</em></p>") {
+		t.Fatal("markdown body must be sent as HTML", raw)
+	}
+}
+
+func TestEnrichedBodyContentType(t *testing.T) {
+	raw := string(createEmail(emailOptions{from: "from@someone.com"}, &email{
+		To:              "to@test.com",
+		Subject:         "Wow",
+		HTMLBody:        "<bold>Wow</bold>",
+		BodyContentType: contentTypeEnriched,
+	}).RawMessage.Data)
+
+	if !strings.Contains(raw, "Content-Type: text/enriched; charset=UTF-8") {
+		t.Fatal("body must be sent with the given content type", raw)
+	}
+}
+
+func TestValidateBodyContentType(t *testing.T) {
+	e := &email{To: "to@test.com", Subject: "Wow", HTMLBody: "body", BodyContentType: "application/pdf"}
+
+	err := e.validate()
+
+	if err == nil || err.Error() != `"application/pdf" body content type is not supported` {
+		t.Fatal("unsupported body content type must be rejected", err)
+	}
+}
+
+func TestHTMLAndTextBodiesAreAlternatives(t *testing.T) {
+	raw := string(createEmail(emailOptions{from: "from@someone.com"}, &email{
+		To:       "to@test.com",
+		Subject:  "Wow",
+		HTMLBody: "<b>html body</b>",
+		TextBody: "text body",
+	}).RawMessage.Data)
+
+	text, html := strings.Index(raw, "text body"), strings.Index(raw, "<b>html body</b>")
+	if !strings.Contains(raw, "multipart/alternative") || text < 0 || html < text {
+		t.Fatal("text and html bodies must be sent as alternatives, html last", raw)
+	}
+}
+
+func TestMarkdownBodyWithHeadingAndLink(t *testing.T) {
+	e := &email{MarkdownBody: "# Report\n\nSee [the details](https://example.com/report).", MarkdownAsText: true}
+
+	e.renderMarkdown()
+
+	if !strings.Contains(e.HTMLBody, "<h1>Report</h1>") || !strings.Contains(e.HTMLBody, `<p>See <a href="https://example.com/report">the details</a>.</p>`) {
+		t.Fatal("rendered html", e.HTMLBody)
+	}
+	if e.TextBody != "# Report\n\nSee [the details](https://example.com/report)." {
+		t.Fatal("markdown must be kept as the text body", e.TextBody)
+	}
+}
+
+func TestMarkdownBodyIsSanitized(t *testing.T) {
+	e := &email{MarkdownBody: "Hi <script>alert(1)</script>\n\n[click](javascript:alert(1))"}
+
+	e.renderMarkdown()
+
+	if strings.Contains(e.HTMLBody, "<script>") || strings.Contains(e.HTMLBody, "javascript:") {
+		t.Fatal("rendered html must be sanitized", e.HTMLBody)
+	}
+	if len(e.TextBody) != 0 {
+		t.Fatal("markdown must not be kept as the text body unless asked", e.TextBody)
+	}
+}
+
+func TestMarkdownBodyIsNotCombinedWithHTMLBody(t *testing.T) {
+	e := &email{To: "to@test.com", Subject: "Wow", HTMLBody: "<p>html</p>", MarkdownBody: "markdown"}
+
+	e.renderMarkdown()
+	err := e.validate()
+
+	if err == nil || err.Error() != "markdown_body must not be combined with html_body" {
+		t.Fatal("markdown and html bodies must be rejected together", err)
+	}
+}
+
+func TestEmailWithUnsupportedBodyContentTypeIsRejected(t *testing.T) {
+	for _, body := range []string{
+		`{"to":"to@test.com","subject":"Wow","html_body":"Hello","body_content_type":"text/rtf"}`,
+		`{"to":"to@test.com","subject":"Wow","html_body":"<p>html</p>","markdown_body":"markdown"}`,
+	} {
+		sender := &fakeSES{}
+		m := newTestMailer(&config{}, sender)
+		acknowledger := &fakeAcknowledger{}
+
+		m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: []byte(body)})
+
+		if acknowledger.nacked != 1 || acknowledger.requeued != 0 {
+			t.Fatal("email must be rejected without requeue", body, acknowledger)
+		}
+		if len(sender.inputs) != 0 {
+			t.Fatal("email must not be sent", body, len(sender.inputs))
+		}
+	}
+}
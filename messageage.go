@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"github.com/streadway/amqp"
+	"log"
+	"time"
+)
+
+// messageAge returns how long ago the message was published, it is 0 for the
+// messages without the timestamp property.
+func messageAge(message amqp.Delivery, now time.Time) time.Duration {
+	if message.Timestamp.IsZero() {
+		return 0
+	}
+	return now.Sub(message.Timestamp)
+}
+
+// dropStale takes the message older than MAX_MESSAGE_AGE out of the queue
+// unsent, e.g. after an outage, it is moved to the dead letter queue when one
+// is configured.
+func (m *mailer) dropStale(message amqp.Delivery, age time.Duration) {
+	reason := fmt.Errorf("message of %s age exceeds the limit of %s", age.Round(time.Second), m.cfg.maxMessageAge)
+	m.stats.failed()
+	if m.cfg.amqpDLQ != "" {
+		m.deadLetter(message, reason)
+		return
+	}
+	log.Println("stale message is dropped:", reason)
+	message.Ack(false)
+	m.stats.dropped()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"github.com/streadway/amqp"
+	"testing"
+	"time"
+)
+
+const freshEmailBody = `{"to":"to@test.com","subject":"Wow","text_body":"text body"}`
+
+func TestStaleMessageIsDropped(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{maxMessageAge: time.Hour}, sender)
+	acknowledger := &fakeAcknowledger{}
+
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Timestamp: time.Now().Add(-2 * time.Hour), Body: []byte(freshEmailBody)})
+
+	if len(sender.inputs) != 0 {
+		t.Fatal("stale email must not be sent")
+	}
+	if acknowledger.acked != 1 || acknowledger.nacked != 0 {
+		t.Fatal("stale message must be acked")
+	}
+	if report := m.stats.report(); report.Dropped != 1 {
+		t.Fatal("stale message must be counted as dropped", report)
+	}
+}
+
+func TestStaleMessageIsDeadLettered(t *testing.T) {
+	channel := &fakeChannel{}
+	m := newTestMailer(&config{maxMessageAge: time.Hour, amqpDLQ: "aws.ses.mailer.dlq"}, &fakeSES{})
+	m.channel = channel
+	acknowledger := &fakeAcknowledger{}
+
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Timestamp: time.Now().Add(-2 * time.Hour), Body: []byte(freshEmailBody)})
+
+	if len(channel.published) != 1 || channel.published[0].key != "aws.ses.mailer.dlq" {
+		t.Fatal("stale message must be moved to the dead letter queue", channel.published)
+	}
+	if reason := channel.published[0].msg.Headers["x-rejection-reason"]; reason != "message of 2h0m0s age exceeds the limit of 1h0m0s" {
+		t.Fatal("rejection reason", reason)
+	}
+}
+
+func TestFreshAndUntimestampedMessagesAreSent(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{maxMessageAge: time.Hour}, sender)
+
+	m.handle(amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Timestamp: time.Now().Add(-time.Minute), Body: []byte(freshEmailBody)})
+	m.handle(amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: []byte(freshEmailBody)})
+
+	if len(sender.inputs) != 2 {
+		t.Fatal("fresh and untimestamped emails must be sent", len(sender.inputs))
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	typeTransactional = "transactional"
+	typeMarketing     = "marketing"
+)
+
+// messageTypes are the values the "type" field of an email may have.
+var messageTypes = map[string]bool{
+	typeTransactional: true,
+	typeMarketing:     true,
+}
+
+func (e *email) validateType() error {
+	if len(e.Type) > 0 && !messageTypes[e.Type] {
+		return errInvalidEmail{fmt.Errorf(`"%s" type is not supported`, e.Type)}
+	}
+	return nil
+}
+
+// logLabels returns the type, the tenant and the tracking id of the email for
+// the log lines, each one preceded by a space, or an empty string when none is set.
+func (e *email) logLabels() string {
+	var labels string
+	if len(e.Type) > 0 {
+		labels += " type=" + e.Type
+	}
+	if len(e.Tenant) > 0 {
+		labels += " tenant=" + e.Tenant
+	}
+	if len(e.trackingID) > 0 {
+		labels += " tracking_id=" + e.trackingID
+	}
+	return labels
+}
+
+// parseTypeValues parses the comma-separated type=value pairs.
+func parseTypeValues(v string) (map[string]string, error) {
+	values := map[string]string{}
+	for _, pair := range strings.Split(v, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || len(strings.TrimSpace(parts[1])) == 0 {
+			return nil, fmt.Errorf(`"%s" must be in type=value form`, pair)
+		}
+		messageType := strings.TrimSpace(parts[0])
+		if !messageTypes[messageType] {
+			return nil, fmt.Errorf(`"%s" type is not supported`, messageType)
+		}
+		values[messageType] = strings.TrimSpace(parts[1])
+	}
+	return values, nil
+}
+
+// parseTypeRates parses the comma-separated type=emails per second pairs.
+func parseTypeRates(v string) (map[string]float64, error) {
+	values, err := parseTypeValues(v)
+	if err != nil {
+		return nil, err
+	}
+	rates := map[string]float64{}
+	for messageType, value := range values {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf(`"%s" is not valid rate`, value)
+		}
+		rates[messageType] = rate
+	}
+	return rates, nil
+}
+
+// rateLimiter spaces the sends evenly to at most the given number per second.
+type rateLimiter struct {
+	interval time.Duration
+	now      func() time.Time
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// newRateLimiter returns nil without the rate, so the sends are not limited.
+func newRateLimiter(perSecond float64) *rateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / perSecond), now: time.Now}
+}
+
+// reserve takes the next send slot and returns 0 when it is due, otherwise how
+// long to wait for it.
+func (l *rateLimiter) reserve() time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if now.Before(l.next) {
+		return l.next.Sub(now)
+	}
+	l.next = now.Add(l.interval)
+	return 0
+}
+
+// setRate changes the rate, the slot already taken is kept.
+func (l *rateLimiter) setRate(perSecond float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.interval = time.Duration(float64(time.Second) / perSecond)
+}
+
+// newTypeLimiters creates the rate limiter of every type with a rate.
+func newTypeLimiters(rates map[string]float64) map[string]*rateLimiter {
+	limiters := map[string]*rateLimiter{}
+	for messageType, rate := range rates {
+		limiters[messageType] = newRateLimiter(rate)
+	}
+	return limiters
+}
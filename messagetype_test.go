@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/streadway/amqp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMarketingEmailUsesMarketingDefaults(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{
+		configurationSet:      "default-set",
+		typeConfigurationSets: map[string]string{typeMarketing: "marketing-set"},
+		typeFromAddresses:     map[string]string{typeMarketing: "news@someone.com"},
+	}, sender)
+	now := time.Date(2020, 1, 17, 10, 0, 0, 0, time.UTC)
+	marketing := newRateLimiter(2)
+	marketing.now = func() time.Time { return now }
+	transactional := newRateLimiter(2)
+	transactional.now = func() time.Time { return now }
+	m.typeLimiters = map[string]*rateLimiter{typeMarketing: marketing, typeTransactional: transactional}
+	var out bytes.Buffer
+	m.emf = newEMFEmitter(&out)
+
+	m.handle(amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: []byte(`{"type":"marketing","to":"to@test.com","subject":"Sale","text_body":"text body"}`)})
+
+	if len(sender.inputs) != 1 {
+		t.Fatal("marketing email must be sent")
+	}
+	if aws.StringValue(sender.inputs[0].ConfigurationSetName) != "marketing-set" {
+		t.Fatal("marketing configuration set must be used", aws.StringValue(sender.inputs[0].ConfigurationSetName))
+	}
+	if !strings.Contains(string(sender.inputs[0].RawMessage.Data), "From: news@someone.com\r\n") {
+		t.Fatal("marketing From address must be used", string(sender.inputs[0].RawMessage.Data))
+	}
+	if marketing.reserve() != 500*time.Millisecond {
+		t.Fatal("marketing rate limiter must be used")
+	}
+	if transactional.reserve() != 0 {
+		t.Fatal("transactional rate limiter must not be used")
+	}
+	if !strings.Contains(out.String(), `"Type":"marketing"`) || !strings.Contains(out.String(), `["Service","Type"]`) {
+		t.Fatal("metrics must be labelled with the type", out.String())
+	}
+}
+
+func TestEmailWithoutTypeUsesGlobalDefaults(t *testing.T) {
+	cfg := &config{configurationSet: "default-set", typeConfigurationSets: map[string]string{typeMarketing: "marketing-set"}}
+
+	if set := cfg.configurationSetFor(&email{}); set != "default-set" {
+		t.Fatal("global configuration set must be used", set)
+	}
+}
+
+func TestUnknownTypeIsInvalid(t *testing.T) {
+	e := &email{Type: "newsletter"}
+
+	if err := e.validateType(); err == nil || err.Error() != `"newsletter" type is not supported` {
+		t.Fatal("unknown type must be rejected", err)
+	}
+
+	sender := &fakeSES{}
+	m := newTestMailer(&config{}, sender)
+	acknowledger := &fakeAcknowledger{}
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body","type":"newsletter"}`)})
+	if acknowledger.nacked != 1 || acknowledger.requeued != 0 || len(sender.inputs) != 0 {
+		t.Fatal("email of unknown type must be rejected without requeue", acknowledger, len(sender.inputs))
+	}
+}
+
+func TestParseTypeValues(t *testing.T) {
+	values, err := parseTypeValues("marketing=marketing-set, transactional=alerts")
+	if err != nil || values[typeMarketing] != "marketing-set" || values[typeTransactional] != "alerts" {
+		t.Fatal("type values", values, err)
+	}
+	if _, err := parseTypeValues("newsletter=set"); err == nil {
+		t.Fatal("unknown type must fail")
+	}
+	if _, err := parseTypeRates("marketing=fast"); err == nil {
+		t.Fatal("invalid rate must fail")
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2020, 1, 17, 10, 0, 0, 0, time.UTC)
+	l := newRateLimiter(4)
+	l.now = func() time.Time { return now }
+
+	if l.reserve() != 0 {
+		t.Fatal("first send must not wait")
+	}
+	if wait := l.reserve(); wait != 250*time.Millisecond {
+		t.Fatal("next send must wait for its slot", wait)
+	}
+	now = now.Add(250 * time.Millisecond)
+	if l.reserve() != 0 {
+		t.Fatal("send must not wait once its slot is due")
+	}
+
+	var disabled *rateLimiter
+	if newRateLimiter(0) != nil || disabled.reserve() != 0 {
+		t.Fatal("limiter without a rate must be disabled")
+	}
+}
+
+func TestLogLabels(t *testing.T) {
+	if labels := (&email{Type: "marketing", Tenant: "acme"}).logLabels(); labels != " type=marketing tenant=acme" {
+		t.Fatal("labels", labels)
+	}
+	if labels := (&email{}).logLabels(); labels != "" {
+		t.Fatal("email without type and tenant must have no labels", labels)
+	}
+}
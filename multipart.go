@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"strings"
+)
+
+// isMultipart reports whether the delivery is a multipart payload by its content type.
+func isMultipart(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && strings.HasPrefix(mediaType, "multipart/")
+}
+
+// decodeMultipartEmail parses the multipart payload whose first part is the JSON
+// email and the next ones are the raw contents of the attachments, an attachment
+// refers to its part by "part", 1 being the part after the JSON. The contents
+// are sent without the base64 overhead of the JSON. A malformed payload is
+// invalid, retrying doesn't fix it.
+func decodeMultipartEmail(contentType string, body []byte) (*email, error) {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, errInvalidEmail{err}
+	}
+	if len(params["boundary"]) == 0 {
+		return nil, errInvalidEmail{errors.New("multipart payload has no boundary")}
+	}
+
+	reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	var parts [][]byte
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errInvalidEmail{err}
+		}
+		content, err := ioutil.ReadAll(part)
+		if err != nil {
+			return nil, errInvalidEmail{err}
+		}
+		parts = append(parts, content)
+	}
+	if len(parts) == 0 {
+		return nil, errInvalidEmail{errors.New("multipart payload has no parts")}
+	}
+
+	e, err := decodeEmail(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	for i, attach := range e.Attaches {
+		if attach.Part == 0 {
+			continue
+		}
+		if attach.Part < 0 || attach.Part >= len(parts) {
+			return nil, errInvalidEmail{fmt.Errorf(`attachment "%s" refers to part %d, the payload has %d`, attach.FileName, attach.Part, len(parts)-1)}
+		}
+		e.Attaches[i].content = parts[attach.Part]
+	}
+	return e, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"github.com/streadway/amqp"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// multipartPayload builds the multipart delivery body of the JSON email and the raw parts.
+func multipartPayload(t *testing.T, emailJSON string, parts ...[]byte) (string, []byte) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	jsonPart, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonPart.Write([]byte(emailJSON))
+	for _, content := range parts {
+		part, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/octet-stream"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(content)
+	}
+	writer.Close()
+	return "multipart/mixed; boundary=" + writer.Boundary(), body.Bytes()
+}
+
+func TestMultipartDeliveryAttachesRawParts(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{multipartPayloads: true}, sender)
+	contentType, body := multipartPayload(t,
+		`{"to":"to@test.com","subject":"Wow","text_body":"text body","attaches":[{"file_name":"report.pdf","part":2},{"file_name":"stub.png","part":1}]}`,
+		[]byte("\x89PNG raw bytes"), []byte("%PDF raw bytes"))
+	acknowledger := &fakeAcknowledger{}
+
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, ContentType: contentType, Body: body})
+
+	if acknowledger.acked != 1 || len(sender.inputs) != 1 {
+		t.Fatal("multipart email must be sent")
+	}
+	message, err := mail.ReadMessage(bytes.NewReader(sender.inputs[0].RawMessage.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := string(sender.inputs[0].RawMessage.Data)
+	for _, expected := range []string{`filename="report.pdf"`, "JVBERiByYXcgYnl0ZXM=", `filename="stub.png"`, "iVBORyByYXcgYnl0ZXM="} {
+		if !strings.Contains(raw, expected) {
+			t.Fatal("attachments must be attached from the raw parts", expected, raw)
+		}
+	}
+	if message.Header.Get("Subject") != "Wow" {
+		t.Fatal("email must be decoded from the first part", message.Header.Get("Subject"))
+	}
+}
+
+func TestMultipartAttachmentWithMissingPart(t *testing.T) {
+	contentType, body := multipartPayload(t, `{"to":"to@test.com","attaches":[{"file_name":"report.pdf","part":2}]}`, []byte("one"))
+
+	_, err := decodeMultipartEmail(contentType, body)
+
+	if err == nil || err.Error() != `attachment "report.pdf" refers to part 2, the payload has 1` {
+		t.Fatal("missing part must fail", err)
+	}
+}
+
+func TestMultipartDeliveryWithMissingPartIsDeadLettered(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{multipartPayloads: true, amqpDLQ: "mailer.dlq"}, sender)
+	contentType, body := multipartPayload(t, `{"to":"to@test.com","subject":"Wow","text_body":"text body","attaches":[{"file_name":"report.pdf","part":2}]}`, []byte("one"))
+	acknowledger := &fakeAcknowledger{}
+
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, ContentType: contentType, Body: body})
+
+	if acknowledger.acked != 1 || acknowledger.requeued != 0 {
+		t.Fatal("multipart payload with missing part must not be requeued", acknowledger)
+	}
+	published := m.channel.(*fakeChannel).published
+	if len(published) != 1 || published[0].key != "mailer.dlq" {
+		t.Fatal("multipart payload with missing part must be dead-lettered", published)
+	}
+	if len(sender.inputs) != 0 {
+		t.Fatal("email must not be sent", len(sender.inputs))
+	}
+}
+
+func TestIsMultipart(t *testing.T) {
+	if !isMultipart("multipart/mixed; boundary=abc") || isMultipart("application/json") || isMultipart("") {
+		t.Fatal("content type check")
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	mxLookupTimeout = 5 * time.Second
+	// mxCacheTTL is how long a deliverable domain is cached, a domain without
+	// MX or A record is looked up again sooner as it may be fixed.
+	mxCacheTTL         = time.Hour
+	mxNegativeCacheTTL = 5 * time.Minute
+	// mxCacheSize bounds the number of cached domains.
+	mxCacheSize = 10000
+
+	// lookupFailOpen sends the email when the recipient domain can't be looked up.
+	lookupFailOpen = "open"
+	// lookupFailClosed holds the email back until the recipient domain can be looked up.
+	lookupFailClosed = "closed"
+)
+
+// errLookupFailed is returned when a recipient domain could not be looked up
+// under the fail-closed policy, the email is retried later.
+var errLookupFailed = errors.New("domain lookup failed")
+
+// dnsResolver is the part of *net.Resolver used to validate recipient domains.
+type dnsResolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// mxValidator rejects recipients whose domain has neither MX nor A record.
+// Lookup results are cached per domain for a while.
+type mxValidator struct {
+	resolver dnsResolver
+	// allowSimulator skips the lookup of the SES mailbox simulator domain.
+	allowSimulator bool
+	// failClosed fails the validation when a domain can't be looked up, the
+	// recipient is let through otherwise.
+	failClosed bool
+
+	now    func() time.Time
+	mu     sync.Mutex
+	cached map[string]mxCacheEntry
+}
+
+type mxCacheEntry struct {
+	deliverable bool
+	expires     time.Time
+}
+
+func newMXValidator(resolver dnsResolver) *mxValidator {
+	return &mxValidator{resolver: resolver, now: time.Now, cached: map[string]mxCacheEntry{}}
+}
+
+func (v *mxValidator) validate(e *email) error {
+	for _, recipient := range e.recipients() {
+		domain := strings.ToLower(recipient[strings.LastIndex(recipient, "@")+1:])
+		if v.allowSimulator && domain == sesSimulatorDomain {
+			continue
+		}
+		deliverable, err := v.isDeliverable(domain)
+		if err != nil && v.failClosed {
+			return fmt.Errorf(`"%s" %w: %v`, recipient, errLookupFailed, err)
+		}
+		if err != nil {
+			log.Printf(`"%s" domain lookup error, the recipient is not checked: %v`, recipient, err)
+			continue
+		}
+		if !deliverable {
+			return errInvalidEmail{fmt.Errorf(`"%s" domain has no MX record`, recipient)}
+		}
+	}
+	return nil
+}
+
+func (v *mxValidator) isDeliverable(domain string) (bool, error) {
+	v.mu.Lock()
+	entry, ok := v.cached[domain]
+	v.mu.Unlock()
+	if ok && v.now().Before(entry.expires) {
+		return entry.deliverable, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mxLookupTimeout)
+	defer cancel()
+	deliverable, err := v.lookup(ctx, domain)
+	if err != nil {
+		return false, err
+	}
+
+	v.store(domain, deliverable)
+	return deliverable, nil
+}
+
+// store caches the lookup result, expired entries are evicted once the cache
+// is full and an arbitrary one if none has expired.
+func (v *mxValidator) store(domain string, deliverable bool) {
+	now := v.now()
+	ttl := mxCacheTTL
+	if !deliverable {
+		ttl = mxNegativeCacheTTL
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, ok := v.cached[domain]; !ok && len(v.cached) >= mxCacheSize {
+		for cached, entry := range v.cached {
+			if !now.Before(entry.expires) {
+				delete(v.cached, cached)
+			}
+		}
+		for cached := range v.cached {
+			if len(v.cached) < mxCacheSize {
+				break
+			}
+			delete(v.cached, cached)
+		}
+	}
+	v.cached[domain] = mxCacheEntry{deliverable: deliverable, expires: now.Add(ttl)}
+}
+
+func (v *mxValidator) lookup(ctx context.Context, domain string) (bool, error) {
+	mxs, err := v.resolver.LookupMX(ctx, domain)
+	if err != nil && !isNotFound(err) {
+		return false, err
+	}
+	if len(mxs) > 0 {
+		return true, nil
+	}
+
+	hosts, err := v.resolver.LookupHost(ctx, domain)
+	if err != nil && !isNotFound(err) {
+		return false, err
+	}
+	return len(hosts) > 0, nil
+}
+
+func isNotFound(err error) bool {
+	dnsErr, ok := err.(*net.DNSError)
+	return ok && dnsErr.IsNotFound
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/streadway/amqp"
+	"net"
+	"testing"
+	"time"
+)
+
+type fakeResolver struct {
+	mxs     map[string][]*net.MX
+	hosts   map[string][]string
+	lookups int
+}
+
+func (r *fakeResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	r.lookups++
+	if mxs, ok := r.mxs[name]; ok {
+		return mxs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (r *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if hosts, ok := r.hosts[host]; ok {
+		return hosts, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func TestMXValidator(t *testing.T) {
+	resolver := &fakeResolver{
+		mxs:   map[string][]*net.MX{"mx.com": {{Host: "mail.mx.com.", Pref: 10}}},
+		hosts: map[string][]string{"a-only.com": {"192.0.2.1"}},
+	}
+	validator := newMXValidator(resolver)
+
+	if err := validator.validate(&email{To: "someone@mx.com", Cc: "someone@a-only.com"}); err != nil {
+		t.Fatal("domains with MX or A record must be accepted", err)
+	}
+
+	err := validator.validate(&email{To: "someone@mx.com,someone@nomx.com"})
+	if err == nil || err.Error() != `"someone@nomx.com" domain has no MX record` {
+		t.Fatal("domain without MX must be rejected", err)
+	}
+
+	lookups := resolver.lookups
+	validator.validate(&email{To: "other@NOMX.com,other@mx.com"})
+	if resolver.lookups != lookups {
+		t.Fatal("lookups must be cached", resolver.lookups)
+	}
+}
+
+func TestMXValidatorCacheExpires(t *testing.T) {
+	resolver := &fakeResolver{mxs: map[string][]*net.MX{"mx.com": {{Host: "mail.mx.com.", Pref: 10}}}}
+	validator := newMXValidator(resolver)
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	validator.now = func() time.Time { return now }
+
+	validator.validate(&email{To: "someone@mx.com"})
+	validator.validate(&email{To: "someone@nomx.com"})
+	lookups := resolver.lookups
+
+	now = now.Add(mxNegativeCacheTTL)
+	validator.validate(&email{To: "someone@mx.com"})
+	if resolver.lookups != lookups {
+		t.Fatal("deliverable domain must still be cached", resolver.lookups)
+	}
+	resolver.mxs["nomx.com"] = []*net.MX{{Host: "mail.nomx.com.", Pref: 10}}
+	if err := validator.validate(&email{To: "someone@nomx.com"}); err != nil {
+		t.Fatal("domain without MX must be looked up again", err)
+	}
+
+	lookups = resolver.lookups
+	now = now.Add(mxCacheTTL)
+	validator.validate(&email{To: "someone@mx.com"})
+	if resolver.lookups != lookups+1 {
+		t.Fatal("deliverable domain must be looked up again", resolver.lookups)
+	}
+}
+
+func TestMXValidatorCacheIsBounded(t *testing.T) {
+	validator := newMXValidator(&fakeResolver{})
+	for i := 0; i < mxCacheSize+10; i++ {
+		validator.validate(&email{To: fmt.Sprintf("someone@domain%d.com", i)})
+	}
+	if len(validator.cached) != mxCacheSize {
+		t.Fatal("cache must be bounded", len(validator.cached))
+	}
+}
+
+func TestMXValidatorRejectsEmail(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{}, sender)
+	m.mx = newMXValidator(&fakeResolver{})
+	acknowledger := &fakeAcknowledger{}
+
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: []byte(`{"to":"to@nomx.com","subject":"Wow","text_body":"text body"}`)})
+
+	if len(sender.inputs) != 0 {
+		t.Fatal("email to a domain without MX must not be sent")
+	}
+	if acknowledger.nacked != 1 || acknowledger.requeued != 0 {
+		t.Fatal("email must be rejected without requeue", acknowledger)
+	}
+}
+
+// failingResolver fails every lookup like an unreachable DNS server.
+type failingResolver struct{}
+
+func (failingResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	return nil, &net.DNSError{Err: "i/o timeout", Name: name, IsTimeout: true}
+}
+
+func (failingResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	return nil, &net.DNSError{Err: "i/o timeout", Name: host, IsTimeout: true}
+}
+
+func TestMXLookupFailOpen(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{}, sender)
+	m.mx = newMXValidator(failingResolver{})
+	acknowledger := &fakeAcknowledger{}
+
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body"}`)})
+
+	if len(sender.inputs) != 1 || acknowledger.acked != 1 {
+		t.Fatal("email must be sent when the lookup fails open")
+	}
+}
+
+func TestMXLookupFailClosed(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{}, sender)
+	m.mx = newMXValidator(failingResolver{})
+	m.mx.failClosed = true
+	acknowledger := &fakeAcknowledger{}
+
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body"}`)})
+
+	if len(sender.inputs) != 0 {
+		t.Fatal("email must not be sent when the lookup fails closed")
+	}
+	if acknowledger.requeued != 1 {
+		t.Fatal("email must be held back for a retry", acknowledger)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// payloadVersion is the latest version of the email payload format.
+const payloadVersion = 1
+
+// decodeEmail parses the message body according to its payload version.
+// Payloads without version are treated as version 1.
+func decodeEmail(body []byte) (*email, error) {
+	var versioned struct {
+		Version int `json:"version"`
+	}
+	err := json.Unmarshal(body, &versioned)
+	if err != nil {
+		return nil, err
+	}
+
+	switch versioned.Version {
+	case 0, 1:
+		e := &email{}
+		err = json.Unmarshal(body, e)
+		if err != nil {
+			return nil, err
+		}
+		e.Version = payloadVersion
+		return e, nil
+	default:
+		return nil, errInvalidEmail{fmt.Errorf("payload version %d is not supported, the latest is %d", versioned.Version, payloadVersion)}
+	}
+}
+
+// addresses is a recipient list given either as a comma-separated string
+// or as an array of strings.
+type addresses string
+
+func (a *addresses) UnmarshalJSON(data []byte) error {
+	var list []string
+	if err := json.Unmarshal(data, &list); err == nil {
+		*a = addresses(strings.Join(list, ","))
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.New("recipients must be a string or an array of strings")
+	}
+	*a = addresses(s)
+	return nil
+}
+
+func (e *email) UnmarshalJSON(data []byte) error {
+	type plainEmail email
+	payload := struct {
+		*plainEmail
+		To  addresses `json:"to"`
+		Cc  addresses `json:"cc"`
+		Bcc addresses `json:"bcc"`
+	}{plainEmail: (*plainEmail)(e)}
+	err := json.Unmarshal(data, &payload)
+	if err != nil {
+		return err
+	}
+	e.To = string(payload.To)
+	e.Cc = string(payload.Cc)
+	e.Bcc = string(payload.Bcc)
+	return nil
+}
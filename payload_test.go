@@ -0,0 +1,88 @@
+package main
+
+import (
+	"github.com/streadway/amqp"
+	"testing"
+)
+
+func TestDecodeEmail(t *testing.T) {
+	testCases := []struct {
+		body  string
+		valid bool
+	}{
+		{`{"to":"to@test.com","subject":"Wow"}`, true},
+		{`{"version":1,"to":"to@test.com","subject":"Wow"}`, true},
+		{`{"version":2,"to":"to@test.com","subject":"Wow"}`, false},
+		{`not json`, false},
+	}
+
+	for _, testCase := range testCases {
+		decoded, err := decodeEmail([]byte(testCase.body))
+		if testCase.valid {
+			if err != nil {
+				t.Fatalf("%s must be decoded, got %s", testCase.body, err)
+			}
+			if decoded.To != "to@test.com" || decoded.Version != 1 {
+				t.Fatalf("%s decoded as %#v", testCase.body, decoded)
+			}
+		}
+		if !testCase.valid && err == nil {
+			t.Fatalf("%s must be rejected", testCase.body)
+		}
+	}
+}
+
+func TestDecodeEmailUnknownVersionError(t *testing.T) {
+	_, err := decodeEmail([]byte(`{"version":3}`))
+	if err == nil || err.Error() != "payload version 3 is not supported, the latest is 1" {
+		t.Fatal("unexpected error", err)
+	}
+}
+
+func TestUnknownVersionIsDeadLettered(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{amqpDLQ: "mailer.dlq"}, sender)
+	acknowledger := &fakeAcknowledger{}
+	body := []byte(`{"version":3,"to":"to@test.com","subject":"Wow","text_body":"text body"}`)
+
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: body})
+
+	if acknowledger.acked != 1 || acknowledger.requeued != 0 {
+		t.Fatal("unsupported version must not be requeued", acknowledger)
+	}
+	published := m.channel.(*fakeChannel).published
+	if len(published) != 1 || published[0].key != "mailer.dlq" || string(published[0].msg.Body) != string(body) {
+		t.Fatal("unsupported version must be dead-lettered", published)
+	}
+	if len(sender.inputs) != 0 {
+		t.Fatal("email must not be sent", len(sender.inputs))
+	}
+}
+
+func TestDecodeEmailRecipientForms(t *testing.T) {
+	testCases := []string{
+		`{"to":"to1@test.com,to2@test.com","cc":"cc@test.com","bcc":"bcc1@test.com,bcc2@test.com","subject":"Wow"}`,
+		`{"to":["to1@test.com","to2@test.com"],"cc":["cc@test.com"],"bcc":["bcc1@test.com","bcc2@test.com"],"subject":"Wow"}`,
+		`{"to":["to1@test.com","to2@test.com"],"cc":"cc@test.com","bcc":["bcc1@test.com","bcc2@test.com"],"subject":"Wow"}`,
+	}
+
+	for _, body := range testCases {
+		decoded, err := decodeEmail([]byte(body))
+		if err != nil {
+			t.Fatalf("%s must be decoded, got %s", body, err)
+		}
+		if decoded.To != "to1@test.com,to2@test.com" || decoded.Cc != "cc@test.com" || decoded.Bcc != "bcc1@test.com,bcc2@test.com" {
+			t.Fatalf("%s decoded recipients %s / %s / %s", body, decoded.To, decoded.Cc, decoded.Bcc)
+		}
+		if decoded.Subject != "Wow" {
+			t.Fatalf("%s decoded subject %s", body, decoded.Subject)
+		}
+	}
+}
+
+func TestDecodeEmailInvalidRecipients(t *testing.T) {
+	_, err := decodeEmail([]byte(`{"to":42}`))
+	if err == nil {
+		t.Fatal("recipients must be a string or an array")
+	}
+}
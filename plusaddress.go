@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log"
+	"strings"
+)
+
+const (
+	// plusAddressingPreserve treats user+a@x and user+b@x as different recipients.
+	plusAddressingPreserve = "preserve"
+	// plusAddressingStrip treats user+a@x and user+b@x as the same mailbox when
+	// looking for duplicates, the email is sent to the full address of the first one.
+	plusAddressingStrip = "strip"
+)
+
+// mailbox returns the address without the +tag of its local part, lowercased.
+func mailbox(address string) string {
+	address = strings.ToLower(address)
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return address
+	}
+	local := address[:at]
+	if plus := strings.Index(local, "+"); plus >= 0 {
+		local = local[:plus]
+	}
+	return local + address[at:]
+}
+
+// dropSameMailboxes drops the recipients which are the same mailbox as an
+// earlier one apart from the +tags, the kept ones are sent to as they are. The
+// repeated addresses are left to the validation.
+func (e *email) dropSameMailboxes() {
+	seen := map[string]string{}
+	for _, field := range []*string{&e.To, &e.Cc, &e.Bcc} {
+		if len(*field) == 0 {
+			continue
+		}
+		var kept []string
+		for _, recipient := range strings.Split(*field, ",") {
+			key := mailbox(recipient)
+			if other, ok := seen[key]; ok && !strings.EqualFold(other, recipient) {
+				log.Printf(`recipient "%s" is dropped, it is the same mailbox as "%s"`, recipient, other)
+				continue
+			}
+			if _, ok := seen[key]; !ok {
+				seen[key] = recipient
+			}
+			kept = append(kept, recipient)
+		}
+		*field = strings.Join(kept, ",")
+	}
+}
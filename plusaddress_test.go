@@ -0,0 +1,54 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/streadway/amqp"
+	"strings"
+	"testing"
+)
+
+func TestPlusAddressingModes(t *testing.T) {
+	body := []byte(`{"to":"user+a@test.com","cc":"User+b@test.com,other@test.com","bcc":"user@test.com","subject":"Wow","text_body":"text body"}`)
+
+	sender := &fakeSES{}
+	preserving := newTestMailer(&config{plusAddressing: plusAddressingPreserve}, sender)
+	preserving.handle(amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: body})
+	if len(sender.inputs) != 1 || len(sender.inputs[0].Destinations) != 4 {
+		t.Fatal("tagged addresses must be different recipients when preserved", len(sender.inputs))
+	}
+
+	sender = &fakeSES{}
+	acknowledger := &fakeAcknowledger{}
+	stripping := newTestMailer(&config{plusAddressing: plusAddressingStrip}, sender)
+	stripping.handle(amqp.Delivery{Acknowledger: acknowledger, Body: body})
+	if acknowledger.acked != 1 || len(sender.inputs) != 1 {
+		t.Fatal("email with tagged duplicates must be sent", acknowledger, len(sender.inputs))
+	}
+	destinations := strings.Join(aws.StringValueSlice(sender.inputs[0].Destinations), ",")
+	if destinations != "user+a@test.com,other@test.com" {
+		t.Fatal("email must be sent once per mailbox to the full address", destinations)
+	}
+}
+
+func TestDropSameMailboxes(t *testing.T) {
+	e := &email{To: "user+a@test.com,user+b@test.com", Cc: "other@test.com", Bcc: "USER@test.com"}
+
+	e.dropSameMailboxes()
+
+	if e.To != "user+a@test.com" || e.Cc != "other@test.com" || e.Bcc != "" {
+		t.Fatal("later addresses of one mailbox must be dropped", e.To, e.Cc, e.Bcc)
+	}
+}
+
+func TestMailbox(t *testing.T) {
+	for address, expected := range map[string]string{
+		"user+tag@test.com": "user@test.com",
+		"User+a+b@Test.com": "user@test.com",
+		"user@test.com":     "user@test.com",
+		"+only@test.com":    "@test.com",
+	} {
+		if actual := mailbox(address); actual != expected {
+			t.Fatal(address, actual)
+		}
+	}
+}
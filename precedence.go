@@ -0,0 +1,29 @@
+package main
+
+import "strings"
+
+const (
+	precedenceHeader = "Precedence"
+
+	// precedenceBulk and precedenceList ask the receiving systems not to send
+	// auto-replies, e.g. out of office, to the marketing emails.
+	precedenceBulk = "bulk"
+	precedenceList = "list"
+)
+
+// addPrecedence sets the Precedence header of the marketing emails unless the
+// producer set one in the headers of the email.
+func (e *email) addPrecedence(precedence string) {
+	if e.Type != typeMarketing {
+		return
+	}
+	for name := range e.Headers {
+		if strings.EqualFold(name, precedenceHeader) {
+			return
+		}
+	}
+	if e.Headers == nil {
+		e.Headers = map[string]string{}
+	}
+	e.Headers[precedenceHeader] = precedence
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"github.com/streadway/amqp"
+	"log"
+	"net/mail"
+	"os"
+	"testing"
+)
+
+func TestPrecedenceOfBulkEmails(t *testing.T) {
+	log.SetOutput(&bytes.Buffer{})
+	defer log.SetOutput(os.Stderr)
+
+	sender := &fakeSES{}
+	m := newTestMailer(&config{precedence: precedenceBulk}, sender)
+	m.handle(amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: []byte(`{"to":"to@test.com","subject":"Sale","text_body":"text body","type":"marketing"}`)})
+	m.handle(amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: []byte(`{"to":"to@test.com","subject":"Receipt","text_body":"text body","type":"transactional"}`)})
+	m.handle(amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body"}`)})
+
+	var precedences []string
+	for _, input := range sender.inputs {
+		msg, err := mail.ReadMessage(bytes.NewReader(input.RawMessage.Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		precedences = append(precedences, msg.Header.Get(precedenceHeader))
+	}
+	if len(precedences) != 3 || precedences[0] != "bulk" || precedences[1] != "" || precedences[2] != "" {
+		t.Fatal("only the marketing email must have the Precedence header", precedences)
+	}
+}
+
+func TestPrecedenceKeepsProducerHeader(t *testing.T) {
+	e := &email{Type: typeMarketing, Headers: map[string]string{"precedence": "list"}}
+	e.addPrecedence(precedenceBulk)
+	if len(e.Headers) != 1 || e.Headers["precedence"] != "list" {
+		t.Fatal("producer Precedence must be kept", e.Headers)
+	}
+}
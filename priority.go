@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"github.com/streadway/amqp"
+	"time"
+)
+
+const (
+	priorityHigh   = "high"
+	priorityNormal = "normal"
+	priorityLow    = "low"
+
+	// retryCountHeader counts the retries of a message moved to the retry queue.
+	retryCountHeader = "x-retry-count"
+	// deliveryCountHeader is set by the quorum queues on the redelivered messages.
+	deliveryCountHeader = "x-delivery-count"
+)
+
+// priorityBackoffScales scale the retry delay, urgent emails are retried sooner.
+var priorityBackoffScales = map[string]float64{
+	priorityHigh:   0.25,
+	priorityNormal: 1,
+	priorityLow:    4,
+}
+
+// priorityRetryScales scale the retries of MAX_RETRIES, urgent emails are retried more often.
+var priorityRetryScales = map[string]float64{
+	priorityHigh:   2,
+	priorityNormal: 1,
+	priorityLow:    0.5,
+}
+
+func (e *email) validatePriority() error {
+	if len(e.Priority) > 0 && priorityBackoffScales[e.Priority] == 0 {
+		return errInvalidEmail{fmt.Errorf(`"%s" priority is not supported`, e.Priority)}
+	}
+	return nil
+}
+
+func (e *email) priority() string {
+	if _, ok := priorityBackoffScales[e.Priority]; ok {
+		return e.Priority
+	}
+	return priorityNormal
+}
+
+// retryDelay scales the base retry delay by the priority of the email.
+func (e *email) retryDelay(base time.Duration) time.Duration {
+	return time.Duration(float64(base) * priorityBackoffScales[e.priority()])
+}
+
+// maxRetries scales the base retries by the priority of the email, at least
+// one retry is left. The retries are not limited when the base is 0.
+func (e *email) maxRetries(base int) int {
+	if base == 0 {
+		return 0
+	}
+	retries := int(float64(base) * priorityRetryScales[e.priority()])
+	if retries < 1 {
+		return 1
+	}
+	return retries
+}
+
+// retryCount returns how often the message was retried, as counted by the
+// retry queue or, for the requeued messages, by a quorum queue. It is 0 when
+// the broker doesn't count the retries.
+func retryCount(message amqp.Delivery) int {
+	for _, header := range []string{retryCountHeader, deliveryCountHeader} {
+		switch count := message.Headers[header].(type) {
+		case int64:
+			return int(count)
+		case int32:
+			return int(count)
+		case int:
+			return count
+		}
+	}
+	return 0
+}
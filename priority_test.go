@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"github.com/streadway/amqp"
+	"testing"
+	"time"
+)
+
+func TestHighPriorityRetriesSooner(t *testing.T) {
+	high := &email{Priority: priorityHigh}
+	low := &email{Priority: priorityLow}
+	normal := &email{}
+
+	if delay := high.retryDelay(time.Minute); delay != 15*time.Second {
+		t.Fatal("high priority delay", delay)
+	}
+	if delay := normal.retryDelay(time.Minute); delay != time.Minute {
+		t.Fatal("normal priority delay", delay)
+	}
+	if delay := low.retryDelay(time.Minute); delay != 4*time.Minute {
+		t.Fatal("low priority delay", delay)
+	}
+	if high.maxRetries(5) != 10 || normal.maxRetries(5) != 5 || low.maxRetries(5) != 2 || low.maxRetries(1) != 1 {
+		t.Fatal("retries", high.maxRetries(5), normal.maxRetries(5), low.maxRetries(5), low.maxRetries(1))
+	}
+	if high.maxRetries(0) != 0 {
+		t.Fatal("retries must stay unlimited")
+	}
+}
+
+func TestRetryLaterWaitsPriorityScaledDelay(t *testing.T) {
+	m := newTestMailer(&config{}, &fakeSES{})
+	m.retryDelay = 400 * time.Millisecond
+
+	start := time.Now()
+	m.retryLater(amqp.Delivery{Acknowledger: &fakeAcknowledger{}}, &email{Priority: priorityHigh}, errors.New("temporary failure"))
+	if elapsed := time.Since(start); elapsed >= 400*time.Millisecond {
+		t.Fatal("high priority email must be retried sooner", elapsed)
+	}
+}
+
+func TestExhaustedRetriesAreDeadLettered(t *testing.T) {
+	m := newTestMailer(&config{amqpQueue: "aws.ses.mailer", amqpRetryDelay: time.Minute, amqpDLQ: "aws.ses.mailer.dlq", maxRetries: 2}, &fakeSES{})
+	channel := m.channel.(*fakeChannel)
+
+	acknowledger := &fakeAcknowledger{}
+	m.retryLater(amqp.Delivery{Acknowledger: acknowledger, Headers: amqp.Table{retryCountHeader: int64(2)}}, &email{Priority: priorityHigh}, errors.New("temporary failure"))
+	if len(channel.published) != 1 || channel.published[0].key != "aws.ses.mailer.retry" || channel.published[0].msg.Headers[retryCountHeader] != int64(3) {
+		t.Fatal("high priority email must be retried again", channel.published)
+	}
+
+	acknowledger = &fakeAcknowledger{}
+	m.retryLater(amqp.Delivery{Acknowledger: acknowledger, Headers: amqp.Table{retryCountHeader: int64(2)}}, &email{}, errors.New("temporary failure"))
+	if len(channel.published) != 2 || channel.published[1].key != "aws.ses.mailer.dlq" || acknowledger.acked != 1 {
+		t.Fatal("normal priority email must be dead-lettered", channel.published)
+	}
+}
+
+func TestUnknownPriorityIsInvalid(t *testing.T) {
+	e := &email{Priority: "urgent"}
+	var invalidErr errInvalidEmail
+	if err := e.validatePriority(); !errors.As(err, &invalidErr) {
+		t.Fatal("unknown priority must reject the email", err)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"log"
+	"sync"
+	"time"
+)
+
+// quotaProvider is the part of the SES client reporting the sending quota.
+type quotaProvider interface {
+	GetSendQuota(input *ses.GetSendQuotaInput) (*ses.GetSendQuotaOutput, error)
+}
+
+// quotaWatcher caches the remaining 24 hour sending quota of the SES account,
+// it is refreshed on the interval and counted down by the recipients of the
+// sends in between. With pause set, sending waits while the recipients would
+// leave less than reserve sends. A negative maximum means the quota is unlimited.
+type quotaWatcher struct {
+	provider quotaProvider
+	interval time.Duration
+	reserve  float64
+	pause    bool
+	now      func() time.Time
+
+	mu        sync.Mutex
+	remaining float64
+	unlimited bool
+	refreshed time.Time
+}
+
+func newQuotaWatcher(provider quotaProvider, interval time.Duration, reserve int, pause bool) *quotaWatcher {
+	return &quotaWatcher{provider: provider, interval: interval, reserve: float64(reserve), pause: pause, now: time.Now}
+}
+
+// refresh asks SES for the quota once the cached one is older than the interval.
+func (w *quotaWatcher) refresh() error {
+	if !w.refreshed.IsZero() && w.now().Sub(w.refreshed) < w.interval {
+		return nil
+	}
+	quota, err := w.provider.GetSendQuota(&ses.GetSendQuotaInput{})
+	if err != nil {
+		return err
+	}
+	w.unlimited = aws.Float64Value(quota.Max24HourSend) < 0
+	w.remaining = aws.Float64Value(quota.Max24HourSend) - aws.Float64Value(quota.SentLast24Hours)
+	w.refreshed = w.now()
+	return nil
+}
+
+// reserveSend returns how long to wait before sending to the destinations, it
+// is 0 once they are counted against the quota. The quota is not checked when
+// the watcher is nil, SES can't be asked or the quota is unlimited.
+func (w *quotaWatcher) reserveSend(destinations int) time.Duration {
+	if w == nil {
+		return 0
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.refresh(); err != nil {
+		log.Println("send quota could not be checked", err)
+		return 0
+	}
+	if w.unlimited {
+		return 0
+	}
+	if w.pause && w.remaining-float64(destinations) < w.reserve {
+		return w.interval
+	}
+	w.remaining -= float64(destinations)
+	return 0
+}
+
+// remainingQuota is the number of sends left in the 24 hour quota, it is not
+// known when the quota is unlimited.
+func (w *quotaWatcher) remainingQuota() (float64, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.remaining, !w.unlimited
+}
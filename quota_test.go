@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"testing"
+	"time"
+)
+
+type fakeQuotaProvider struct {
+	max, sent float64
+	err       error
+	calls     int
+}
+
+func (p *fakeQuotaProvider) GetSendQuota(input *ses.GetSendQuotaInput) (*ses.GetSendQuotaOutput, error) {
+	p.calls++
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &ses.GetSendQuotaOutput{Max24HourSend: aws.Float64(p.max), SentLast24Hours: aws.Float64(p.sent)}, nil
+}
+
+func TestQuotaWatcherPausesNearTheLimit(t *testing.T) {
+	provider := &fakeQuotaProvider{max: 1000, sent: 997}
+	now := time.Date(2020, 1, 17, 10, 0, 0, 0, time.UTC)
+	w := newQuotaWatcher(provider, time.Minute, 1, true)
+	w.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if wait := w.reserveSend(1); wait != 0 {
+			t.Fatal("sending must go on while the quota is above the reserve", i, wait)
+		}
+	}
+	if wait := w.reserveSend(1); wait != time.Minute {
+		t.Fatal("sending must pause at the reserve", wait)
+	}
+	if remaining, ok := w.remainingQuota(); !ok || remaining != 1 {
+		t.Fatal("remaining quota", remaining)
+	}
+	if provider.calls != 1 {
+		t.Fatal("quota must be cached until the interval passes", provider.calls)
+	}
+
+	provider.sent = 0
+	now = now.Add(time.Minute)
+	if wait := w.reserveSend(1); wait != 0 || provider.calls != 2 {
+		t.Fatal("sending must resume once the refreshed quota is enough", wait, provider.calls)
+	}
+}
+
+func TestQuotaWatcherWithoutPause(t *testing.T) {
+	w := newQuotaWatcher(&fakeQuotaProvider{max: 10, sent: 10}, time.Minute, 0, false)
+
+	if wait := w.reserveSend(1); wait != 0 {
+		t.Fatal("sending must not pause unless asked", wait)
+	}
+}
+
+func TestQuotaWatcherFailsOpen(t *testing.T) {
+	w := newQuotaWatcher(&fakeQuotaProvider{err: errors.New("access denied")}, time.Minute, 0, true)
+
+	if wait := w.reserveSend(1); wait != 0 {
+		t.Fatal("sending must go on when the quota can't be checked", wait)
+	}
+	var disabled *quotaWatcher
+	if wait := disabled.reserveSend(1); wait != 0 {
+		t.Fatal("disabled watcher must not wait", wait)
+	}
+}
+
+func TestQuotaWatcherCountsDestinations(t *testing.T) {
+	w := newQuotaWatcher(&fakeQuotaProvider{max: 10, sent: 0}, time.Minute, 2, true)
+
+	if wait := w.reserveSend(5); wait != 0 {
+		t.Fatal("sending must go on while the quota is above the reserve", wait)
+	}
+	if remaining, _ := w.remainingQuota(); remaining != 5 {
+		t.Fatal("every destination must be counted", remaining)
+	}
+	if wait := w.reserveSend(4); wait != time.Minute {
+		t.Fatal("sending must pause when the destinations would go below the reserve", wait)
+	}
+	if wait := w.reserveSend(3); wait != 0 {
+		t.Fatal("destinations down to the reserve must be sent", wait)
+	}
+}
+
+func TestQuotaWatcherUnlimitedQuota(t *testing.T) {
+	w := newQuotaWatcher(&fakeQuotaProvider{max: -1, sent: 5000}, time.Minute, 10, true)
+
+	for i := 0; i < 3; i++ {
+		if wait := w.reserveSend(50); wait != 0 {
+			t.Fatal("unlimited quota must not pause sending", wait)
+		}
+	}
+	if _, ok := w.remainingQuota(); ok {
+		t.Fatal("unlimited quota has no remaining sends to report")
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"net/mail"
+	"strings"
+)
+
+// validateRawMIME checks the raw MIME message is the only content of the email
+// and takes the recipients from its To, Cc and Bcc headers. The Bcc header is
+// removed, the other recipients must not see it.
+func (e *email) validateRawMIME() error {
+	if len(e.To) > 0 || len(e.Cc) > 0 || len(e.Bcc) > 0 || len(e.ReplyTo) > 0 || len(e.Subject) > 0 ||
+		len(e.HTMLBody) > 0 || len(e.TextBody) > 0 || len(e.Attaches) > 0 || len(e.Headers) > 0 || len(e.Language) > 0 ||
+		len(e.BodyContentType) > 0 || len(e.MarkdownBody) > 0 {
+		return errors.New("raw_mime must not be combined with other email fields")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(e.RawMIME)
+	if err != nil {
+		return errors.New("raw_mime must be base64 encoded")
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("raw_mime is not valid MIME message: %v", err)
+	}
+
+	recipients := map[string][]string{}
+	for _, field := range []string{"To", "Cc", "Bcc"} {
+		addresses, err := msg.Header.AddressList(field)
+		if err == mail.ErrHeaderNotPresent {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("raw_mime %s header is not valid: %v", field, err)
+		}
+		for _, address := range addresses {
+			recipients[field] = append(recipients[field], address.Address)
+		}
+	}
+	if len(recipients["To"])+len(recipients["Cc"])+len(recipients["Bcc"]) == 0 {
+		return errors.New("raw_mime must have at least one recipient")
+	}
+
+	e.To = strings.Join(recipients["To"], ",")
+	e.Cc = strings.Join(recipients["Cc"], ",")
+	e.Bcc = strings.Join(recipients["Bcc"], ",")
+	e.Subject = msg.Header.Get("Subject")
+	e.rawMessage = stripHeader(data, "Bcc")
+	return nil
+}
+
+// stripHeader removes the header field with its folded lines from the header
+// section of the raw message, the rest is kept byte for byte.
+func stripHeader(data []byte, name string) []byte {
+	var out bytes.Buffer
+	stripping := false
+	rest := data
+	for len(rest) > 0 {
+		end := bytes.IndexByte(rest, '\n') + 1
+		if end == 0 {
+			end = len(rest)
+		}
+		line := rest[:end]
+		rest = rest[end:]
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			// the blank line ends the header section
+			out.Write(line)
+			out.Write(rest)
+			break
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			colon := bytes.IndexByte(line, ':')
+			stripping = colon > 0 && strings.EqualFold(strings.TrimSpace(string(line[:colon])), name)
+		}
+		if !stripping {
+			out.Write(line)
+		}
+	}
+	return out.Bytes()
+}
+
+// createRawEmail sends the raw MIME message as it is, but for the Bcc header, to
+// the recipients found in its headers.
+func createRawEmail(e *email) *ses.SendRawEmailInput {
+	var destinations []string
+	for _, recipient := range e.recipients() {
+		if len(recipient) > 0 {
+			destinations = append(destinations, recipient)
+		}
+	}
+	return &ses.SendRawEmailInput{
+		Destinations: aws.StringSlice(destinations),
+		RawMessage:   &ses.RawMessage{Data: e.rawMessage},
+	}
+}
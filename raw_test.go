@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/streadway/amqp"
+	"strings"
+	"testing"
+)
+
+const rawMIMEMessage = "From: info@someone.com\r\n" +
+	"To: First <first@test.com>, second@test.com\r\n" +
+	"Cc: cc@test.com\r\n" +
+	"Subject: Raw message\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: text/plain; charset=UTF-8\r\n" +
+	"X-Custom: kept\r\n" +
+	"\r\n" +
+	"raw body\r\n"
+
+func TestRawMIMEIsSentVerbatim(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{}, sender)
+	acknowledger := &fakeAcknowledger{}
+	body, _ := json.Marshal(map[string]string{"raw_mime": base64.StdEncoding.EncodeToString([]byte(rawMIMEMessage))})
+
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: body})
+
+	if acknowledger.acked != 1 {
+		t.Fatal("message must be acked", acknowledger)
+	}
+	if len(sender.inputs) != 1 {
+		t.Fatal("SES calls", len(sender.inputs))
+	}
+	if !bytes.Equal(sender.inputs[0].RawMessage.Data, []byte(rawMIMEMessage)) {
+		t.Fatal("raw message must be sent verbatim", string(sender.inputs[0].RawMessage.Data))
+	}
+	destinations := strings.Join(aws.StringValueSlice(sender.inputs[0].Destinations), ",")
+	if destinations != "first@test.com,second@test.com,cc@test.com" {
+		t.Fatal("destinations", destinations)
+	}
+}
+
+func TestRawMIMEBccIsNotSent(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{}, sender)
+	acknowledger := &fakeAcknowledger{}
+	raw := strings.Replace(rawMIMEMessage, "Cc: cc@test.com\r\n", "Cc: cc@test.com\r\nBcc: hidden@test.com,\r\n other@test.com\r\n", 1)
+	body, _ := json.Marshal(map[string]string{"raw_mime": base64.StdEncoding.EncodeToString([]byte(raw))})
+
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: body})
+
+	if len(sender.inputs) != 1 {
+		t.Fatal("SES calls", len(sender.inputs))
+	}
+	if !bytes.Equal(sender.inputs[0].RawMessage.Data, []byte(rawMIMEMessage)) {
+		t.Fatal("Bcc header must be removed from the raw message", string(sender.inputs[0].RawMessage.Data))
+	}
+	destinations := strings.Join(aws.StringValueSlice(sender.inputs[0].Destinations), ",")
+	if destinations != "first@test.com,second@test.com,cc@test.com,hidden@test.com,other@test.com" {
+		t.Fatal("Bcc recipients must still be sent to", destinations)
+	}
+}
+
+func TestInvalidRawMIMEIsRejected(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{}, sender)
+	acknowledger := &fakeAcknowledger{}
+
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: []byte(`{"raw_mime":"not base64!"}`)})
+
+	if len(sender.inputs) != 0 {
+		t.Fatal("invalid raw_mime must not be sent")
+	}
+	if acknowledger.nacked != 1 || acknowledger.requeued != 0 {
+		t.Fatal("email must be rejected without requeue", acknowledger)
+	}
+}
+
+func TestValidateRawMIME(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString([]byte(rawMIMEMessage))
+	testCases := []struct {
+		email              email
+		validationErrorMsg string
+	}{
+		{
+			email{RawMIME: encoded, Subject: "Wow"},
+			"raw_mime must not be combined with other email fields",
+		},
+		{
+			email{RawMIME: "not base64!"},
+			"raw_mime must be base64 encoded",
+		},
+		{
+			email{RawMIME: base64.StdEncoding.EncodeToString([]byte("Subject: no recipients\r\n\r\nbody"))},
+			"raw_mime must have at least one recipient",
+		},
+	}
+
+	for _, testCase := range testCases {
+		err := testCase.email.validateRawMIME()
+		if err == nil || err.Error() != testCase.validationErrorMsg {
+			t.Fatalf("%#v must emit validation error %s, got %v", testCase.email, testCase.validationErrorMsg, err)
+		}
+	}
+}
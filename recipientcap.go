@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	recipientCapWindow = time.Hour
+	recipientCapBucket = time.Minute
+)
+
+// recipientCap limits the distinct recipients emailed in the last hour, a
+// safeguard against a runaway producer. The recipients are kept in minute
+// buckets which expire together.
+type recipientCap struct {
+	limit int
+	now   func() time.Time
+
+	mu      sync.Mutex
+	buckets []recipientBucket
+	// counts is the number of buckets each recipient is in.
+	counts map[string]int
+}
+
+type recipientBucket struct {
+	start      time.Time
+	recipients map[string]bool
+}
+
+// newRecipientCap returns nil without the limit, so the recipients are not tracked.
+func newRecipientCap(limit int) *recipientCap {
+	if limit <= 0 {
+		return nil
+	}
+	return &recipientCap{limit: limit, now: time.Now, counts: map[string]int{}}
+}
+
+// reserve records the recipients and returns 0 when they fit the cap, otherwise
+// how long to wait before the oldest recipients expire. It fails when the email
+// alone has more new recipients than the cap.
+func (c *recipientCap) reserve(recipients []string) (time.Duration, error) {
+	if c == nil {
+		return 0, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	c.expire(now)
+
+	newRecipients := map[string]bool{}
+	for _, recipient := range recipients {
+		recipient = strings.ToLower(recipient)
+		if c.counts[recipient] == 0 {
+			newRecipients[recipient] = true
+		}
+	}
+	if len(newRecipients) > c.limit {
+		return 0, fmt.Errorf("email has %d new recipients, more than %d allowed per hour", len(newRecipients), c.limit)
+	}
+	if len(c.counts)+len(newRecipients) > c.limit {
+		return c.buckets[0].start.Add(recipientCapWindow).Sub(now), nil
+	}
+
+	start := now.Truncate(recipientCapBucket)
+	if len(c.buckets) == 0 || !c.buckets[len(c.buckets)-1].start.Equal(start) {
+		c.buckets = append(c.buckets, recipientBucket{start: start, recipients: map[string]bool{}})
+	}
+	bucket := c.buckets[len(c.buckets)-1]
+	for _, recipient := range recipients {
+		recipient = strings.ToLower(recipient)
+		if !bucket.recipients[recipient] {
+			bucket.recipients[recipient] = true
+			c.counts[recipient]++
+		}
+	}
+	return 0, nil
+}
+
+// expire drops the buckets which left the window.
+func (c *recipientCap) expire(now time.Time) {
+	for len(c.buckets) > 0 && !c.buckets[0].start.Add(recipientCapWindow).After(now) {
+		for recipient := range c.buckets[0].recipients {
+			if c.counts[recipient]--; c.counts[recipient] == 0 {
+				delete(c.counts, recipient)
+			}
+		}
+		c.buckets = c.buckets[1:]
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRecipientCapTriggers(t *testing.T) {
+	now := time.Date(2020, 1, 17, 10, 0, 30, 0, time.UTC)
+	c := newRecipientCap(100)
+	c.now = func() time.Time { return now }
+
+	for i := 0; i < 100; i++ {
+		now = now.Add(10 * time.Second)
+		if wait, err := c.reserve([]string{fmt.Sprintf("recipient%d@test.com", i)}); wait != 0 || err != nil {
+			t.Fatal("recipients below the cap must be allowed", i, wait, err)
+		}
+	}
+	if wait, err := c.reserve([]string{"RECIPIENT5@test.com"}); wait != 0 || err != nil {
+		t.Fatal("known recipient must not count again", wait, err)
+	}
+
+	wait, err := c.reserve([]string{"runaway@test.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first bucket started at 10:00 expires at 11:00
+	if wait != now.Truncate(time.Hour).Add(time.Hour).Sub(now) || wait <= 0 {
+		t.Fatal("new recipient over the cap must wait for the oldest bucket to expire", wait)
+	}
+
+	now = time.Date(2020, 1, 17, 11, 0, 0, 0, time.UTC)
+	if wait, err := c.reserve([]string{"runaway@test.com"}); wait != 0 || err != nil {
+		t.Fatal("recipient must be allowed once the oldest ones expire", wait, err)
+	}
+}
+
+func TestRecipientCapRejectsTooManyRecipientsInOneEmail(t *testing.T) {
+	c := newRecipientCap(2)
+
+	_, err := c.reserve([]string{"a@test.com", "b@test.com", "c@test.com"})
+
+	if err == nil {
+		t.Fatal("email over the cap by itself must be rejected")
+	}
+	if wait, err := newRecipientCap(0).reserve([]string{"a@test.com"}); wait != 0 || err != nil {
+		t.Fatal("disabled cap must allow everything", wait, err)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import "fmt"
+
+// defaultMaxRecipientLength caps the To, Cc and Bcc strings when
+// MAX_RECIPIENT_LENGTH is not set, about a thousand long addresses.
+const defaultMaxRecipientLength = 64 * 1024
+
+// checkRecipientLength fails fast on the pathological recipient strings before
+// they are split and validated address by address, there is no limit when
+// maxLength is 0.
+func (e *email) checkRecipientLength(maxLength int) error {
+	if maxLength == 0 {
+		return nil
+	}
+	for _, field := range []struct {
+		name  string
+		value string
+	}{
+		{"to", e.To},
+		{"cc", e.Cc},
+		{"bcc", e.Bcc},
+	} {
+		if len(field.value) > maxLength {
+			return fmt.Errorf("%s of %d bytes exceeds the limit of %d bytes", field.name, len(field.value), maxLength)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"github.com/streadway/amqp"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestOversizedRecipientStringIsRejectedEarly(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	sender := &fakeSES{}
+	m := newTestMailer(&config{maxRecipientLength: 1024}, sender)
+	acknowledger := &fakeAcknowledger{}
+	to := strings.TrimSuffix(strings.Repeat("user@test.com,", 100), ",")
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: []byte(`{"to":"` + to + `","subject":"Wow","text_body":"text body"}`)})
+
+	if acknowledger.nacked != 1 || acknowledger.requeued != 0 || len(sender.inputs) != 0 {
+		t.Fatal("email must be rejected", acknowledger, len(sender.inputs))
+	}
+	if !strings.Contains(logged.String(), "to of 1399 bytes exceeds the limit of 1024 bytes") {
+		t.Fatal("rejection must be logged", logged.String())
+	}
+	if strings.Contains(logged.String(), "new email message") {
+		t.Fatal("email must be rejected before it is processed", logged.String())
+	}
+}
+
+func TestCheckRecipientLength(t *testing.T) {
+	e := &email{To: "to@test.com", Cc: "cc@test.com", Bcc: strings.Repeat("b", 100)}
+	if err := e.checkRecipientLength(100); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.checkRecipientLength(99); err == nil || !strings.HasPrefix(err.Error(), "bcc") {
+		t.Fatal("long bcc must be rejected", err)
+	}
+	if err := e.checkRecipientLength(0); err != nil {
+		t.Fatal("0 must disable the limit", err)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log"
+	"net/url"
+	"strings"
+)
+
+const redactedPassword = "xxxxx"
+
+// redactURL masks the password of the URL, so it can be logged.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		// the unparsable URL could still hold the credentials
+		return "<invalid url>"
+	}
+	if u.User == nil {
+		return rawURL
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redactedPassword)
+	}
+	return u.String()
+}
+
+// redactPassword masks the password of the URL wherever the message repeats it.
+func redactPassword(rawURL, message string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return message
+	}
+	if password, ok := u.User.Password(); ok && len(password) > 0 {
+		message = strings.Replace(message, password, redactedPassword, -1)
+		if escaped := url.PathEscape(password); escaped != password {
+			message = strings.Replace(message, escaped, redactedPassword, -1)
+		}
+	}
+	return message
+}
+
+// logDialError logs the failed AMQP dial without the credentials.
+func logDialError(amqpURL string, err error) {
+	log.Println("dial err", redactURL(amqpURL), redactPassword(amqpURL, err.Error()))
+}
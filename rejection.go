@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/streadway/amqp"
+	"log"
+)
+
+const (
+	// rejectionAck drops the emails permanently rejected by SES.
+	rejectionAck = "ack"
+	// rejectionDLQ moves the emails permanently rejected by SES to the dead letter queue.
+	rejectionDLQ = "dlq"
+)
+
+// isPermanentRejection reports whether SES refused the email for a reason which
+// retrying doesn't fix.
+func isPermanentRejection(err error) bool {
+	var awsErr awserr.Error
+	if !errors.As(err, &awsErr) {
+		return false
+	}
+	switch awsErr.Code() {
+	case ses.ErrCodeMessageRejected, ses.ErrCodeMailFromDomainNotVerifiedException:
+		return true
+	}
+	return false
+}
+
+func declareDeadLetterQueue(amqpChannel amqpChannel, cfg *config) error {
+	return declareQueue(amqpChannel, cfg, cfg.amqpDLQ)
+}
+
+// republishing copies the delivered message with its body, headers and
+// properties unchanged, so it can be inspected or replayed as it was sent. The
+// copy is persistent.
+func republishing(message amqp.Delivery) amqp.Publishing {
+	headers := amqp.Table{}
+	for k, v := range message.Headers {
+		headers[k] = v
+	}
+	return amqp.Publishing{
+		Headers:         headers,
+		ContentType:     message.ContentType,
+		ContentEncoding: message.ContentEncoding,
+		DeliveryMode:    amqp.Persistent,
+		Priority:        message.Priority,
+		CorrelationId:   message.CorrelationId,
+		ReplyTo:         message.ReplyTo,
+		MessageId:       message.MessageId,
+		Timestamp:       message.Timestamp,
+		Type:            message.Type,
+		UserId:          message.UserId,
+		AppId:           message.AppId,
+		Body:            message.Body,
+	}
+}
+
+// publishDeadLetter copies the message to the dead letter queue along with the
+// reason it was rejected, the body and the properties are left unchanged.
+func publishDeadLetter(amqpChannel amqpChannel, cfg *config, message amqp.Delivery, reason error) error {
+	publishing := republishing(message)
+	publishing.Headers["x-rejection-reason"] = reason.Error()
+	return amqpChannel.Publish("", cfg.amqpDLQ, false, false, publishing)
+}
+
+// deadLetter moves the message to the dead letter queue, it is dropped when the
+// queue is not configured. The message is requeued rather than lost when it
+// could not be published to the queue and the consumer backs off.
+func (m *mailer) deadLetter(message amqp.Delivery, reason error) {
+	log.Println("message is dead-lettered:", reason)
+	if m.cfg.amqpDLQ == "" {
+		message.Nack(false, false)
+		m.stats.dropped()
+		return
+	}
+	if err := publishDeadLetter(m.channel, m.cfg, message, reason); err != nil {
+		log.Println("dead letter publishing err, the message is requeued", err)
+		message.Nack(false, true)
+		m.stats.retried()
+		m.backOff(m.retryDelay)
+		return
+	}
+	message.Ack(false)
+	m.stats.dropped()
+}
+
+// rejectPermanently takes the message out of the queue following the rejection policy.
+func (m *mailer) rejectPermanently(message amqp.Delivery, err error) {
+	log.Println("email is permanently rejected, it is not retried:", err)
+	if m.cfg.rejectionPolicy == rejectionDLQ {
+		m.deadLetter(message, err)
+		return
+	}
+	message.Ack(false)
+	m.stats.dropped()
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/streadway/amqp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func handleRejectedEmail(cfg *config) (*mailer, *fakeSES, *fakeAcknowledger) {
+	sender := &fakeSES{err: awserr.New(ses.ErrCodeMessageRejected, "Email address is not verified.", nil)}
+	m := newTestMailer(cfg, sender)
+	m.retryDelay = time.Hour
+	acknowledger := &fakeAcknowledger{}
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body"}`)})
+	return m, sender, acknowledger
+}
+
+func TestPermanentRejectionIsAcked(t *testing.T) {
+	_, sender, acknowledger := handleRejectedEmail(&config{rejectionPolicy: rejectionAck})
+
+	if len(sender.inputs) != 1 {
+		t.Fatal("SES calls", len(sender.inputs))
+	}
+	if acknowledger.acked != 1 || acknowledger.nacked != 0 {
+		t.Fatal("rejected email must be acked without retry", acknowledger)
+	}
+}
+
+func TestPermanentRejectionIsMovedToDLQ(t *testing.T) {
+	m, _, acknowledger := handleRejectedEmail(&config{rejectionPolicy: rejectionDLQ, amqpDLQ: "mailer.dlq"})
+
+	if acknowledger.acked != 1 || acknowledger.nacked != 0 {
+		t.Fatal("rejected email must be acked without retry", acknowledger)
+	}
+	published := m.channel.(*fakeChannel).published
+	if len(published) != 1 || published[0].exchange != "" || published[0].key != "mailer.dlq" {
+		t.Fatal("rejected email must be published to the dead letter queue", published)
+	}
+	if reason, _ := published[0].msg.Headers["x-rejection-reason"].(string); reason != "aws sending email error: MessageRejected: Email address is not verified." {
+		t.Fatal("rejection reason", reason)
+	}
+}
+
+func TestIsPermanentRejection(t *testing.T) {
+	if !isPermanentRejection(errAWSSendingEmail{err: awserr.New(ses.ErrCodeMessageRejected, "rejected", nil)}) {
+		t.Fatal("MessageRejected must be permanent")
+	}
+	if isPermanentRejection(errAWSSendingEmail{err: awserr.New("Throttling", "slow down", nil)}) {
+		t.Fatal("throttling must be retried")
+	}
+}
+
+func TestOversizedPayloadIsDeadLetteredBeforeDecode(t *testing.T) {
+	sender := &fakeSES{}
+	m := newTestMailer(&config{maxPayloadBytes: 64, amqpDLQ: "mailer.dlq"}, sender)
+	acknowledger := &fakeAcknowledger{}
+	// the body is not valid JSON, decoding it would stop the process
+	body := []byte(`{"to":"to@test.com","subject":"Wow","text_body":"` + strings.Repeat("a", 100))
+
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: body})
+
+	if acknowledger.acked != 1 || acknowledger.nacked != 0 {
+		t.Fatal("oversized payload must be acked once dead-lettered", acknowledger)
+	}
+	published := m.channel.(*fakeChannel).published
+	if len(published) != 1 || published[0].key != "mailer.dlq" || string(published[0].msg.Body) != string(body) {
+		t.Fatal("oversized payload must be published to the dead letter queue", published)
+	}
+	if len(sender.inputs) != 0 {
+		t.Fatal("email must not be sent", len(sender.inputs))
+	}
+}
+
+func TestDeadLetterKeepsOriginalPayload(t *testing.T) {
+	sender := &fakeSES{err: awserr.New(ses.ErrCodeMessageRejected, "Email address is not verified.", nil)}
+	m := newTestMailer(&config{rejectionPolicy: rejectionDLQ, amqpDLQ: "mailer.dlq"}, sender)
+	// whitespace, escapes and field order which a re-serialization would change
+	original := "{ \"subject\" : \"  Wow \\u00e9 \",\n  \"to\": \" to@test.com \", \"text_body\": \"text body\\n\", \"unknown\": [1, 2] }"
+	timestamp := time.Date(2020, 1, 17, 10, 0, 0, 0, time.UTC)
+	delivery := amqp.Delivery{
+		Acknowledger:  &fakeAcknowledger{},
+		Headers:       amqp.Table{"x-trace": "abc"},
+		ContentType:   "application/json",
+		CorrelationId: "order-42",
+		MessageId:     "message-1",
+		Timestamp:     timestamp,
+		AppId:         "shop",
+		Body:          []byte(original),
+	}
+
+	m.handle(delivery)
+
+	published := m.channel.(*fakeChannel).published
+	if len(published) != 1 || published[0].key != "mailer.dlq" {
+		t.Fatal("rejected email must be dead-lettered", published)
+	}
+	msg := published[0].msg
+	if string(msg.Body) != original {
+		t.Fatalf("dead-lettered body %q must equal the original %q", msg.Body, original)
+	}
+	if msg.Headers["x-trace"] != "abc" || len(msg.Headers) != 2 {
+		t.Fatal("original headers must be kept", msg.Headers)
+	}
+	if msg.ContentType != "application/json" || msg.CorrelationId != "order-42" || msg.MessageId != "message-1" || !msg.Timestamp.Equal(timestamp) || msg.AppId != "shop" {
+		t.Fatal("original properties must be kept", msg)
+	}
+	if _, ok := delivery.Headers["x-rejection-reason"]; ok {
+		t.Fatal("delivered headers must not be modified")
+	}
+}
+
+func TestDeadLetterPublishFailureRequeuesMessage(t *testing.T) {
+	m := newTestMailer(&config{amqpDLQ: "mailer.dlq"}, &fakeSES{})
+	m.channel.(*fakeChannel).publishErr = errors.New("channel closed")
+	acknowledger := &fakeAcknowledger{}
+
+	m.deadLetter(amqp.Delivery{Acknowledger: acknowledger, Body: []byte(`{"to":"to@test.com"}`)}, errors.New("rejected"))
+
+	if acknowledger.requeued != 1 || acknowledger.acked != 0 {
+		t.Fatal("message must be requeued rather than lost", acknowledger)
+	}
+	if report := m.stats.report(); report.Dropped != 0 || report.Retried != 1 {
+		t.Fatal("message must not be counted as dropped", report)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"github.com/streadway/amqp"
+	"log"
+	"regexp"
+	"time"
+)
+
+// defaultReplayBatchSize is the number of dead-lettered messages replayed by one run.
+const defaultReplayBatchSize = 100
+
+// replayChannel is the part of *amqp.Channel used to replay the dead letter queue.
+type replayChannel interface {
+	Get(queue string, autoAck bool) (amqp.Delivery, bool, error)
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+type replayOptions struct {
+	// batchSize is the most messages taken from the dead letter queue.
+	batchSize int
+	// dryRun only logs the messages which would be replayed.
+	dryRun bool
+	// filter picks the messages whose body or rejection reason match, all
+	// messages are replayed when it is nil.
+	filter *regexp.Regexp
+}
+
+// replayDeadLetters moves the dead-lettered messages back to the main queue for
+// another attempt, e.g. after a fix, and returns how many were moved. The
+// rejection reason and the retry count are removed and the timestamp is reset,
+// so MAX_MESSAGE_AGE doesn't drop them. The messages which are skipped, or all
+// of them in a dry run, stay in the dead letter queue.
+func replayDeadLetters(ch replayChannel, cfg *config, opts replayOptions) (int, error) {
+	var skipped []amqp.Delivery
+	defer func() {
+		for _, message := range skipped {
+			message.Nack(false, true)
+		}
+	}()
+
+	replayed := 0
+	for i := 0; i < opts.batchSize; i++ {
+		message, ok, err := ch.Get(cfg.amqpDLQ, false)
+		if err != nil {
+			return replayed, err
+		}
+		if !ok {
+			break
+		}
+		reason, _ := message.Headers["x-rejection-reason"].(string)
+		if opts.filter != nil && !opts.filter.Match(message.Body) && !opts.filter.MatchString(reason) {
+			skipped = append(skipped, message)
+			continue
+		}
+		if opts.dryRun {
+			log.Printf("would replay message %s rejected for: %s\n", message.Body, reason)
+			skipped = append(skipped, message)
+			continue
+		}
+
+		publishing := republishing(message)
+		delete(publishing.Headers, "x-rejection-reason")
+		delete(publishing.Headers, retryCountHeader)
+		publishing.Timestamp = time.Now()
+		if err := ch.Publish("", cfg.amqpQueue, false, false, publishing); err != nil {
+			skipped = append(skipped, message)
+			return replayed, err
+		}
+		message.Ack(false)
+		replayed++
+	}
+	return replayed, nil
+}
+
+// replayCommand runs "aws-ses-mailer replay --from-dlq", it replays the dead
+// letter queue of the configured main queue and exits.
+func replayCommand(args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	fromDLQ := flags.Bool("from-dlq", false, "replay the messages of AMQP_DLQ to AMQP_QUEUE")
+	batchSize := flags.Int("batch-size", defaultReplayBatchSize, "most messages replayed")
+	dryRun := flags.Bool("dry-run", false, "only log the messages which would be replayed")
+	filter := flags.String("filter", "", "replay only the messages whose body or rejection reason match the regular expression")
+	flags.Parse(args)
+
+	if !*fromDLQ {
+		log.Fatal("replay err", errors.New("--from-dlq must be given, the dead letter queue is the only source"))
+	}
+	if *batchSize < 1 {
+		log.Fatal("replay err", errors.New("--batch-size must be positive"))
+	}
+	opts := replayOptions{batchSize: *batchSize, dryRun: *dryRun}
+	if *filter != "" {
+		re, err := regexp.Compile(*filter)
+		if err != nil {
+			log.Fatal("replay filter err", err)
+		}
+		opts.filter = re
+	}
+	cfg := loadConfig()
+	if cfg.amqpDLQ == "" {
+		log.Fatal("replay err", errors.New("AMQP_DLQ is not set"))
+	}
+
+	var conn *amqp.Connection
+	_, err := dialFirst(cfg.amqpURLs, func(url string) (err error) {
+		conn, err = amqp.DialConfig(url, amqpDialConfig(cfg))
+		return err
+	})
+	if err != nil {
+		log.Fatal("amqp dial err", err)
+	}
+	defer conn.Close()
+	ch, err := conn.Channel()
+	if err != nil {
+		log.Fatal("channel init err", err)
+	}
+	defer ch.Close()
+
+	replayed, err := replayDeadLetters(ch, cfg, opts)
+	log.Printf("%d messages replayed from %s to %s\n", replayed, cfg.amqpDLQ, cfg.amqpQueue)
+	if err != nil {
+		log.Fatal("replay err", err)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"github.com/streadway/amqp"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// fakeDLQ hands out its messages one by one like basic.get.
+type fakeDLQ struct {
+	fakeChannel
+	messages []amqp.Delivery
+	got      []string
+}
+
+func (q *fakeDLQ) Get(queue string, autoAck bool) (amqp.Delivery, bool, error) {
+	q.got = append(q.got, queue)
+	if len(q.messages) == 0 {
+		return amqp.Delivery{}, false, nil
+	}
+	message := q.messages[0]
+	q.messages = q.messages[1:]
+	return message, true, nil
+}
+
+func deadLetters(reasons ...string) ([]amqp.Delivery, []*fakeAcknowledger) {
+	var messages []amqp.Delivery
+	var acknowledgers []*fakeAcknowledger
+	for _, reason := range reasons {
+		acknowledger := &fakeAcknowledger{}
+		acknowledgers = append(acknowledgers, acknowledger)
+		messages = append(messages, amqp.Delivery{
+			Acknowledger: acknowledger,
+			Headers:      amqp.Table{"x-rejection-reason": reason, retryCountHeader: int64(3), "x-trace": "abc"},
+			Timestamp:    time.Date(2020, 1, 17, 10, 0, 0, 0, time.UTC),
+			Body:         []byte(`{"to":"to@test.com","subject":"` + reason + `"}`),
+		})
+	}
+	return messages, acknowledgers
+}
+
+var replayConfig = &config{amqpQueue: "aws.ses.mailer", amqpDLQ: "aws.ses.mailer.dlq"}
+
+func TestReplayRepublishesDeadLettersToMainQueue(t *testing.T) {
+	messages, acknowledgers := deadLetters("throttled", "rejected")
+	dlq := &fakeDLQ{messages: messages}
+
+	replayed, err := replayDeadLetters(dlq, replayConfig, replayOptions{batchSize: 10})
+
+	if err != nil || replayed != 2 {
+		t.Fatal("replayed", replayed, err)
+	}
+	if len(dlq.got) != 3 || dlq.got[0] != "aws.ses.mailer.dlq" {
+		t.Fatal("dead letter queue must be read until it is empty", dlq.got)
+	}
+	if len(dlq.published) != 2 {
+		t.Fatal("published", dlq.published)
+	}
+	for i, published := range dlq.published {
+		if published.exchange != "" || published.key != "aws.ses.mailer" || string(published.msg.Body) != string(messages[i].Body) {
+			t.Fatal("message must be republished to the main queue", published)
+		}
+		if _, ok := published.msg.Headers["x-rejection-reason"]; ok || published.msg.Headers[retryCountHeader] != nil || published.msg.Headers["x-trace"] != "abc" {
+			t.Fatal("only the rejection reason and the retry count must be removed", published.msg.Headers)
+		}
+		if time.Since(published.msg.Timestamp) > time.Minute {
+			t.Fatal("timestamp must be reset", published.msg.Timestamp)
+		}
+		if acknowledgers[i].acked != 1 {
+			t.Fatal("replayed message must be acked")
+		}
+	}
+}
+
+func TestReplayFilterBatchSizeAndDryRun(t *testing.T) {
+	messages, acknowledgers := deadLetters("throttled", "rejected", "throttled")
+	dlq := &fakeDLQ{messages: messages}
+
+	replayed, err := replayDeadLetters(dlq, replayConfig, replayOptions{batchSize: 2, filter: regexp.MustCompile("throttled")})
+
+	if err != nil || replayed != 1 || len(dlq.published) != 1 {
+		t.Fatal("only the first matching message of the batch must be replayed", replayed, err)
+	}
+	if acknowledgers[0].acked != 1 || acknowledgers[1].requeued != 1 || acknowledgers[2].nacked+acknowledgers[2].acked != 0 {
+		t.Fatal("skipped message must stay in the queue, the rest must not be taken", acknowledgers[0], acknowledgers[1], acknowledgers[2])
+	}
+
+	messages, acknowledgers = deadLetters("throttled")
+	dlq = &fakeDLQ{messages: messages}
+	replayed, err = replayDeadLetters(dlq, replayConfig, replayOptions{batchSize: 10, dryRun: true})
+	if err != nil || replayed != 0 || len(dlq.published) != 0 || acknowledgers[0].requeued != 1 {
+		t.Fatal("dry run must not replay", replayed, err)
+	}
+}
+
+func TestReplayStopsOnGetError(t *testing.T) {
+	dlq := &failingDLQ{}
+	if _, err := replayDeadLetters(dlq, replayConfig, replayOptions{batchSize: 10}); err == nil {
+		t.Fatal("get error must be returned")
+	}
+}
+
+type failingDLQ struct {
+	fakeChannel
+}
+
+func (q *failingDLQ) Get(queue string, autoAck bool) (amqp.Delivery, bool, error) {
+	return amqp.Delivery{}, false, errors.New("channel is closed")
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"github.com/streadway/amqp"
+	"log"
+	"strconv"
+	"time"
+)
+
+// retryQueueSuffix names the queue holding the retried messages after the main one.
+const retryQueueSuffix = ".retry"
+
+func retryQueueName(cfg *config) string {
+	return cfg.amqpQueue + retryQueueSuffix
+}
+
+// declareRetryQueue declares the queue whose messages expire after the retry
+// delay and are dead-lettered back to the main queue through the default
+// exchange, delayed retries without the delayed message plugin. A passively
+// declared queue must have been created with these arguments.
+func declareRetryQueue(amqpChannel amqpChannel, cfg *config) error {
+	if cfg.amqpPassiveDeclare {
+		_, err := amqpChannel.QueueDeclarePassive(retryQueueName(cfg), true, false, false, false, nil)
+		return err
+	}
+	_, err := amqpChannel.QueueDeclare(retryQueueName(cfg), true, false, false, false, amqp.Table{
+		"x-message-ttl":             int64(cfg.amqpRetryDelay / time.Millisecond),
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": cfg.amqpQueue,
+	})
+	return err
+}
+
+// publishRetry copies the message to the retry queue, the broker moves it back
+// to the main queue once the delay expires. The copy counts the retry.
+// The queue TTL caps the delay, so a low priority email waits no longer than
+// AMQP_RETRY_DELAY, and RabbitMQ expires the messages at the head of the queue
+// only, a high priority one may wait for the ones ahead of it.
+func publishRetry(amqpChannel amqpChannel, cfg *config, message amqp.Delivery, delay time.Duration) error {
+	publishing := republishing(message)
+	publishing.Headers[retryCountHeader] = int64(retryCount(message) + 1)
+	publishing.Expiration = strconv.FormatInt(int64(delay/time.Millisecond), 10)
+	return amqpChannel.Publish("", retryQueueName(cfg), false, false, publishing)
+}
+
+// publishDeferred copies the message to the retry queue for the wait of a send
+// limit, the retry is not counted. The queue TTL expires it sooner when the
+// wait is longer, the email is deferred again then.
+func publishDeferred(amqpChannel amqpChannel, cfg *config, message amqp.Delivery, wait time.Duration) error {
+	publishing := republishing(message)
+	publishing.Expiration = strconv.FormatInt(int64(wait/time.Millisecond), 10)
+	return amqpChannel.Publish("", retryQueueName(cfg), false, false, publishing)
+}
+
+// requeueDelayed moves the message to the retry queue for the priority scaled
+// delay instead of requeueing it at once, it reports false when the message
+// could not be moved.
+func (m *mailer) requeueDelayed(message amqp.Delivery, e *email) bool {
+	if err := publishRetry(m.channel, m.cfg, message, e.retryDelay(m.cfg.amqpRetryDelay)); err != nil {
+		log.Println("retry publishing err", err)
+		return false
+	}
+	message.Ack(false)
+	return true
+}
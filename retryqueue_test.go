@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"github.com/streadway/amqp"
+	"testing"
+	"time"
+)
+
+func TestConsumeDeclaresRetryQueueTopology(t *testing.T) {
+	channel := &fakeChannel{}
+	if _, err := consume(channel, &config{amqpQueue: "aws.ses.mailer", amqpRetryDelay: 30 * time.Second}); err != nil {
+		t.Fatal(err)
+	}
+
+	args, ok := channel.queueArgs["aws.ses.mailer.retry"]
+	if !ok {
+		t.Fatal("retry queue must be declared", channel.declared)
+	}
+	if args["x-message-ttl"] != int64(30000) {
+		t.Fatal("ttl", args["x-message-ttl"])
+	}
+	if args["x-dead-letter-exchange"] != "" || args["x-dead-letter-routing-key"] != "aws.ses.mailer" {
+		t.Fatal("expired messages must be dead-lettered to the main queue", args)
+	}
+	if len(channel.consumed) != 1 || channel.consumed[0] != "aws.ses.mailer" {
+		t.Fatal("only the main queue is consumed", channel.consumed)
+	}
+}
+
+func TestConsumeWithoutRetryDelayDeclaresNoRetryQueue(t *testing.T) {
+	channel := &fakeChannel{}
+	if _, err := consume(channel, &config{amqpQueue: "aws.ses.mailer"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(channel.declared) != 1 {
+		t.Fatal("declared queues", channel.declared)
+	}
+}
+
+func TestRetryLaterMovesMessageToRetryQueue(t *testing.T) {
+	m := newTestMailer(&config{amqpQueue: "aws.ses.mailer", amqpRetryDelay: 30 * time.Second}, &fakeSES{})
+	m.retryDelay = time.Hour
+	acknowledger := &fakeAcknowledger{}
+	message := amqp.Delivery{Acknowledger: acknowledger, Headers: amqp.Table{"x-trace": "abc"}, Body: []byte(`{"to":"to@test.com"}`)}
+
+	m.retryLater(message, &email{}, errors.New("temporary failure"))
+
+	published := m.channel.(*fakeChannel).published
+	if len(published) != 1 || published[0].exchange != "" || published[0].key != "aws.ses.mailer.retry" {
+		t.Fatal("message must be published to the retry queue", published)
+	}
+	if string(published[0].msg.Body) != `{"to":"to@test.com"}` || published[0].msg.Headers["x-trace"] != "abc" {
+		t.Fatal("message must be copied", published[0].msg)
+	}
+	if published[0].msg.Expiration != "30000" {
+		t.Fatal("message must expire after the retry delay", published[0].msg.Expiration)
+	}
+	if acknowledger.acked != 1 || acknowledger.nacked != 0 {
+		t.Fatal("message must be acked once moved", acknowledger)
+	}
+	if m.stats.report().Retried != 1 {
+		t.Fatal("retry must be counted")
+	}
+}
+
+func TestRetryQueueExpirationIsScaledByPriority(t *testing.T) {
+	m := newTestMailer(&config{amqpQueue: "aws.ses.mailer", amqpRetryDelay: 30 * time.Second}, &fakeSES{})
+	message := amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: []byte(`{"to":"to@test.com"}`)}
+
+	m.retryLater(message, &email{Priority: priorityHigh}, errors.New("temporary failure"))
+
+	published := m.channel.(*fakeChannel).published
+	if len(published) != 1 || published[0].msg.Expiration != "7500" {
+		t.Fatal("high priority email must expire sooner", published)
+	}
+}
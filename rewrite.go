@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// addressRewrite replaces the recipient addresses matching the pattern, e.g.
+// to move the emails of an old domain over to the new one.
+type addressRewrite struct {
+	match   *regexp.Regexp
+	replace string
+}
+
+// loadAddressRewrites reads the rules from a JSON file listing the match
+// patterns with their replacements, they are tried in the given order.
+func loadAddressRewrites(path string) ([]addressRewrite, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []struct {
+		Match   string `json:"match"`
+		Replace string `json:"replace"`
+	}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	var rewrites []addressRewrite
+	for _, rule := range rules {
+		match, err := regexp.Compile(rule.Match)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		rewrites = append(rewrites, addressRewrite{match: match, replace: rule.Replace})
+	}
+	return rewrites, nil
+}
+
+// rewriteAddresses applies the first matching rule to every recipient, the
+// rewritten addresses are validated like the others afterwards.
+func (e *email) rewriteAddresses(rewrites []addressRewrite) {
+	for _, field := range []*string{&e.To, &e.Cc, &e.Bcc} {
+		if len(*field) == 0 {
+			continue
+		}
+		recipients := strings.Split(*field, ",")
+		for i, recipient := range recipients {
+			recipients[i] = rewriteAddress(rewrites, recipient)
+		}
+		*field = strings.Join(recipients, ",")
+	}
+}
+
+func rewriteAddress(rewrites []addressRewrite, address string) string {
+	for _, rewrite := range rewrites {
+		if !rewrite.match.MatchString(address) {
+			continue
+		}
+		rewritten := rewrite.match.ReplaceAllString(address, rewrite.replace)
+		log.Printf(`recipient "%s" is rewritten to "%s"`, address, rewritten)
+		return rewritten
+	}
+	return address
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRewriteAddresses(t *testing.T) {
+	file, err := ioutil.TempFile("", "rewrites*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(`[
+		{"match": "(?i)@old-domain\\.com$", "replace": "@new-domain.com"},
+		{"match": "^(.+)@legacy\\.com$", "replace": "${1}@new-domain.com"}
+	]`)
+	file.Close()
+	rewrites, err := loadAddressRewrites(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	e := &email{To: "alice@old-domain.com,bob@test.com", Cc: "carol@legacy.com", Bcc: "dave@OLD-DOMAIN.com"}
+	e.rewriteAddresses(rewrites)
+	if e.To != "alice@new-domain.com,bob@test.com" || e.Cc != "carol@new-domain.com" || e.Bcc != "dave@new-domain.com" {
+		t.Fatal("recipients", e.To, e.Cc, e.Bcc)
+	}
+	if !strings.Contains(logged.String(), `recipient "alice@old-domain.com" is rewritten to "alice@new-domain.com"`) {
+		t.Fatal("rewrite must be logged", logged.String())
+	}
+	if strings.Contains(logged.String(), "bob@test.com") {
+		t.Fatal("unchanged recipient must not be logged", logged.String())
+	}
+}
+
+func TestLoadAddressRewritesValidatesRules(t *testing.T) {
+	file, err := ioutil.TempFile("", "rewrites*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(`[{"match": "(@old-domain.com", "replace": "@new-domain.com"}]`)
+	file.Close()
+	if _, err := loadAddressRewrites(file.Name()); err == nil {
+		t.Fatal("invalid pattern must be rejected")
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"github.com/xeipuuv/gojsonschema"
+	"io/ioutil"
+	"strings"
+)
+
+// loadPayloadSchema reads the JSON Schema of the email payloads.
+func loadPayloadSchema(path string) (*gojsonschema.Schema, error) {
+	schema, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return gojsonschema.NewSchema(gojsonschema.NewBytesLoader(schema))
+}
+
+// validatePayload checks the JSON payload of an email against the schema, the
+// error lists every violation, e.g. an unexpected field or a wrong type.
+func validatePayload(schema *gojsonschema.Schema, body []byte) error {
+	result, err := schema.Validate(gojsonschema.NewBytesLoader(body))
+	if err != nil {
+		return err
+	}
+	if result.Valid() {
+		return nil
+	}
+	var violations []string
+	for _, violation := range result.Errors() {
+		violations = append(violations, violation.String())
+	}
+	return errors.New("payload doesn't match the schema: " + strings.Join(violations, "; "))
+}
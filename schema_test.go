@@ -0,0 +1,56 @@
+package main
+
+import (
+	"github.com/streadway/amqp"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testPayloadSchema = `{
+  "type": "object",
+  "required": ["to", "subject"],
+  "additionalProperties": false,
+  "properties": {
+    "to": {"type": "string"},
+    "subject": {"type": "string"},
+    "text_body": {"type": "string"}
+  }
+}`
+
+func TestPayloadSchema(t *testing.T) {
+	file, err := ioutil.TempFile("", "schema")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(testPayloadSchema)
+	file.Close()
+	schema, err := loadPayloadSchema(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := &fakeSES{}
+	m := newTestMailer(&config{amqpDLQ: "aws.ses.mailer.dlq"}, sender)
+	m.payloadSchema = schema
+
+	valid := &fakeAcknowledger{}
+	m.handle(amqp.Delivery{Acknowledger: valid, Body: []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body"}`)})
+	if len(sender.inputs) != 1 || valid.acked != 1 {
+		t.Fatal("valid payload must be sent")
+	}
+
+	invalid := &fakeAcknowledger{}
+	m.handle(amqp.Delivery{Acknowledger: invalid, Body: []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body","priorty":"high"}`)})
+	if len(sender.inputs) != 1 {
+		t.Fatal("payload with an extra field must not be sent")
+	}
+	published := m.channel.(*fakeChannel).published
+	if len(published) != 1 || published[0].key != "aws.ses.mailer.dlq" || invalid.acked != 1 {
+		t.Fatal("payload with an extra field must be dead-lettered", published)
+	}
+	if reason, _ := published[0].msg.Headers["x-rejection-reason"].(string); !strings.Contains(reason, "priorty") {
+		t.Fatal("reason must name the extra field", reason)
+	}
+}
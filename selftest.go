@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"log"
+	"time"
+)
+
+const selfTestTimeout = 30 * time.Second
+
+// sendSelfTest sends one email to the self-test address, so wrong credentials,
+// an unverified From address or no route to SES stop the mailer before it
+// consumes the queue.
+func sendSelfTest(sender sesSender, cfg *config) error {
+	input := createEmail(emailOptions{
+		from:       cfg.fromAddress,
+		returnPath: cfg.returnPathFor(cfg.fromAddress),
+	}, &email{
+		To:       cfg.selfTestTo,
+		Subject:  "aws-ses-mailer self-test",
+		TextBody: "The mailer has started and can send emails through SES.",
+	})
+	if len(cfg.configurationSet) > 0 {
+		input.ConfigurationSetName = aws.String(cfg.configurationSet)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), selfTestTimeout)
+	defer cancel()
+	if err := sendEmail(ctx, sender, input); err != nil {
+		return err
+	}
+	log.Println("startup self-test email is sent to", cfg.selfTestTo)
+	return nil
+}
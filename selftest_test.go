@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"strings"
+	"testing"
+)
+
+func TestSelfTestSendsEmail(t *testing.T) {
+	sender := &fakeSES{}
+	cfg := &config{fromAddress: "from@someone.com", selfTestTo: "ops@test.com", configurationSet: "default-set"}
+
+	if err := sendSelfTest(sender, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sender.inputs) != 1 {
+		t.Fatal("self-test email must be sent")
+	}
+	if aws.StringValue(sender.inputs[0].Destinations[0]) != "ops@test.com" || aws.StringValue(sender.inputs[0].ConfigurationSetName) != "default-set" {
+		t.Fatal("self-test destination and configuration set", sender.inputs[0])
+	}
+	if !strings.Contains(string(sender.inputs[0].RawMessage.Data), "From: from@someone.com\r\n") {
+		t.Fatal("self-test must be sent from the From address")
+	}
+}
+
+func TestSelfTestFails(t *testing.T) {
+	sender := &fakeSES{err: errors.New("InvalidClientTokenId: The security token included in the request is invalid")}
+
+	err := sendSelfTest(sender, &config{fromAddress: "from@someone.com", selfTestTo: "ops@test.com"})
+
+	if err == nil || !strings.Contains(err.Error(), "InvalidClientTokenId") {
+		t.Fatal("failed self-test must fail the startup", err)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	sendOutcomeSent   = "sent"
+	sendOutcomeFailed = "failed"
+)
+
+// sendEventEmitter writes one JSON event line per completed send for the log
+// based analytics, apart from the human-readable log lines.
+type sendEventEmitter struct {
+	mu  sync.Mutex
+	out io.Writer
+	now func() time.Time
+}
+
+func newSendEventEmitter(out io.Writer) *sendEventEmitter {
+	return &sendEventEmitter{out: out, now: time.Now}
+}
+
+type sendEvent struct {
+	Timestamp     string   `json:"timestamp"`
+	TrackingID    string   `json:"tracking_id"`
+	Type          string   `json:"type,omitempty"`
+	Tenant        string   `json:"tenant,omitempty"`
+	Recipients    int      `json:"recipients"`
+	Size          int      `json:"size"`
+	DurationMs    float64  `json:"duration_ms"`
+	Outcome       string   `json:"outcome"`
+	SESMessageIDs []string `json:"ses_message_ids"`
+	Error         string   `json:"error,omitempty"`
+}
+
+// emit writes the event of the send of the SES input, it does nothing when
+// the emitter is disabled. There is a SES message id per chunk of recipients
+// sent, the ones sent before a failure included.
+func (s *sendEventEmitter) emit(e *email, input *ses.SendRawEmailInput, receipts []sendReceipt, duration time.Duration, err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	event := sendEvent{
+		Timestamp:     s.now().UTC().Format(time.RFC3339Nano),
+		TrackingID:    e.trackingID,
+		Type:          e.Type,
+		Tenant:        e.Tenant,
+		Recipients:    len(input.Destinations),
+		Size:          len(input.RawMessage.Data),
+		DurationMs:    float64(duration) / float64(time.Millisecond),
+		Outcome:       sendOutcomeSent,
+		SESMessageIDs: []string{},
+	}
+	for _, receipt := range receipts {
+		event.SESMessageIDs = append(event.SESMessageIDs, receipt.messageID)
+	}
+	if err != nil {
+		event.Outcome = sendOutcomeFailed
+		event.Error = err.Error()
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		log.Println("send event err", err)
+		return
+	}
+	s.out.Write(append(line, '\n'))
+}
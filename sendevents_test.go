@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/streadway/amqp"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureStdout returns what f writes to stdout.
+func captureStdout(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	f()
+	os.Stdout = stdout
+	w.Close()
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestSendEventPerSend(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	sender := &fakeSES{}
+	m := newTestMailer(&config{}, sender)
+	out := captureStdout(t, func() {
+		m.sendEvents = newSendEventEmitter(os.Stdout)
+		m.sendEvents.now = func() time.Time { return time.Date(2020, 1, 17, 10, 0, 0, 0, time.UTC) }
+		m.handle(amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: []byte(`{"to":"to@test.com","cc":"cc@test.com","subject":"Wow","text_body":"text body","type":"marketing"}`)})
+		sender.err = awserr.New(ses.ErrCodeMessageRejected, "Email address is not verified.", nil)
+		m.handle(amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body"}`)})
+	})
+
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatal("one event must be written per send", out)
+	}
+	var events []sendEvent
+	for _, line := range lines {
+		var event sendEvent
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatal(err, line)
+		}
+		events = append(events, event)
+	}
+
+	sent := events[0]
+	if sent.Timestamp != "2020-01-17T10:00:00Z" || sent.Outcome != "sent" || sent.Recipients != 2 || sent.Type != "marketing" {
+		t.Fatal("sent event", lines[0])
+	}
+	if sent.Size != len(sender.inputs[0].RawMessage.Data) || sent.DurationMs <= 0 {
+		t.Fatal("size and duration", lines[0])
+	}
+	if len(sent.SESMessageIDs) != 1 || sent.SESMessageIDs[0] != "message-1" {
+		t.Fatal("SES message id", lines[0])
+	}
+	if sent.TrackingID == "" || !strings.Contains(logged.String(), "tracking_id="+sent.TrackingID) {
+		t.Fatal("tracking id must match the log lines", lines[0], logged.String())
+	}
+
+	failed := events[1]
+	if failed.Outcome != "failed" || len(failed.SESMessageIDs) != 0 || !strings.Contains(failed.Error, "MessageRejected") || failed.TrackingID == sent.TrackingID {
+		t.Fatal("failed event", lines[1])
+	}
+	if strings.Contains(logged.String(), `"outcome"`) {
+		t.Fatal("events must not be written to the debug log", logged.String())
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import "time"
+
+// defaultMaxSendTimeout caps the send timeout of an email when MAX_SEND_TIMEOUT is not set.
+const defaultMaxSendTimeout = 10 * time.Minute
+
+// sendTimeoutFor returns the deadline of fetching, building and sending the
+// email, send_timeout_ms overrides MESSAGE_DEADLINE up to the configured
+// maximum. There is no deadline when it is 0.
+func (cfg *config) sendTimeoutFor(e *email) time.Duration {
+	if e.SendTimeoutMs <= 0 {
+		return cfg.messageDeadline
+	}
+	maxSendTimeout := defaultMaxSendTimeout
+	if cfg.maxSendTimeout > 0 {
+		maxSendTimeout = cfg.maxSendTimeout
+	}
+	// compared in milliseconds, converting a huge send_timeout_ms overflows
+	if e.SendTimeoutMs < int64(maxSendTimeout/time.Millisecond) {
+		return time.Duration(e.SendTimeoutMs) * time.Millisecond
+	}
+	return maxSendTimeout
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSendTimeoutFor(t *testing.T) {
+	cfg := &config{messageDeadline: time.Minute, maxSendTimeout: 5 * time.Minute}
+
+	if timeout := cfg.sendTimeoutFor(&email{}); timeout != time.Minute {
+		t.Fatal("global timeout must be used without send_timeout_ms", timeout)
+	}
+	if timeout := cfg.sendTimeoutFor(&email{SendTimeoutMs: 180000}); timeout != 3*time.Minute {
+		t.Fatal("send_timeout_ms must override the global timeout", timeout)
+	}
+	if timeout := cfg.sendTimeoutFor(&email{SendTimeoutMs: 3600000}); timeout != 5*time.Minute {
+		t.Fatal("send_timeout_ms must be clamped", timeout)
+	}
+
+	cfg = &config{}
+	if timeout := cfg.sendTimeoutFor(&email{}); timeout != 0 {
+		t.Fatal("there must be no deadline by default", timeout)
+	}
+	if timeout := cfg.sendTimeoutFor(&email{SendTimeoutMs: 3600000}); timeout != defaultMaxSendTimeout {
+		t.Fatal("send_timeout_ms must be clamped by default", timeout)
+	}
+	if timeout := cfg.sendTimeoutFor(&email{SendTimeoutMs: math.MaxInt64}); timeout != defaultMaxSendTimeout {
+		t.Fatal("huge send_timeout_ms must be clamped rather than overflow", timeout)
+	}
+	if timeout := cfg.sendTimeoutFor(&email{SendTimeoutMs: 1 << 62}); timeout != defaultMaxSendTimeout {
+		t.Fatal("huge send_timeout_ms must be clamped rather than overflow", timeout)
+	}
+}
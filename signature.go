@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+)
+
+const (
+	// mailerIDHeader carries the random id of the email, SES replaces the
+	// Message-ID so it can't be signed.
+	mailerIDHeader = "X-Mailer-Id"
+	// mailerSignatureHeader carries the hex HMAC-SHA256 of the id and the
+	// recipients, downstream processors verify it with the shared secret.
+	mailerSignatureHeader = "X-Mailer-Signature"
+)
+
+// newMailerID returns a random id for the signature.
+func newMailerID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// mailerSignature signs the id together with the To and Cc recipients, which
+// are lowercased and sorted, so their order in the headers doesn't matter.
+func mailerSignature(secret []byte, id string, recipients []string) string {
+	normalized := make([]string, 0, len(recipients))
+	for _, recipient := range recipients {
+		normalized = append(normalized, strings.ToLower(strings.TrimSpace(recipient)))
+	}
+	sort.Strings(normalized)
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(id + "\n" + strings.Join(normalized, ",")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyMailerSignature checks the signature of the received email, it is what
+// the downstream processors do.
+func verifyMailerSignature(secret []byte, id string, recipients []string, signature string) bool {
+	return hmac.Equal([]byte(mailerSignature(secret, id, recipients)), []byte(signature))
+}
+
+// signedRecipients are the recipients the signature covers, Bcc is left out as
+// it is not in the headers the receivers see.
+func (e *email) signedRecipients() []string {
+	var recipients []string
+	for _, field := range []string{e.To, e.Cc} {
+		if len(field) > 0 {
+			recipients = append(recipients, strings.Split(field, ",")...)
+		}
+	}
+	return recipients
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestSignedEmailIsVerifiable(t *testing.T) {
+	secret := []byte("s3cr3t")
+	input := createEmail(emailOptions{from: "from@someone.com", signingSecret: secret}, &email{
+		To:       "to@test.com,Other@test.com",
+		Cc:       "cc@test.com",
+		Bcc:      "bcc@test.com",
+		Subject:  "Wow",
+		TextBody: "text body",
+	})
+
+	message, err := mail.ReadMessage(bytes.NewReader(input.RawMessage.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := message.Header.Get(mailerIDHeader)
+	signature := message.Header.Get(mailerSignatureHeader)
+	if len(id) == 0 || len(signature) == 0 {
+		t.Fatal("signature headers must be present", string(input.RawMessage.Data))
+	}
+	var recipients []string
+	for _, field := range []string{"To", "Cc"} {
+		addresses, err := message.Header.AddressList(field)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, address := range addresses {
+			recipients = append(recipients, address.Address)
+		}
+	}
+	if !verifyMailerSignature(secret, id, recipients, signature) {
+		t.Fatal("signature must be verifiable with the secret")
+	}
+	if verifyMailerSignature([]byte("other"), id, recipients, signature) {
+		t.Fatal("signature must not be verifiable with another secret")
+	}
+	if verifyMailerSignature(secret, id, append(recipients, "bcc@test.com"), signature) {
+		t.Fatal("signature must not be verifiable with other recipients")
+	}
+}
+
+func TestUnsignedEmail(t *testing.T) {
+	input := createEmail(emailOptions{from: "from@someone.com"}, &email{To: "to@test.com", Subject: "Wow", TextBody: "text body"})
+
+	if strings.Contains(string(input.RawMessage.Data), mailerSignatureHeader) {
+		t.Fatal("email must not be signed without the secret")
+	}
+}
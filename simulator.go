@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// sesSimulatorDomain is the domain of the SES mailbox simulator addresses.
+const sesSimulatorDomain = "simulator.amazonses.com"
+
+// sesSimulatorOutcomes maps the mailbox simulator addresses to what SES simulates for them.
+var sesSimulatorOutcomes = map[string]string{
+	"success":         "successful delivery",
+	"bounce":          "hard bounce",
+	"ooto":            "out of the office auto response",
+	"complaint":       "complaint",
+	"suppressionlist": "bounce of suppression list address",
+}
+
+// simulatorOutcome returns the simulated outcome for the mailbox simulator address,
+// ok is false for the addresses out of the simulator domain. A +label suffix is allowed.
+func simulatorOutcome(address string) (outcome string, ok bool) {
+	at := strings.LastIndex(address, "@")
+	if !strings.EqualFold(address[at+1:], sesSimulatorDomain) {
+		return "", false
+	}
+	mailbox := strings.ToLower(address[:at])
+	if plus := strings.Index(mailbox, "+"); plus >= 0 {
+		mailbox = mailbox[:plus]
+	}
+	return sesSimulatorOutcomes[mailbox], true
+}
+
+// validateSimulatorAddresses rejects the simulator domain addresses SES doesn't simulate anything for.
+func (e *email) validateSimulatorAddresses() error {
+	for _, recipient := range e.recipients() {
+		if outcome, ok := simulatorOutcome(recipient); ok && outcome == "" {
+			return errInvalidEmail{fmt.Errorf(`"%s" is not valid mailbox simulator email`, recipient)}
+		}
+	}
+	return nil
+}
+
+func logSimulatedOutcomes(e *email) {
+	for _, recipient := range e.recipients() {
+		if outcome, ok := simulatorOutcome(recipient); ok {
+			log.Println("mailbox simulator", recipient, "will simulate", outcome)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"strings"
+	"testing"
+)
+
+func TestSimulatorAddressesPassValidation(t *testing.T) {
+	e := &email{
+		To:       "success@simulator.amazonses.com,bounce+test1@simulator.amazonses.com",
+		Cc:       "complaint@simulator.amazonses.com",
+		Subject:  "Wow",
+		TextBody: "text body",
+	}
+	if err := e.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.validateSimulatorAddresses(); err != nil {
+		t.Fatal(err)
+	}
+	mx := newMXValidator(&fakeResolver{})
+	mx.allowSimulator = true
+	if err := mx.validate(e); err != nil {
+		t.Fatal("simulator addresses must not be filtered", err)
+	}
+
+	input := createEmail(emailOptions{from: "from@someone.com"}, e)
+	destinations := strings.Join(aws.StringValueSlice(input.Destinations), ",")
+	if destinations != "success@simulator.amazonses.com,bounce+test1@simulator.amazonses.com,complaint@simulator.amazonses.com" {
+		t.Fatal("simulator addresses must be routed normally", destinations)
+	}
+}
+
+func TestUnknownSimulatorAddressIsRejected(t *testing.T) {
+	e := &email{To: "unknown@simulator.amazonses.com"}
+
+	err := e.validateSimulatorAddresses()
+
+	if err == nil || err.Error() != `"unknown@simulator.amazonses.com" is not valid mailbox simulator email` {
+		t.Fatal("unexpected error", err)
+	}
+	var invalidErr errInvalidEmail
+	if !errors.As(err, &invalidErr) {
+		t.Fatal("email must be rejected rather than stop the process", err)
+	}
+}
+
+func TestSimulatorOutcome(t *testing.T) {
+	if outcome, ok := simulatorOutcome("ooto@simulator.amazonses.com"); !ok || outcome != "out of the office auto response" {
+		t.Fatal("ooto outcome", outcome, ok)
+	}
+	if _, ok := simulatorOutcome("success@amazonses.com"); ok {
+		t.Fatal("other domains are not simulated")
+	}
+}
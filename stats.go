@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// stats keeps the live counters of the mailer, it is safe for concurrent use.
+type stats struct {
+	inFlight      int64
+	consumedTotal int64
+	sentTotal     int64
+	failedTotal   int64
+	// retriedTotal counts the messages requeued, droppedTotal the ones taken out
+	// of the queue unsent, dead-lettered ones included.
+	retriedTotal int64
+	droppedTotal int64
+	backoff      int64
+	paused       int32
+	// accountPaused is set while SES reports the sending of the account paused.
+	accountPaused int32
+	// quotaRemaining holds the float64 bits of the remaining SES quota, it is
+	// reported once quotaKnown is set.
+	quotaRemaining uint64
+	quotaKnown     int32
+}
+
+type statsReport struct {
+	InFlight       int64    `json:"in_flight"`
+	Consumed       int64    `json:"consumed"`
+	Sent           int64    `json:"sent"`
+	Failed         int64    `json:"failed"`
+	Retried        int64    `json:"retried"`
+	Dropped        int64    `json:"dropped"`
+	BackoffSeconds float64  `json:"backoff_seconds"`
+	Paused         bool     `json:"paused"`
+	AccountPaused  bool     `json:"account_sending_paused"`
+	QuotaRemaining *float64 `json:"quota_remaining,omitempty"`
+}
+
+func (s *stats) begin() {
+	atomic.AddInt64(&s.inFlight, 1)
+	atomic.AddInt64(&s.consumedTotal, 1)
+}
+
+func (s *stats) end() {
+	atomic.AddInt64(&s.inFlight, -1)
+}
+
+func (s *stats) sent() {
+	atomic.AddInt64(&s.sentTotal, 1)
+}
+
+func (s *stats) failed() {
+	atomic.AddInt64(&s.failedTotal, 1)
+}
+
+func (s *stats) retried() {
+	atomic.AddInt64(&s.retriedTotal, 1)
+}
+
+func (s *stats) dropped() {
+	atomic.AddInt64(&s.droppedTotal, 1)
+}
+
+func (s *stats) setBackoff(backoff time.Duration) {
+	atomic.StoreInt64(&s.backoff, int64(backoff))
+}
+
+func (s *stats) setPaused(paused bool) {
+	var v int32
+	if paused {
+		v = 1
+	}
+	atomic.StoreInt32(&s.paused, v)
+}
+
+func (s *stats) setAccountPaused(paused bool) {
+	var v int32
+	if paused {
+		v = 1
+	}
+	atomic.StoreInt32(&s.accountPaused, v)
+}
+
+func (s *stats) setQuotaRemaining(remaining float64) {
+	atomic.StoreUint64(&s.quotaRemaining, math.Float64bits(remaining))
+	atomic.StoreInt32(&s.quotaKnown, 1)
+}
+
+func (s *stats) report() statsReport {
+	report := statsReport{
+		InFlight:       atomic.LoadInt64(&s.inFlight),
+		Consumed:       atomic.LoadInt64(&s.consumedTotal),
+		Sent:           atomic.LoadInt64(&s.sentTotal),
+		Failed:         atomic.LoadInt64(&s.failedTotal),
+		Retried:        atomic.LoadInt64(&s.retriedTotal),
+		Dropped:        atomic.LoadInt64(&s.droppedTotal),
+		BackoffSeconds: time.Duration(atomic.LoadInt64(&s.backoff)).Seconds(),
+		Paused:         atomic.LoadInt32(&s.paused) == 1,
+		AccountPaused:  atomic.LoadInt32(&s.accountPaused) == 1,
+	}
+	if atomic.LoadInt32(&s.quotaKnown) == 1 {
+		quotaRemaining := math.Float64frombits(atomic.LoadUint64(&s.quotaRemaining))
+		report.QuotaRemaining = &quotaRemaining
+	}
+	return report
+}
+
+// logSummary logs the lifetime counters, it is a short report of the run on shutdown.
+func (s *stats) logSummary() {
+	report := s.report()
+	log.Printf("summary: consumed %d, sent %d, failed %d, retried %d, dropped %d\n",
+		report.Consumed, report.Sent, report.Failed, report.Retried, report.Dropped)
+}
+
+func (s *stats) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.report())
+}
+
+func serveStats(addr string, s *stats) {
+	mux := http.NewServeMux()
+	mux.Handle("/stats", s)
+	log.Fatal("stats server err", http.ListenAndServe(addr, mux))
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/streadway/amqp"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsEndpoint(t *testing.T) {
+	s := &stats{}
+	s.begin()
+	s.sent()
+	s.end()
+	s.begin()
+	s.failed()
+	s.setBackoff(5 * time.Minute)
+	s.setPaused(true)
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/stats", nil))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatal("status code", recorder.Code)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &fields); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"in_flight":       float64(1),
+		"consumed":        float64(2),
+		"sent":            float64(1),
+		"failed":          float64(1),
+		"backoff_seconds": float64(300),
+		"paused":          true,
+	}
+	for field, value := range expected {
+		if fields[field] != value {
+			t.Fatalf("%s must be %v, got %v", field, value, fields[field])
+		}
+	}
+}
+
+func TestStatsEndpointIsReadOnly(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	(&stats{}).ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/stats", nil))
+
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Fatal("status code", recorder.Code)
+	}
+}
+
+func TestStatsReportsQuotaRemaining(t *testing.T) {
+	s := &stats{}
+	if s.report().QuotaRemaining != nil {
+		t.Fatal("quota must not be reported before it is known")
+	}
+
+	s.setQuotaRemaining(42)
+
+	if remaining := s.report().QuotaRemaining; remaining == nil || *remaining != 42 {
+		t.Fatal("remaining quota", remaining)
+	}
+}
+
+func TestSummaryOnShutdown(t *testing.T) {
+	deliveries := make(chan amqp.Delivery)
+	conn := &fakeConnection{channels: []*fakeChannel{{deliveries: deliveries}}}
+	m := newTestMailer(&config{}, &rejectingSES{invalid: "bad@test.com"})
+	m.groups = fakeGroupResolver{}
+	stop := make(chan os.Signal)
+	go func() {
+		for _, body := range []string{
+			`{"to":"to@test.com","subject":"Sent","text_body":"text body"}`,
+			`{"to":"bad@test.com","subject":"Retried","text_body":"text body"}`,
+			`{"to":"@nobody","subject":"Dropped","text_body":"text body"}`,
+		} {
+			deliveries <- amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: []byte(body)}
+		}
+		stop <- os.Interrupt
+	}()
+
+	if !m.run(conn, stop) {
+		t.Fatal("mailer must be stopped by the signal")
+	}
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+	m.stats.logSummary()
+
+	if !strings.Contains(out.String(), "summary: consumed 3, sent 1, failed 2, retried 1, dropped 1\n") {
+		t.Fatal("summary must reflect the activity", out.String())
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/streadway/amqp"
+	"log"
+)
+
+const (
+	statusSent   = "sent"
+	statusFailed = "failed"
+)
+
+// recipientStatus is the status message of one recipient of a send attempt,
+// published to AMQP_STATUS_QUEUE.
+type recipientStatus struct {
+	Recipient string `json:"recipient"`
+	Outcome   string `json:"outcome"`
+	// MessageID is the SES message id of the chunk the recipient was sent in.
+	MessageID string `json:"message_id,omitempty"`
+	Error     string `json:"error,omitempty"`
+	// CorrelationID is copied from the consumed message, so the producer can
+	// match the statuses with its email.
+	CorrelationID string `json:"correlation_id,omitempty"`
+}
+
+// recipientStatuses lists the outcome of every destination, the destinations
+// dropped from a chunk failed with the SES error of the drop, the other ones
+// without a receipt failed with the error.
+func recipientStatuses(destinations []*string, receipts []sendReceipt, err error) []recipientStatus {
+	messageIDs := map[string]string{}
+	dropped := map[string]string{}
+	for _, receipt := range receipts {
+		for _, destination := range receipt.destinations {
+			messageIDs[aws.StringValue(destination)] = receipt.messageID
+		}
+		for destination, dropErr := range receipt.dropped {
+			dropped[destination] = dropErr
+		}
+	}
+
+	statuses := make([]recipientStatus, 0, len(destinations))
+	for _, destination := range aws.StringValueSlice(destinations) {
+		status := recipientStatus{Recipient: destination}
+		if messageID, ok := messageIDs[destination]; ok {
+			status.Outcome = statusSent
+			status.MessageID = messageID
+		} else {
+			status.Outcome = statusFailed
+			if dropErr, ok := dropped[destination]; ok {
+				status.Error = dropErr
+			} else if err != nil {
+				status.Error = err.Error()
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// newSendResult summarizes the send attempt for the webhook.
+func newSendResult(message amqp.Delivery, e *email, destinations []*string, receipts []sendReceipt, err error) sendResult {
+	result := sendResult{
+		Outcome:       statusSent,
+		Recipients:    aws.StringValueSlice(destinations),
+		Subject:       e.Subject,
+		CorrelationID: message.CorrelationId,
+	}
+	for _, receipt := range receipts {
+		result.MessageIDs = append(result.MessageIDs, receipt.messageID)
+	}
+	if err != nil {
+		result.Outcome = statusFailed
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// publishStatuses publishes one status message per recipient of the send
+// attempt. The statuses are best effort, a failed publish is only logged.
+func (m *mailer) publishStatuses(message amqp.Delivery, destinations []*string, receipts []sendReceipt, err error) {
+	for _, status := range recipientStatuses(destinations, receipts, err) {
+		status.CorrelationID = message.CorrelationId
+		body, err := json.Marshal(status)
+		if err != nil {
+			log.Println("status encoding err", err)
+			continue
+		}
+		err = m.channel.Publish("", m.cfg.amqpStatusQueue, false, false, amqp.Publishing{
+			ContentType:   "application/json",
+			CorrelationId: message.CorrelationId,
+			DeliveryMode:  amqp.Persistent,
+			Body:          body,
+		})
+		if err != nil {
+			log.Println("status publishing err", status.Recipient, err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/streadway/amqp"
+	"testing"
+)
+
+func TestStatusPerRecipientOfSplitSend(t *testing.T) {
+	channel := &fakeChannel{}
+	m := newTestMailer(&config{amqpStatusQueue: "aws.ses.mailer.status"}, &fakeSES{})
+	m.channel = channel
+
+	m.handle(amqp.Delivery{Acknowledger: &fakeAcknowledger{}, CorrelationId: "order-42", Body: []byte(`{"to":"first@test.com","bcc":"second@test.com","subject":"Wow","text_body":"text body"}`)})
+
+	if len(channel.published) != 2 {
+		t.Fatal("one status must be published per recipient", len(channel.published))
+	}
+	for i, recipient := range []string{"first@test.com", "second@test.com"} {
+		published := channel.published[i]
+		if published.key != "aws.ses.mailer.status" || published.msg.CorrelationId != "order-42" {
+			t.Fatal("status routing", published.key, published.msg.CorrelationId)
+		}
+		var status recipientStatus
+		if err := json.Unmarshal(published.msg.Body, &status); err != nil {
+			t.Fatal(err)
+		}
+		if status.Recipient != recipient || status.Outcome != statusSent || status.MessageID != "message-1" || status.CorrelationID != "order-42" {
+			t.Fatal("status of", recipient, string(published.msg.Body))
+		}
+	}
+}
+
+func TestStatusesOfPartlyFailedSend(t *testing.T) {
+	destinations := aws.StringSlice([]string{"first@test.com", "second@test.com"})
+	receipts := []sendReceipt{{destinations: destinations[:1], messageID: "message-1"}}
+
+	statuses := recipientStatuses(destinations, receipts, errors.New("Throttling: slow down"))
+
+	if statuses[0].Outcome != statusSent || statuses[0].MessageID != "message-1" {
+		t.Fatal("first chunk is sent", statuses[0])
+	}
+	if statuses[1].Outcome != statusFailed || statuses[1].Error != "Throttling: slow down" || statuses[1].MessageID != "" {
+		t.Fatal("second chunk failed", statuses[1])
+	}
+}
+
+func TestStatusOfDroppedInvalidRecipient(t *testing.T) {
+	channel := &fakeChannel{}
+	sender := &rejectingSES{invalid: "bad..address@test.com"}
+	m := newTestMailer(&config{amqpStatusQueue: "aws.ses.mailer.status", invalidRecipientPolicy: invalidRecipientDrop}, newInvalidRecipientDropper(sender))
+	m.channel = channel
+
+	m.handle(amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: []byte(`{"to":"first@test.com","cc":"bad..address@test.com","subject":"Wow","text_body":"text body"}`)})
+
+	if len(sender.inputs) != 1 || len(channel.published) != 2 {
+		t.Fatal("email must be resent and a status published per recipient", len(sender.inputs), len(channel.published))
+	}
+	var sent, dropped recipientStatus
+	json.Unmarshal(channel.published[0].msg.Body, &sent)
+	json.Unmarshal(channel.published[1].msg.Body, &dropped)
+	if sent.Recipient != "first@test.com" || sent.Outcome != statusSent || sent.MessageID != "message-1" {
+		t.Fatal("status of the valid recipient", sent)
+	}
+	if dropped.Recipient != "bad..address@test.com" || dropped.Outcome != statusFailed || dropped.MessageID != "" || dropped.Error == "" {
+		t.Fatal("dropped recipient must be reported failed", dropped)
+	}
+}
+
+func TestConsumeDeclaresStatusQueue(t *testing.T) {
+	channel := &fakeChannel{}
+
+	if _, err := consume(channel, &config{amqpQueue: "aws.ses.mailer", amqpStatusQueue: "aws.ses.mailer.status"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(channel.declared) != 2 || channel.declared[1] != "aws.ses.mailer.status" {
+		t.Fatal("status queue must be declared", channel.declared)
+	}
+}
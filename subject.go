@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"unicode/utf8"
+)
+
+const (
+	// subjectMaxLength is the characters of the subject an unfolded Subject
+	// header line would hold, RFC 5322 2.1.1. The header is folded and its
+	// non-ASCII characters are encoded when it is written, so the characters
+	// are counted rather than the bytes.
+	subjectMaxLength = 998 - len("Subject: ")
+	subjectEllipsis  = "…"
+
+	// subjectLengthReject rejects the emails whose subject is too long.
+	subjectLengthReject = "reject"
+	// subjectLengthTruncate cuts the subject down to the limit with an ellipsis.
+	subjectLengthTruncate = "truncate"
+)
+
+// checkSubjectLength returns an error when the subject is longer than the
+// limit, under the truncate policy the subject is truncated and logged instead.
+func (e *email) checkSubjectLength(policy string) error {
+	length := utf8.RuneCountInString(e.Subject)
+	if length <= subjectMaxLength {
+		return nil
+	}
+	if policy != subjectLengthTruncate {
+		return errInvalidEmail{fmt.Errorf("subject is longer than %d characters", subjectMaxLength)}
+	}
+	runes := []rune(e.Subject)
+	e.Subject = string(runes[:subjectMaxLength-utf8.RuneCountInString(subjectEllipsis)]) + subjectEllipsis
+	log.Printf("subject is truncated from %d to %d characters: %s %s%s\n", length, utf8.RuneCountInString(e.Subject), e.Subject, e.To, e.logLabels())
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"github.com/streadway/amqp"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSubjectLengthReject(t *testing.T) {
+	e := &email{Subject: strings.Repeat("a", subjectMaxLength)}
+	if err := e.checkSubjectLength(subjectLengthReject); err != nil {
+		t.Fatal("subject at the limit must pass", err)
+	}
+
+	e.Subject += "a"
+	if err := e.checkSubjectLength(subjectLengthReject); err == nil {
+		t.Fatal("long subject must be rejected")
+	}
+
+	e.Subject = strings.Repeat("Отчёт", 100)
+	if err := e.checkSubjectLength(subjectLengthReject); err != nil {
+		t.Fatal("characters must be counted rather than bytes", err)
+	}
+}
+
+func TestSubjectLengthPolicyInHandle(t *testing.T) {
+	body := []byte(`{"to":"to@test.com","subject":"` + strings.Repeat("a", subjectMaxLength+1) + `","text_body":"text body"}`)
+
+	sender := &fakeSES{}
+	acknowledger := &fakeAcknowledger{}
+	newTestMailer(&config{}, sender).handle(amqp.Delivery{Acknowledger: acknowledger, Body: body})
+	if acknowledger.acked != 1 || len(sender.inputs) != 1 {
+		t.Fatal("subject length must not be checked by default", acknowledger, len(sender.inputs))
+	}
+
+	sender = &fakeSES{}
+	acknowledger = &fakeAcknowledger{}
+	newTestMailer(&config{subjectLengthPolicy: subjectLengthReject}, sender).handle(amqp.Delivery{Acknowledger: acknowledger, Body: body})
+	if len(sender.inputs) != 0 || acknowledger.nacked != 1 || acknowledger.requeued != 0 {
+		t.Fatal("long subject must be rejected without requeue", len(sender.inputs), acknowledger)
+	}
+}
+
+func TestSubjectLengthTruncate(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	e := &email{To: "to@test.com", Subject: strings.Repeat("Отчёт ", 200)}
+	if err := e.checkSubjectLength(subjectLengthTruncate); err != nil {
+		t.Fatal(err)
+	}
+	if utf8.RuneCountInString(e.Subject) != subjectMaxLength {
+		t.Fatal("subject is not truncated to the limit", utf8.RuneCountInString(e.Subject))
+	}
+	if !strings.HasSuffix(e.Subject, "…") || !utf8.ValidString(e.Subject) {
+		t.Fatal("subject must end with an ellipsis", e.Subject)
+	}
+	if !strings.Contains(logged.String(), "subject is truncated from 1200 to 989 characters") || !strings.Contains(logged.String(), "to@test.com") {
+		t.Fatal("truncation must be logged", logged.String())
+	}
+
+	logged.Reset()
+	e = &email{To: "to@test.com", Subject: "Wow"}
+	if err := e.checkSubjectLength(subjectLengthTruncate); err != nil || e.Subject != "Wow" {
+		t.Fatal("short subject must stay", e.Subject, err)
+	}
+	if logged.Len() != 0 {
+		t.Fatal("short subject must not be logged", logged.String())
+	}
+}
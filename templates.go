@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+)
+
+// validateTemplateName keeps the template names inside the template directory.
+func validateTemplateName(name string) error {
+	if strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return fmt.Errorf(`"%s" is not valid template name`, name)
+	}
+	return nil
+}
+
+// renderTemplates renders HTMLTemplate and TextTemplate of the template
+// directory with TemplateData into the bodies. The templates are read on every
+// render, so they may be changed without a restart. The html template escapes
+// the data, the text one doesn't.
+func (e *email) renderTemplates(dir string) error {
+	if len(e.HTMLTemplate) == 0 && len(e.TextTemplate) == 0 {
+		return nil
+	}
+	if len(dir) == 0 {
+		return errors.New("templates are not configured, TEMPLATE_DIR is not set")
+	}
+
+	if len(e.HTMLTemplate) > 0 {
+		if len(e.HTMLBody) > 0 {
+			return errors.New("html_template must not be combined with html_body")
+		}
+		if err := validateTemplateName(e.HTMLTemplate); err != nil {
+			return err
+		}
+		t, err := htmltemplate.ParseFiles(filepath.Join(dir, e.HTMLTemplate))
+		if err != nil {
+			return err
+		}
+		var body bytes.Buffer
+		if err := t.Execute(&body, e.TemplateData); err != nil {
+			return err
+		}
+		e.HTMLBody = body.String()
+	}
+
+	if len(e.TextTemplate) > 0 {
+		if len(e.TextBody) > 0 {
+			return errors.New("text_template must not be combined with text_body")
+		}
+		if err := validateTemplateName(e.TextTemplate); err != nil {
+			return err
+		}
+		t, err := texttemplate.ParseFiles(filepath.Join(dir, e.TextTemplate))
+		if err != nil {
+			return err
+		}
+		var body bytes.Buffer
+		if err := t.Execute(&body, e.TemplateData); err != nil {
+			return err
+		}
+		e.TextBody = body.String()
+	}
+	return nil
+}
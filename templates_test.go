@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func templateDir(t *testing.T, templates map[string]string) string {
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range templates {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestRenderSeparateTemplates(t *testing.T) {
+	dir := templateDir(t, map[string]string{
+		"welcome.html": `<h1>Welcome, {{.name}}!</h1><p>Your plan: {{.plan}}</p>`,
+		"welcome.txt":  `Hi {{.name}}, you are on the {{.plan}} plan.`,
+	})
+	defer os.RemoveAll(dir)
+	e := &email{
+		HTMLTemplate: "welcome.html",
+		TextTemplate: "welcome.txt",
+		TemplateData: map[string]interface{}{"name": "Tom & Jerry", "plan": "pro"},
+	}
+
+	if err := e.renderTemplates(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if e.HTMLBody != "<h1>Welcome, Tom &amp; Jerry!</h1><p>Your plan: pro</p>" {
+		t.Fatal("html body must be rendered from the html template", e.HTMLBody)
+	}
+	if e.TextBody != "Hi Tom & Jerry, you are on the pro plan." {
+		t.Fatal("text body must be rendered from the text template", e.TextBody)
+	}
+}
+
+func TestTemplateErrors(t *testing.T) {
+	dir := templateDir(t, map[string]string{"welcome.txt": "Hi"})
+	defer os.RemoveAll(dir)
+
+	for _, c := range []struct {
+		email *email
+		dir   string
+	}{
+		{&email{TextTemplate: "welcome.txt"}, ""},
+		{&email{TextTemplate: "../secrets.txt"}, dir},
+		{&email{TextTemplate: "missing.txt"}, dir},
+		{&email{TextTemplate: "welcome.txt", TextBody: "text body"}, dir},
+	} {
+		if err := c.email.renderTemplates(c.dir); err == nil {
+			t.Fatal("template must fail", c.email.TextTemplate, c.dir)
+		}
+	}
+}
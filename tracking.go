@@ -0,0 +1,22 @@
+package main
+
+import "regexp"
+
+// The sizes are matched at the start of an attribute or a css declaration,
+// \b would also match border-width or max-width.
+var (
+	imgTagRegexp      = regexp.MustCompile(`(?i)<img\b[^>]*>`)
+	pixelWidthRegexp  = regexp.MustCompile(`(?i)(?:^|[\s;"'])width\s*(?:=\s*["']?|:)\s*[01](?:px)?\b`)
+	pixelHeightRegexp = regexp.MustCompile(`(?i)(?:^|[\s;"'])height\s*(?:=\s*["']?|:)\s*[01](?:px)?\b`)
+)
+
+// stripTrackingPixels removes the images sized 1x1 (or 0x0) from the HTML body,
+// which is how open tracking pixels are usually embedded.
+func (e *email) stripTrackingPixels() {
+	e.HTMLBody = imgTagRegexp.ReplaceAllStringFunc(e.HTMLBody, func(tag string) string {
+		if pixelWidthRegexp.MatchString(tag) && pixelHeightRegexp.MatchString(tag) {
+			return ""
+		}
+		return tag
+	})
+}
@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestStripTrackingPixels(t *testing.T) {
+	testCases := []struct {
+		htmlBody string
+		expected string
+	}{
+		{
+			`<p>Hello</p><img src="https://track.example.com/open.gif" width="1" height="1" alt="">`,
+			`<p>Hello</p>`,
+		},
+		{
+			`<p>Hello</p><IMG style="width:1px;height:1px" src="https://track.example.com/o"/>`,
+			`<p>Hello</p>`,
+		},
+		{
+			`<p>Hello</p><img src="https://track.example.com/o" width=0 height=0>`,
+			`<p>Hello</p>`,
+		},
+		{
+			`<p>Hello</p><img src="https://example.com/logo.png" width="100" height="10">`,
+			`<p>Hello</p><img src="https://example.com/logo.png" width="100" height="10">`,
+		},
+		{
+			`<p>Hello</p><img src="https://example.com/rule.png" style="border-width:0;max-width:1px;height:1px">`,
+			`<p>Hello</p><img src="https://example.com/rule.png" style="border-width:0;max-width:1px;height:1px">`,
+		},
+		{
+			`<p>Hello</p><img src="https://example.com/rule.png" style="border-width: 1px; width: 600px; height: 1px">`,
+			`<p>Hello</p><img src="https://example.com/rule.png" style="border-width: 1px; width: 600px; height: 1px">`,
+		},
+		{
+			`<p>Hello</p><img src="https://track.example.com/o" style="border-width:0; width:1px; min-height:0;height:1px">`,
+			`<p>Hello</p>`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		email := email{HTMLBody: testCase.htmlBody}
+		email.stripTrackingPixels()
+		if email.HTMLBody != testCase.expected {
+			t.Fatalf("expected %s, got %s", testCase.expected, email.HTMLBody)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package main
+
+import "log"
+
+// trackingIDHeader carries the tracking id generated for the queue message in
+// the email, so the bounce and complaint notifications can be traced back to it.
+const trackingIDHeader = "X-Mailer-Tracking-Id"
+
+// logSESMessageIDs logs the tracking id of the email paired with the SES
+// message id of every chunk sent.
+func (e *email) logSESMessageIDs(receipts []sendReceipt) {
+	for _, receipt := range receipts {
+		log.Printf("tracking_id=%s ses_message_id=%s\n", e.trackingID, receipt.messageID)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"github.com/streadway/amqp"
+	"log"
+	"net/mail"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestTrackingIDCorrelatesLogsWithSESMessageID(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	sender := &fakeSES{}
+	m := newTestMailer(&config{}, sender)
+	m.handle(amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body","type":"transactional"}`)})
+
+	ids := regexp.MustCompile(`tracking_id=([0-9a-f-]{36})`).FindAllStringSubmatch(logged.String(), -1)
+	if len(ids) < 3 {
+		t.Fatal("tracking id must be logged", logged.String())
+	}
+	trackingID := ids[0][1]
+	for _, id := range ids {
+		if id[1] != trackingID {
+			t.Fatal("tracking id must be the same in every log line", logged.String())
+		}
+	}
+	for _, line := range []string{"new email message: Wow", "email message successfully sent Wow"} {
+		if !strings.Contains(logged.String(), line) {
+			t.Fatal(line, "must be logged", logged.String())
+		}
+	}
+	if !strings.Contains(logged.String(), "tracking_id="+trackingID+" ses_message_id=message-1") {
+		t.Fatal("tracking id must be paired with the SES message id", logged.String())
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(sender.inputs[0].RawMessage.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Header.Get(trackingIDHeader) != trackingID {
+		t.Fatal("tracking id must be sent in the header", msg.Header)
+	}
+}
+
+func TestTrackingIDIsUniquePerMessage(t *testing.T) {
+	log.SetOutput(&bytes.Buffer{})
+	defer log.SetOutput(os.Stderr)
+
+	sender := &fakeSES{}
+	m := newTestMailer(&config{}, sender)
+	body := []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body"}`)
+	m.handle(amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: body})
+	m.handle(amqp.Delivery{Acknowledger: &fakeAcknowledger{}, Body: body})
+
+	var ids []string
+	for _, input := range sender.inputs {
+		msg, err := mail.ReadMessage(bytes.NewReader(input.RawMessage.Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, msg.Header.Get(trackingIDHeader))
+	}
+	if len(ids) != 2 || ids[0] == "" || ids[0] == ids[1] {
+		t.Fatal("every message must get its own tracking id", ids)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// errAttachmentChecksum is returned when a file of the shared volume doesn't
+// match the checksum of its attachment, the email is never sent.
+var errAttachmentChecksum = errors.New("checksum mismatch")
+
+// volumePath resolves the path of the attachment relative to the shared volume,
+// the paths leading out of the volume are refused.
+func volumePath(volume, path string) (string, error) {
+	if filepath.IsAbs(path) {
+		return "", fmt.Errorf(`"%s" must be relative to the attachment volume`, path)
+	}
+	resolved := filepath.Join(volume, path)
+	if !strings.HasPrefix(resolved, filepath.Clean(volume)+string(filepath.Separator)) {
+		return "", fmt.Errorf(`"%s" leads out of the attachment volume`, path)
+	}
+	return resolved, nil
+}
+
+// readVolumeAttachments reads the attachments given by path from the shared
+// volume and verifies them against their SHA-256 checksum. The files which
+// could not be read are reported as *os.PathError.
+func readVolumeAttachments(volume string, e *email) error {
+	for i, attach := range e.Attaches {
+		if len(attach.Path) == 0 {
+			continue
+		}
+		if len(volume) == 0 {
+			return fmt.Errorf(`attachment "%s" refers to a path but ATTACHMENT_VOLUME is not set`, attach.FileName)
+		}
+		if len(attach.SHA256) == 0 {
+			return fmt.Errorf(`attachment "%s" has no sha256 checksum`, attach.FileName)
+		}
+		path, err := volumePath(volume, attach.Path)
+		if err != nil {
+			return err
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(content)
+		if !strings.EqualFold(hex.EncodeToString(sum[:]), attach.SHA256) {
+			return fmt.Errorf(`attachment "%s": %w`, attach.FileName, errAttachmentChecksum)
+		}
+		e.Attaches[i].content = content
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/streadway/amqp"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// attachmentVolume creates a shared volume holding the file, it returns the
+// volume directory and the hex SHA256 checksum of the content.
+func attachmentVolume(t *testing.T, name, content string) (string, string) {
+	dir, err := ioutil.TempDir("", "volume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(content))
+	return dir, hex.EncodeToString(sum[:])
+}
+
+func TestVolumeAttachmentWithMatchingChecksum(t *testing.T) {
+	dir, checksum := attachmentVolume(t, "report.pdf", "%PDF-1.4")
+	defer os.RemoveAll(dir)
+	sender := &fakeSES{}
+	m := newTestMailer(&config{attachmentVolume: dir}, sender)
+	acknowledger := &fakeAcknowledger{}
+
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body","attaches":[{"file_name":"report.pdf","path":"report.pdf","sha256":"` + checksum + `"}]}`)})
+
+	if len(sender.inputs) != 1 || acknowledger.acked != 1 {
+		t.Fatal("email must be sent")
+	}
+	filenames := attachmentFilenames(t, sender.inputs[0].RawMessage.Data)
+	if len(filenames) != 1 || filenames[0] != "report.pdf" {
+		t.Fatal("volume file must be attached", filenames)
+	}
+}
+
+func TestVolumeAttachmentWithMismatchingChecksum(t *testing.T) {
+	dir, _ := attachmentVolume(t, "report.pdf", "%PDF-1.4")
+	defer os.RemoveAll(dir)
+	sender := &fakeSES{}
+	m := newTestMailer(&config{attachmentVolume: dir}, sender)
+	acknowledger := &fakeAcknowledger{}
+	checksum := sha256.Sum256([]byte("tampered"))
+
+	m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body","attaches":[{"file_name":"report.pdf","path":"report.pdf","sha256":"` + hex.EncodeToString(checksum[:]) + `"}]}`)})
+
+	if len(sender.inputs) != 0 {
+		t.Fatal("email must not be sent")
+	}
+	if acknowledger.nacked != 1 || acknowledger.requeued != 0 {
+		t.Fatal("email must be rejected without requeue", acknowledger)
+	}
+}
+
+func TestVolumeAttachmentOutsideVolume(t *testing.T) {
+	dir, checksum := attachmentVolume(t, "report.pdf", "%PDF-1.4")
+	defer os.RemoveAll(dir)
+
+	for _, path := range []string{"../report.pdf", "/etc/passwd", "a/../../report.pdf"} {
+		e := &email{Attaches: []emailAttach{{FileName: "report.pdf", Path: path, SHA256: checksum}}}
+		err := readVolumeAttachments(filepath.Join(dir, "sub"), e)
+		if err == nil || errors.Is(err, errAttachmentChecksum) {
+			t.Fatal(path, "must be refused", err)
+		}
+	}
+}
+
+func TestVolumeAttachmentRequiresChecksumAndVolume(t *testing.T) {
+	e := &email{Attaches: []emailAttach{{FileName: "report.pdf", Path: "report.pdf"}}}
+	if err := readVolumeAttachments("/mnt/shared", e); err == nil {
+		t.Fatal("attachment without checksum must be refused")
+	}
+	e.Attaches[0].SHA256 = "00"
+	if err := readVolumeAttachments("", e); err == nil {
+		t.Fatal("path must be refused without the volume")
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import "time"
+
+const warmUpDay = 24 * time.Hour
+
+// warmUpThrottle enforces the daily sending caps of a warm-up schedule.
+// Once the schedule is over, sending is not limited anymore.
+type warmUpThrottle struct {
+	schedule []int
+	start    time.Time
+	now      func() time.Time
+
+	day  int
+	sent int
+}
+
+func newWarmUpThrottle(schedule []int, start time.Time) *warmUpThrottle {
+	if len(schedule) == 0 {
+		return nil
+	}
+	return &warmUpThrottle{schedule: schedule, start: start, now: time.Now}
+}
+
+// reserve counts one email against the current daily cap. If the cap is
+// already reached, nothing is counted and the time left until the next day
+// of the schedule is returned.
+func (t *warmUpThrottle) reserve() time.Duration {
+	if t == nil {
+		return 0
+	}
+	now := t.now()
+	currentDay := int(now.Sub(t.start) / warmUpDay)
+	if currentDay < 0 || currentDay >= len(t.schedule) {
+		return 0
+	}
+	if currentDay != t.day {
+		t.day = currentDay
+		t.sent = 0
+	}
+	if t.sent >= t.schedule[currentDay] {
+		return t.start.Add(time.Duration(currentDay+1) * warmUpDay).Sub(now)
+	}
+	t.sent++
+	return 0
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"github.com/streadway/amqp"
+	"testing"
+	"time"
+)
+
+func TestWarmUpThrottle(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start.Add(10 * time.Hour)
+	throttle := newWarmUpThrottle([]int{2, 3}, start)
+	throttle.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if wait := throttle.reserve(); wait != 0 {
+			t.Fatalf("send %d on day 1 must be allowed, got wait %s", i, wait)
+		}
+	}
+	if wait := throttle.reserve(); wait != 14*time.Hour {
+		t.Fatalf("day 1 cap must be enforced until the next day, got wait %s", wait)
+	}
+
+	now = start.Add(warmUpDay + time.Hour)
+	for i := 0; i < 3; i++ {
+		if wait := throttle.reserve(); wait != 0 {
+			t.Fatalf("send %d on day 2 must be allowed, got wait %s", i, wait)
+		}
+	}
+	if wait := throttle.reserve(); wait != 23*time.Hour {
+		t.Fatalf("day 2 cap must be enforced until the next day, got wait %s", wait)
+	}
+
+	now = start.Add(2 * warmUpDay)
+	for i := 0; i < 10; i++ {
+		if wait := throttle.reserve(); wait != 0 {
+			t.Fatalf("sending must not be limited after the schedule, got wait %s", wait)
+		}
+	}
+}
+
+func TestNoWarmUpThrottleWithoutSchedule(t *testing.T) {
+	throttle := newWarmUpThrottle(nil, time.Now())
+	if wait := throttle.reserve(); wait != 0 {
+		t.Fatal("sending must not be limited without schedule", wait)
+	}
+}
+
+func TestParseWarmUpSchedule(t *testing.T) {
+	schedule, err := parseWarmUpSchedule("50, 100,200")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schedule) != 3 || schedule[0] != 50 || schedule[1] != 100 || schedule[2] != 200 {
+		t.Fatal("unexpected schedule", schedule)
+	}
+	if _, err := parseWarmUpSchedule("50,zero"); err == nil {
+		t.Fatal("invalid schedule must be rejected")
+	}
+}
+
+func TestEmailOverWarmUpCapIsDeferred(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	body := []byte(`{"to":"to@test.com","subject":"Wow","text_body":"text body"}`)
+	for _, cfg := range []*config{
+		{amqpQueue: "aws.ses.mailer", amqpDelayedExchange: "aws.ses.mailer.delayed"},
+		{amqpQueue: "aws.ses.mailer", amqpRetryDelay: time.Minute},
+	} {
+		sender := &fakeSES{}
+		m := newTestMailer(cfg, sender)
+		m.warmUp = newWarmUpThrottle([]int{0}, start)
+		m.warmUp.now = func() time.Time { return start.Add(10 * time.Hour) }
+		acknowledger := &fakeAcknowledger{}
+
+		m.handle(amqp.Delivery{Acknowledger: acknowledger, Body: body})
+
+		if len(sender.inputs) != 0 || acknowledger.acked != 1 {
+			t.Fatal("email over the cap must be handed back without sending", len(sender.inputs), acknowledger)
+		}
+		published := m.channel.(*fakeChannel).published
+		if len(published) != 1 || string(published[0].msg.Body) != string(body) {
+			t.Fatal("email must be deferred", published)
+		}
+		if cfg.amqpDelayedExchange != "" && (published[0].exchange != cfg.amqpDelayedExchange || published[0].msg.Headers["x-delay"] != int64(14*time.Hour/time.Millisecond)) {
+			t.Fatal("email must wait in the delayed exchange until the next day", published[0])
+		}
+		if cfg.amqpRetryDelay > 0 && (published[0].key != "aws.ses.mailer.retry" || published[0].msg.Expiration != "50400000" || published[0].msg.Headers[retryCountHeader] != nil) {
+			t.Fatal("email must wait in the retry queue without counting a retry", published[0])
+		}
+		if m.stats.report().Paused {
+			t.Fatal("consumer must not be reported paused after deferring", m.stats.report())
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	webhookTimeout     = 10 * time.Second
+	webhookQueueSize   = 1000
+	webhookMaxAttempts = 5
+	// webhookSignatureHeader carries "sha256=" and the hex HMAC-SHA256 of the body.
+	webhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// sendResult is the JSON POSTed to WEBHOOK_URL for every send attempt.
+type sendResult struct {
+	Outcome    string   `json:"outcome"`
+	MessageIDs []string `json:"message_ids,omitempty"`
+	Recipients []string `json:"recipients"`
+	Subject    string   `json:"subject"`
+	Error      string   `json:"error,omitempty"`
+	// CorrelationID is copied from the consumed message.
+	CorrelationID string `json:"correlation_id,omitempty"`
+}
+
+// webhookNotifier POSTs the send results in the background, retrying with a
+// doubling backoff, so a slow or failing webhook never holds the sends back.
+// The results are dropped when the queue is full.
+type webhookNotifier struct {
+	url     string
+	secret  []byte
+	client  *http.Client
+	backoff time.Duration
+	results chan sendResult
+}
+
+// newWebhookNotifier starts the notifier, it returns nil without the url.
+func newWebhookNotifier(url string, secret []byte) *webhookNotifier {
+	if url == "" {
+		return nil
+	}
+	w := &webhookNotifier{
+		url:     url,
+		secret:  secret,
+		client:  &http.Client{Timeout: webhookTimeout},
+		backoff: time.Second,
+		results: make(chan sendResult, webhookQueueSize),
+	}
+	go w.run()
+	return w
+}
+
+// notify queues the result without blocking.
+func (w *webhookNotifier) notify(result sendResult) {
+	if w == nil {
+		return
+	}
+	select {
+	case w.results <- result:
+	default:
+		log.Println("webhook queue is full, the result is dropped", result.Subject)
+	}
+}
+
+func (w *webhookNotifier) run() {
+	for result := range w.results {
+		body, err := json.Marshal(result)
+		if err != nil {
+			log.Println("webhook encoding err", err)
+			continue
+		}
+		backoff := w.backoff
+		for attempt := 1; ; attempt++ {
+			err = w.post(body)
+			if err == nil {
+				break
+			}
+			if attempt == webhookMaxAttempts {
+				log.Println("webhook err, the result is dropped", err)
+				break
+			}
+			log.Println("webhook err, retrying in", backoff, err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+func (w *webhookNotifier) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(w.secret) > 0 {
+		req.Header.Set(webhookSignatureHeader, "sha256="+webhookSignature(w.secret, body))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// webhookSignature is the hex HMAC-SHA256 of the body the receiver checks.
+func webhookSignature(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/streadway/amqp"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookReceivesSendResult(t *testing.T) {
+	type request struct {
+		body      []byte
+		signature string
+	}
+	requests := make(chan request, 1)
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			// the first attempt fails, the webhook must be retried
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- request{body, r.Header.Get(webhookSignatureHeader)}
+	}))
+	defer server.Close()
+	m := newTestMailer(&config{}, &fakeSES{})
+	m.webhook = newWebhookNotifier(server.URL, []byte("s3cr3t"))
+	m.webhook.backoff = time.Millisecond
+
+	m.handle(amqp.Delivery{Acknowledger: &fakeAcknowledger{}, CorrelationId: "order-42", Body: []byte(`{"to":"to@test.com","cc":"cc@test.com","subject":"Wow","text_body":"text body"}`)})
+
+	var received request
+	select {
+	case received = <-requests:
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook must receive the result")
+	}
+	if received.signature != "sha256="+webhookSignature([]byte("s3cr3t"), received.body) {
+		t.Fatal("webhook body must be signed", received.signature)
+	}
+	var result sendResult
+	if err := json.Unmarshal(received.body, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Outcome != statusSent || len(result.MessageIDs) != 1 || result.MessageIDs[0] != "message-1" ||
+		len(result.Recipients) != 2 || result.Subject != "Wow" || result.CorrelationID != "order-42" || result.Error != "" {
+		t.Fatal("webhook payload", string(received.body))
+	}
+}
+
+func TestDisabledWebhook(t *testing.T) {
+	var w *webhookNotifier
+	w.notify(sendResult{})
+	if newWebhookNotifier("", nil) != nil {
+		t.Fatal("webhook without url must be disabled")
+	}
+}